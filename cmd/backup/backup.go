@@ -0,0 +1,54 @@
+package backup
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/backup"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+// Command writes a snapshot of the store which isn't served. Use POST /admin/backup on a running server
+type Command struct {
+	Out string `long:"out" env:"CHECKER_BACKUP_OUT" required:"true" description:"snapshot file"`
+
+	cmd.CommonOpts
+}
+
+// Execute command writes the snapshot
+func (c *Command) Execute(args []string) error {
+	boltDB, err := record.NewBoltDB(c.BoltDBName, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", c.BoltDBName)
+	}
+	defer boltDB.Close()
+
+	meta, err := backup.Create(context.Background(), boltDB, c.Out, c.Revision)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] backup %s of %d users and %d user IPs is written", c.Out, meta.Users, meta.UserIPs)
+	return nil
+}
+
+// RestoreCommand replaces the store with a validated snapshot
+type RestoreCommand struct {
+	From string `long:"from" env:"CHECKER_RESTORE_FROM" required:"true" description:"snapshot file written by backup"`
+
+	cmd.CommonOpts
+}
+
+// Execute command validates the snapshot and replaces the store file with it
+func (c *RestoreCommand) Execute(args []string) error {
+	meta, err := backup.Restore(context.Background(), c.From, c.BoltDBName)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] %s is restored from %s of revision %s created at %s, %d users and %d user IPs",
+		c.BoltDBName, c.From, meta.Revision, meta.CreatedAt.Format(time.RFC3339), meta.Users, meta.UserIPs)
+	return nil
+}
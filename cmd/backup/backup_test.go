@@ -0,0 +1,46 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testDb     = "/tmp/test_backup_cmd.db"
+	testBackup = "/tmp/test_backup_cmd_snapshot.db"
+)
+
+func TestBackupRestore(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+	defer os.Remove(testBackup)
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	repo, err := record.NewBoltRepository(db)
+	require.NoError(t, err)
+	require.NoError(t, repo.AddRecord(context.Background(), record.NewRecord(1, "1.1.1.1")))
+	require.NoError(t, db.Close())
+
+	common := cmd.CommonOpts{BoltDBName: testDb, Revision: "rev1"}
+	require.NoError(t, (&Command{Out: testBackup, CommonOpts: common}).Execute(nil))
+
+	require.NoError(t, os.Remove(testDb))
+	require.NoError(t, (&RestoreCommand{From: testBackup, CommonOpts: common}).Execute(nil))
+
+	db, err = record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	repo, err = record.OpenBoltRepository(db)
+	require.NoError(t, err)
+	info, err := repo.GetUserInfo(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Len(t, info.IPs, 1)
+
+	assert.Error(t, (&RestoreCommand{From: testDb, CommonOpts: common}).Execute(nil), "db is locked")
+}
@@ -0,0 +1,256 @@
+package check
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+// output formats
+const (
+	formatText = "text"
+	formatJSON = "json"
+	formatCSV  = "csv"
+)
+
+// Command checks pairs of users against the store without starting the server
+type Command struct {
+	Pairs   string `long:"pairs" env:"CHECKER_CHECK_PAIRS" description:"file with user_id_1,user_id_2 lines, - for stdin"`
+	Format  string `long:"format" env:"CHECKER_CHECK_FORMAT" choice:"text" choice:"json" choice:"csv" default:"text" description:"output format, json is a JSON object per line"`
+	Explain bool   `long:"explain" description:"print common IPs of every pair"`
+	Out     string `long:"out" default:"-" description:"output file, - for stdout"`
+	Tenant  string `long:"tenant" env:"CHECKER_CHECK_TENANT" description:"tenant whose users are checked by its rules of --tenants file. Empty is the default tenant"`
+
+	Args struct {
+		U1 record.UserID `positional-arg-name:"user_id_1"`
+		U2 record.UserID `positional-arg-name:"user_id_2"`
+	} `positional-args:"yes"`
+
+	cmd.RulesOpts
+	cmd.TenantOpts
+	cmd.CommonOpts
+}
+
+type pair struct {
+	u1, u2 record.UserID
+}
+
+// Execute command checks requested pairs and prints results
+func (c *Command) Execute(args []string) error {
+	if (c.Pairs == "") == (c.Args.U2 == 0) {
+		return errors.New("either two user ids or --pairs file are required")
+	}
+	// rules are resolved as the server resolves them, so the check gives the same answers
+	rules, err := c.Rules()
+	if err != nil {
+		return err
+	}
+	if rules, err = c.TenantRules(record.Tenant(c.Tenant), rules); err != nil {
+		return err
+	}
+
+	boltDB, err := record.NewBoltDB(c.BoltDBName, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", c.BoltDBName)
+	}
+	defer boltDB.Close()
+	recordRepo, err := record.OpenTenantRepository(boltDB, record.Tenant(c.Tenant))
+	if err != nil {
+		return err
+	}
+
+	pairs, err := c.pairs()
+	if err != nil {
+		return err
+	}
+	out, err := cmd.CreateOutput(c.Out, false)
+	if err != nil {
+		return err
+	}
+	err = c.check(context.Background(), record.NewServiceWithRules(recordRepo, rules), pairs, out)
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// pairs returns the pair from positional arguments or reads --pairs file
+func (c *Command) pairs() ([]pair, error) {
+	if c.Pairs == "" {
+		return []pair{{c.Args.U1, c.Args.U2}}, nil
+	}
+	if c.Pairs == "-" {
+		return readPairs(os.Stdin)
+	}
+	f, err := os.Open(c.Pairs)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readPairs(f)
+}
+
+// readPairs parses user_id_1,user_id_2 lines skipping empty lines, comments and a header
+func readPairs(r io.Reader) ([]pair, error) {
+	var pairs []pair
+	scanner := bufio.NewScanner(r)
+	var n int
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || (n == 1 && (line[0] < '0' || line[0] > '9')) {
+			continue
+		}
+		parts := strings.Split(line, ",")
+		if len(parts) != 2 {
+			return nil, errors.Errorf("line %d: expected `user_id_1,user_id_2`, got %q", n, line)
+		}
+		u1, err1 := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 32)
+		u2, err2 := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 32)
+		if err1 != nil || err2 != nil {
+			return nil, errors.Errorf("line %d: invalid user id in %q", n, line)
+		}
+		pairs = append(pairs, pair{record.UserID(u1), record.UserID(u2)})
+	}
+	return pairs, scanner.Err()
+}
+
+// check writes results of all pairs in the requested format
+func (c *Command) check(ctx context.Context, service record.Service, pairs []pair, w io.Writer) error {
+	rw := c.resultWriter(w)
+	for _, p := range pairs {
+		e, err := service.Explain(ctx, p.u1, p.u2)
+		if err != nil {
+			return errors.Wrapf(err, "failed to check %d,%d", p.u1, p.u2)
+		}
+		if err := rw.write(p, e); err != nil {
+			return err
+		}
+	}
+	if err := rw.flush(); err != nil {
+		return err
+	}
+	log.Printf("[INFO] %d pairs checked", len(pairs))
+	return nil
+}
+
+type resultWriter interface {
+	write(p pair, e *record.Explanation) error
+	flush() error
+}
+
+func (c *Command) resultWriter(w io.Writer) resultWriter {
+	switch c.Format {
+	case formatJSON:
+		return &jsonWriter{enc: json.NewEncoder(w), explain: c.Explain}
+	case formatCSV:
+		return &csvWriter{w: csv.NewWriter(w), explain: c.Explain}
+	default:
+		return &textWriter{w: w, explain: c.Explain}
+	}
+}
+
+type textWriter struct {
+	w       io.Writer
+	explain bool
+}
+
+func (t *textWriter) write(p pair, e *record.Explanation) error {
+	verdict := "dupes"
+	if !e.Dupes {
+		verdict = "not dupes"
+	}
+	if !t.explain {
+		_, err := fmt.Fprintf(t.w, "%d %d: %s\n", p.u1, p.u2, verdict)
+		return err
+	}
+	_, err := fmt.Fprintf(t.w, "%d %d: %s, %d of %d required common IPs", p.u1, p.u2, verdict, len(e.CommonIPs), e.Required)
+	if err == nil && len(e.CommonIPs) > 0 {
+		_, err = fmt.Fprintf(t.w, ": %s", joinIPs(e, ", "))
+	}
+	if err == nil {
+		_, err = io.WriteString(t.w, "\n")
+	}
+	return err
+}
+
+func (t *textWriter) flush() error {
+	return nil
+}
+
+type jsonWriter struct {
+	enc     *json.Encoder
+	explain bool
+}
+
+type jsonResult struct {
+	U1        record.UserID `json:"user_id_1"`
+	U2        record.UserID `json:"user_id_2"`
+	Dupes     bool          `json:"dupes"`
+	CommonIPs []string      `json:"common_ips,omitempty"`
+}
+
+func (j *jsonWriter) write(p pair, e *record.Explanation) error {
+	res := jsonResult{U1: p.u1, U2: p.u2, Dupes: e.Dupes}
+	if j.explain {
+		res.CommonIPs = make([]string, len(e.CommonIPs))
+		for i, ip := range e.CommonIPs {
+			res.CommonIPs[i] = ip.String()
+		}
+	}
+	return j.enc.Encode(res)
+}
+
+func (j *jsonWriter) flush() error {
+	return nil
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	explain bool
+	header  bool
+}
+
+func (c *csvWriter) write(p pair, e *record.Explanation) error {
+	if !c.header {
+		c.header = true
+		header := []string{"user_id_1", "user_id_2", "dupes"}
+		if c.explain {
+			header = append(header, "common_ips")
+		}
+		if err := c.w.Write(header); err != nil {
+			return err
+		}
+	}
+	row := []string{strconv.Itoa(int(p.u1)), strconv.Itoa(int(p.u2)), strconv.FormatBool(e.Dupes)}
+	if c.explain {
+		row = append(row, joinIPs(e, " "))
+	}
+	return c.w.Write(row)
+}
+
+func (c *csvWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func joinIPs(e *record.Explanation, sep string) string {
+	ips := make([]string, len(e.CommonIPs))
+	for i, ip := range e.CommonIPs {
+		ips[i] = ip.String()
+	}
+	return strings.Join(ips, sep)
+}
@@ -0,0 +1,142 @@
+package check
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDb = "/tmp/test_check.db"
+
+func TestCheck_Formats(t *testing.T) {
+	service, teardown := prepService(t)
+	defer teardown()
+	pairs := []pair{{1, 2}, {1, 3}}
+	cases := []struct {
+		format  string
+		explain bool
+		out     string
+	}{
+		{formatText, false, "1 2: dupes\n1 3: not dupes\n"},
+		{formatText, true, "1 2: dupes, 2 of 2 required common IPs: 1.1.1.1, 2.2.2.2\n1 3: not dupes, 0 of 2 required common IPs\n"},
+		{formatJSON, false, `{"user_id_1":1,"user_id_2":2,"dupes":true}` + "\n" + `{"user_id_1":1,"user_id_2":3,"dupes":false}` + "\n"},
+		{formatJSON, true, `{"user_id_1":1,"user_id_2":2,"dupes":true,"common_ips":["1.1.1.1","2.2.2.2"]}` + "\n" + `{"user_id_1":1,"user_id_2":3,"dupes":false}` + "\n"},
+		{formatCSV, false, "user_id_1,user_id_2,dupes\n1,2,true\n1,3,false\n"},
+		{formatCSV, true, "user_id_1,user_id_2,dupes,common_ips\n1,2,true,1.1.1.1 2.2.2.2\n1,3,false,\n"},
+	}
+	for _, tc := range cases {
+		c := &Command{Format: tc.format, Explain: tc.explain}
+		var buf bytes.Buffer
+		require.NoError(t, c.check(context.Background(), service, pairs, &buf))
+		assert.Equal(t, tc.out, buf.String(), "%s explain: %t", tc.format, tc.explain)
+	}
+}
+
+func TestReadPairs(t *testing.T) {
+	pairs, err := readPairs(strings.NewReader("user_id_1,user_id_2\n1,2\n\n# comment\n3, 4\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []pair{{1, 2}, {3, 4}}, pairs)
+
+	_, err = readPairs(strings.NewReader("1,2,3\n"))
+	assert.Error(t, err)
+	_, err = readPairs(strings.NewReader("1,x\n"))
+	assert.Error(t, err)
+}
+
+func TestExecute(t *testing.T) {
+	_, teardown := prepService(t)
+	defer teardown()
+	out := "/tmp/test_check.out"
+	defer os.Remove(out)
+
+	c := &Command{Format: formatText, Out: out, RulesOpts: cmd.RulesOpts{CommonIPs: 2}, CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
+	assert.Error(t, c.Execute(nil), "pair is required")
+
+	c.Args.U1, c.Args.U2 = 2, 1
+	require.NoError(t, c.Execute(nil))
+	res, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "2 1: dupes\n", string(res))
+
+	c.Tenant = "acme"
+	assert.Equal(t, record.ErrUnknownTenant, errors.Cause(c.Execute(nil)))
+}
+
+func TestExecute_TenantRules(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	for _, tenant := range []record.Tenant{record.DefaultTenant, "acme"} {
+		repo, err := record.NewTenantRepository(db, tenant)
+		require.NoError(t, err)
+		require.NoError(t, repo.BulkAddRecords(context.Background(), []*record.Record{
+			record.NewRecord(1, "1.1.1.1"), record.NewRecord(2, "1.1.1.1"),
+		}))
+	}
+	require.NoError(t, db.Close())
+	out, tenants := "/tmp/test_check.out", "/tmp/test_check_tenants.json"
+	defer os.Remove(out)
+	defer os.Remove(tenants)
+	require.NoError(t, ioutil.WriteFile(tenants, []byte(`{"acme": {"common_ips": 1}}`), 0600))
+
+	// rules are taken from --tenants file the server is run with
+	c := &Command{Format: formatText, Out: out, RulesOpts: cmd.RulesOpts{CommonIPs: 2}, CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
+	c.Args.U1, c.Args.U2 = 1, 2
+	for _, tc := range []struct {
+		tenant, tenants string
+		res             string
+	}{
+		{"", tenants, "1 2: not dupes\n"},
+		{"acme", "", "1 2: not dupes\n"},
+		{"acme", tenants, "1 2: dupes\n"},
+	} {
+		c.Tenant, c.Tenants = tc.tenant, tc.tenants
+		require.NoError(t, c.Execute(nil))
+		res, err := ioutil.ReadFile(out)
+		require.NoError(t, err)
+		assert.Equal(t, tc.res, string(res), "tenant %q, tenants %q", tc.tenant, tc.tenants)
+	}
+
+	c.CommonIPs = 0
+	assert.EqualError(t, c.Execute(nil), "common IPs should be positive, got 0")
+	c.CommonIPs = 2
+	require.NoError(t, ioutil.WriteFile(tenants, []byte(`{"beta": {}}`), 0600))
+	assert.EqualError(t, c.Execute(nil), "tenant acme isn't listed in /tmp/test_check_tenants.json")
+}
+
+func prepService(t *testing.T) (service record.Service, teardown func()) {
+	_ = os.Remove(testDb)
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	repo, err := record.NewBoltRepository(db)
+	require.NoError(t, err)
+	require.NoError(t, repo.BulkAddRecords(context.Background(), []*record.Record{
+		record.NewRecord(1, "1.1.1.1"), record.NewRecord(1, "2.2.2.2"),
+		record.NewRecord(2, "1.1.1.1"), record.NewRecord(2, "2.2.2.2"),
+		record.NewRecord(3, "3.3.3.3"),
+	}))
+	require.NoError(t, db.Close())
+
+	// the command opens the store read-only, so the test opens it the same way not to block it
+	db, err = record.NewBoltDB(testDb, &bolt.Options{ReadOnly: true})
+	require.NoError(t, err)
+	repo, err = record.OpenBoltRepository(db)
+	require.NoError(t, err)
+
+	teardown = func() {
+		assert.NoError(t, db.Close())
+		_ = os.Remove(testDb)
+	}
+	return record.NewService(repo), teardown
+}
@@ -20,3 +20,13 @@ func (c *CommonOpts) SetCommon(commonOpts CommonOpts) {
 	c.BoltDBName = commonOpts.BoltDBName
 	c.Dbg = commonOpts.Dbg
 }
+
+// ExitError is returned by commands which exit with a specific code
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
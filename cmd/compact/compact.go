@@ -0,0 +1,38 @@
+package compact
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/compact"
+)
+
+// Command copies the store to a new file reclaiming free pages and replaces it
+type Command struct {
+	FillPercent float64 `long:"fill_percent" env:"CHECKER_COMPACT_FILL_PERCENT" default:"0.5" description:"fill percent of pages of the compacted file, use 1 for a store which won't be written"`
+
+	cmd.CommonOpts
+}
+
+// Execute command compacts the store
+func (c *Command) Execute(args []string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		log.Printf("[WARN] interrupt signal")
+		cancel()
+	}()
+
+	res, err := compact.File(ctx, c.BoltDBName, c.FillPercent)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] %s is compacted from %d to %d bytes in %s", res.Path, res.SizeBefore, res.SizeAfter, res.Elapsed)
+	return nil
+}
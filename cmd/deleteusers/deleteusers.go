@@ -0,0 +1,104 @@
+package deleteusers
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+// Command erases data of users listed in a file
+type Command struct {
+	File      string `long:"file" env:"CHECKER_DELETE_FILE" required:"true" description:"file with a user id per line, - for stdin"`
+	Ref       string `long:"ref" env:"CHECKER_DELETE_REF" required:"true" description:"erasure request reference recorded in the audit instead of user ids"`
+	BatchSize int    `long:"batch_size" env:"CHECKER_DELETE_BATCH_SIZE" default:"10000" description:"users deleted in a single transaction, every batch is audited"`
+	Tenant    string `long:"tenant" env:"CHECKER_DELETE_TENANT" description:"tenant whose users are deleted, it should exist. Empty is the default tenant"`
+
+	cmd.CommonOpts
+}
+
+// Execute command deletes users in batches
+func (c *Command) Execute(args []string) error {
+	if c.BatchSize < 1 {
+		return errors.Errorf("batch size should be positive, got %d", c.BatchSize)
+	}
+	ids, err := c.ids()
+	if err != nil {
+		return err
+	}
+
+	boltDB, err := record.NewBoltDB(c.BoltDBName, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", c.BoltDBName)
+	}
+	defer boltDB.Close()
+	recordRepo, err := record.OpenTenantRepository(boltDB, record.Tenant(c.Tenant))
+	if err != nil {
+		return err
+	}
+
+	deleted, err := deleteUsers(context.Background(), recordRepo, ids, c.Ref, c.BatchSize)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] %d of %d users are deleted, ref %s", deleted, len(ids), c.Ref)
+	return nil
+}
+
+// ids reads --file
+func (c *Command) ids() ([]record.UserID, error) {
+	if c.File == "-" {
+		return readIDs(os.Stdin)
+	}
+	f, err := os.Open(c.File)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readIDs(f)
+}
+
+// readIDs parses user id lines skipping empty lines, comments and a header
+func readIDs(r io.Reader) ([]record.UserID, error) {
+	var ids []record.UserID
+	scanner := bufio.NewScanner(r)
+	var n int
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || (n == 1 && (line[0] < '0' || line[0] > '9')) {
+			continue
+		}
+		id, err := strconv.ParseUint(line, 10, 32)
+		if err != nil || id == 0 {
+			return nil, errors.Errorf("line %d: invalid user id %q", n, line)
+		}
+		ids = append(ids, record.UserID(id))
+	}
+	return ids, scanner.Err()
+}
+
+func deleteUsers(ctx context.Context, repo record.Repository, ids []record.UserID, ref string, batchSize int) (int, error) {
+	var deleted int
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		n, err := repo.DeleteUsers(ctx, ids[start:end], ref)
+		if err != nil {
+			return deleted, errors.Wrapf(err, "failed to delete users %d-%d of the file", start+1, end)
+		}
+		deleted += n
+	}
+	return deleted, nil
+}
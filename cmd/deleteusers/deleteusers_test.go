@@ -0,0 +1,97 @@
+package deleteusers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testDb  = "/tmp/test_delete.db"
+	testIDs = "/tmp/test_delete_ids.txt"
+)
+
+func TestExecute(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+	defer os.Remove(testIDs)
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	repo, err := record.NewBoltRepository(db)
+	require.NoError(t, err)
+	require.NoError(t, repo.BulkAddRecords(context.Background(), []*record.Record{
+		record.NewRecord(1, "1.1.1.1"), record.NewRecord(2, "1.1.1.1"), record.NewRecord(3, "3.3.3.3"),
+	}))
+	require.NoError(t, db.Close())
+	require.NoError(t, ioutil.WriteFile(testIDs, []byte("user_id\n1\n\n3\n4\n"), 0600))
+
+	c := &Command{File: testIDs, Ref: "ticket-1", BatchSize: 2, CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
+	require.NoError(t, c.Execute(nil))
+
+	db, err = record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	repo, err = record.OpenBoltRepository(db)
+	require.NoError(t, err)
+	for uID, n := range map[record.UserID]int{1: 0, 2: 1, 3: 0} {
+		info, err := repo.GetUserInfo(context.Background(), uID)
+		require.NoError(t, err)
+		assert.Len(t, info.IPs, n, "user %d", uID)
+	}
+
+	entries, err := record.Audit(db)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "ticket-1", entries[0].Ref)
+	assert.Equal(t, 2, entries[0].Deleted)
+	assert.Equal(t, 1, entries[1].Requested)
+}
+
+func TestExecute_Tenant(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+	defer os.Remove(testIDs)
+	ctx := context.Background()
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	for _, tenant := range []record.Tenant{record.DefaultTenant, "acme"} {
+		repo, err := record.NewTenantRepository(db, tenant)
+		require.NoError(t, err)
+		require.NoError(t, repo.BulkAddRecords(ctx, []*record.Record{record.NewRecord(1, "1.1.1.1")}))
+	}
+	require.NoError(t, db.Close())
+	require.NoError(t, ioutil.WriteFile(testIDs, []byte("1\n"), 0600))
+
+	c := &Command{File: testIDs, Ref: "ticket-1", BatchSize: 2, Tenant: "acme", CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
+	require.NoError(t, c.Execute(nil))
+	c.Tenant = "beta"
+	assert.Equal(t, record.ErrUnknownTenant, errors.Cause(c.Execute(nil)))
+
+	db, err = record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	for tenant, n := range map[record.Tenant]int{record.DefaultTenant: 1, "acme": 0} {
+		repo, err := record.OpenTenantRepository(db, tenant)
+		require.NoError(t, err)
+		info, err := repo.GetUserInfo(ctx, 1)
+		require.NoError(t, err)
+		assert.Len(t, info.IPs, n, "tenant %q", tenant)
+	}
+}
+
+func TestReadIDs(t *testing.T) {
+	ids, err := readIDs(strings.NewReader("user_id\n1\n# comment\n 2 \n"))
+	require.NoError(t, err)
+	assert.Equal(t, []record.UserID{1, 2}, ids)
+
+	_, err = readIDs(strings.NewReader("1\n0\n"))
+	assert.EqualError(t, err, `line 2: invalid user id "0"`)
+}
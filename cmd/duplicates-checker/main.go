@@ -1,29 +1,57 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/cmd/backup"
+	"github.com/mullakhmetov/duplicates-checker/cmd/check"
+	"github.com/mullakhmetov/duplicates-checker/cmd/compact"
+	"github.com/mullakhmetov/duplicates-checker/cmd/deleteusers"
+	"github.com/mullakhmetov/duplicates-checker/cmd/export"
+	"github.com/mullakhmetov/duplicates-checker/cmd/generate"
 	"github.com/mullakhmetov/duplicates-checker/cmd/importer"
+	"github.com/mullakhmetov/duplicates-checker/cmd/index"
+	"github.com/mullakhmetov/duplicates-checker/cmd/ingest"
+	"github.com/mullakhmetov/duplicates-checker/cmd/migrate"
+	"github.com/mullakhmetov/duplicates-checker/cmd/purge"
 	"github.com/mullakhmetov/duplicates-checker/cmd/rest"
+	"github.com/mullakhmetov/duplicates-checker/cmd/stats"
+	"github.com/mullakhmetov/duplicates-checker/cmd/verify"
 )
 
 // sets at buildtime via ldflags
 var revision = "unknown"
 
 type opts struct {
-	Rest     rest.Command     `command:"server" description:"Starts REST server"`
-	Importer importer.Command `command:"import" description:"Starts randomly generated dataset loading. See import command help for details"`
+	Rest     rest.Command          `command:"server" description:"Starts REST server"`
+	Importer importer.Command      `command:"import" description:"Starts randomly generated dataset loading. See import command help for details"`
+	Generate generate.Command      `command:"generate" description:"Writes randomly generated dataset to csv file or Postgres COPY stream"`
+	Ingest   ingest.Command        `command:"ingest" description:"Starts syslog UDP and TCP listeners loading received access records"`
+	Run      rest.RunCommand       `command:"run" description:"Starts REST server importing records in the same process"`
+	Check    check.Command         `command:"check" description:"Checks pairs of users against the store without starting the server"`
+	Stats    stats.Command         `command:"stats" description:"Prints statistics of users of one tenant, the default one unless --tenant is set"`
+	Export   export.Command        `command:"export" description:"Writes aggregated users' IPs from the store to csv or ndjson file"`
+	Backup   backup.Command        `command:"backup" description:"Writes a consistent snapshot of the store with users of every tenant"`
+	Restore  backup.RestoreCommand `command:"restore" description:"Validates users of every tenant of a snapshot and replaces the store with it"`
+	Compact  compact.Command       `command:"compact" description:"Copies the store to a new file reclaiming free space and replaces it"`
+	Migrate  migrate.Command       `command:"migrate" description:"Upgrades the store layout to the version of this build"`
+	Delete   deleteusers.Command   `command:"delete-users" description:"Erases data of users listed in a file recording an audit entry"`
+	Purge    purge.Command         `command:"purge" description:"Removes users' IPs not seen for longer than --older_than"`
+	Index    index.Command         `command:"index" description:"Manages immutable index files served by mapping them into memory"`
+	Verify   verify.Command        `command:"verify-db" description:"Checks integrity of the store and optionally repairs it. Exits with 2 if it's repaired, 4 if it's broken and 8 if it can't be checked"`
 
 	BoltDBName string `long:"boltdbname" env:"CHECKER_BOLT_DB_NAME" default:"my.db" description:"boltdb db name"`
 	Dbg        bool   `long:"dbg" env:"DEBUG" description:"debug mode"`
 }
 
 func main() {
-	fmt.Printf("duplicates-checker revision: %s\n", revision)
+	// stdout may be taken by command's output
+	fmt.Fprintf(os.Stderr, "duplicates-checker revision: %s\n", revision)
 
 	// parse args and decide what should we do
 	var opts opts
@@ -44,8 +72,11 @@ func main() {
 
 	// unknown command
 	if _, err := p.Parse(); err != nil {
+		var exitErr *cmd.ExitError
 		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
 			os.Exit(0)
+		} else if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
 		} else {
 			os.Exit(1)
 		}
@@ -0,0 +1,141 @@
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+// output formats
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// Command writes aggregated users' IPs from the store to a file
+type Command struct {
+	Out    string        `long:"out" env:"CHECKER_EXPORT_OUT" default:"-" description:"output file, - for stdout"`
+	Format string        `long:"format" env:"CHECKER_EXPORT_FORMAT" choice:"csv" choice:"ndjson" default:"csv" description:"csv with user_id,ips lines, IPs are space separated, or a JSON object per line"`
+	Gzip   bool          `long:"gzip" env:"CHECKER_EXPORT_GZIP" description:"gzip output"`
+	From   record.UserID `long:"from" description:"first exported user id"`
+	To     record.UserID `long:"to" description:"last exported user id, 0 exports up to the last user"`
+	Tenant string        `long:"tenant" env:"CHECKER_EXPORT_TENANT" description:"tenant whose users are exported. Empty is the default tenant"`
+
+	cmd.CommonOpts
+}
+
+// Execute command streams users from the store to the output
+func (c *Command) Execute(args []string) error {
+	if c.To != 0 && c.To < c.From {
+		return errors.Errorf("--to %d is less than --from %d", c.To, c.From)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		log.Printf("[WARN] interrupt signal")
+		cancel()
+	}()
+
+	boltDB, err := record.NewBoltDB(c.BoltDBName, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", c.BoltDBName)
+	}
+	defer boltDB.Close()
+	recordRepo, err := record.OpenTenantRepository(boltDB, record.Tenant(c.Tenant))
+	if err != nil {
+		return err
+	}
+
+	out, err := cmd.CreateOutput(c.Out, c.Gzip)
+	if err != nil {
+		return err
+	}
+	n, err := c.export(ctx, recordRepo, out)
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] %d users exported to %s", n, c.Out)
+	return nil
+}
+
+// export writes users of the requested range in the requested format, returns number of written users
+func (c *Command) export(ctx context.Context, repo record.Repository, w io.Writer) (n uint64, err error) {
+	uw := c.userWriter(w)
+	err = repo.ForEachUserInRange(ctx, c.From, c.To, func(info *record.UserInfo) error {
+		n++
+		return uw.write(info)
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, uw.flush()
+}
+
+type userWriter interface {
+	write(info *record.UserInfo) error
+	flush() error
+}
+
+func (c *Command) userWriter(w io.Writer) userWriter {
+	if c.Format == formatNDJSON {
+		return &jsonWriter{enc: json.NewEncoder(w)}
+	}
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+type jsonWriter struct {
+	enc *json.Encoder
+}
+
+type jsonUser struct {
+	UserID record.UserID `json:"user_id"`
+	IPs    []string      `json:"ips"`
+}
+
+func (j *jsonWriter) write(info *record.UserInfo) error {
+	return j.enc.Encode(jsonUser{UserID: info.UserID, IPs: info.IPs.Strings()})
+}
+
+func (j *jsonWriter) flush() error {
+	return nil
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (c *csvWriter) write(info *record.UserInfo) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write([]string{"user_id", "ips"}); err != nil {
+			return err
+		}
+	}
+	return c.w.Write([]string{strconv.Itoa(int(info.UserID)), strings.Join(info.IPs.Strings(), " ")})
+}
+
+func (c *csvWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,109 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDb = "/tmp/test_export.db"
+
+func TestExport_Formats(t *testing.T) {
+	repo, teardown := prepRepo(t)
+	defer teardown()
+	cases := []struct {
+		format   string
+		from, to record.UserID
+		out      string
+	}{
+		{formatCSV, 0, 0, "user_id,ips\n1,1.1.1.1 2.2.2.2\n2,1.1.1.1\n3,3.3.3.3\n"},
+		{formatCSV, 2, 0, "user_id,ips\n2,1.1.1.1\n3,3.3.3.3\n"},
+		{formatCSV, 1, 2, "user_id,ips\n1,1.1.1.1 2.2.2.2\n2,1.1.1.1\n"},
+		{formatCSV, 4, 0, ""},
+		{formatNDJSON, 0, 1, `{"user_id":1,"ips":["1.1.1.1","2.2.2.2"]}` + "\n"},
+	}
+	for _, tc := range cases {
+		c := &Command{Format: tc.format, From: tc.from, To: tc.to}
+		var buf bytes.Buffer
+		_, err := c.export(context.Background(), repo, &buf)
+		require.NoError(t, err)
+		assert.Equal(t, tc.out, buf.String(), "%s from %d to %d", tc.format, tc.from, tc.to)
+	}
+}
+
+func TestExecute(t *testing.T) {
+	_, teardown := prepRepo(t)
+	defer teardown()
+	out := "/tmp/test_export.csv.gz"
+	defer os.Remove(out)
+
+	c := &Command{Format: formatCSV, Out: out, Gzip: true, To: 2, CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
+	require.NoError(t, c.Execute(nil))
+
+	f, err := os.Open(out)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	res, err := ioutil.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, "user_id,ips\n1,1.1.1.1 2.2.2.2\n2,1.1.1.1\n", string(res))
+
+	c.From, c.To = 3, 2
+	assert.Error(t, c.Execute(nil))
+}
+
+func TestExecute_Tenant(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	_, err = record.NewBoltRepository(db)
+	require.NoError(t, err)
+	repo, err := record.NewTenantRepository(db, "acme")
+	require.NoError(t, err)
+	require.NoError(t, repo.BulkAddRecords(context.Background(), []*record.Record{record.NewRecord(7, "7.7.7.7")}))
+	require.NoError(t, db.Close())
+	out := "/tmp/test_export.csv"
+	defer os.Remove(out)
+
+	c := &Command{Format: formatCSV, Out: out, Tenant: "acme", CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
+	require.NoError(t, c.Execute(nil))
+	res, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "user_id,ips\n7,7.7.7.7\n", string(res))
+}
+
+func prepRepo(t *testing.T) (record.Repository, func()) {
+	_ = os.Remove(testDb)
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	repo, err := record.NewBoltRepository(db)
+	require.NoError(t, err)
+	require.NoError(t, repo.BulkAddRecords(context.Background(), []*record.Record{
+		record.NewRecord(1, "1.1.1.1"), record.NewRecord(1, "2.2.2.2"),
+		record.NewRecord(2, "1.1.1.1"), record.NewRecord(3, "3.3.3.3"),
+	}))
+	require.NoError(t, db.Close())
+
+	// the command opens the store read-only, so the test opens it the same way not to block it
+	db, err = record.NewBoltDB(testDb, &bolt.Options{ReadOnly: true})
+	require.NoError(t, err)
+	repo, err = record.OpenBoltRepository(db)
+	require.NoError(t, err)
+
+	teardown := func() {
+		assert.NoError(t, db.Close())
+		_ = os.Remove(testDb)
+	}
+	return repo, teardown
+}
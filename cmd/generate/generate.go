@@ -0,0 +1,121 @@
+package generate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/generator"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+)
+
+// output formats
+const (
+	formatCSV  = "csv"
+	formatCopy = "copy"
+)
+
+// Command writes generated dataset to a file instead of loading it to the store
+type Command struct {
+	Out    string `long:"out" env:"CHECKER_GENERATE_OUT" default:"conn_log.csv" description:"output file, - for stdout"`
+	Format string `long:"format" env:"CHECKER_GENERATE_FORMAT" choice:"csv" choice:"copy" default:"csv" description:"csv with user_id,ip_addr lines or Postgres COPY stream"`
+	Gzip   bool   `long:"gzip" env:"CHECKER_GENERATE_GZIP" description:"gzip output"`
+	Table  string `long:"table" env:"CHECKER_GENERATE_TABLE" default:"conn_log" description:"table name for copy format"`
+
+	cmd.GeneratorOpts
+	cmd.CommonOpts
+}
+
+// Execute command generates the dataset and writes it to the output
+func (c *Command) Execute(args []string) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		log.Printf("[WARN] interrupt signal")
+		cancel()
+	}()
+
+	seed := c.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Printf("[INFO] generate dataset to %s with seed %d. Debug mode: %t", c.Out, seed, c.Dbg)
+
+	profile := c.Profile()
+	if !c.Dbg {
+		if err := c.SaveTruth(profile); err != nil {
+			return err
+		}
+	}
+
+	out, err := cmd.CreateOutput(c.Out, c.Gzip)
+	if err != nil {
+		return err
+	}
+	n, err := c.write(ctx, out, generator.New(seed), profile)
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] %d records written to %s", n, c.Out)
+	return nil
+}
+
+// write streams generated records in the requested format, returns number of written records
+func (c *Command) write(ctx context.Context, w io.Writer, gen *generator.Generator, profile *generator.Profile) (n uint64, err error) {
+	sep := byte(',')
+	if c.Format == formatCopy {
+		sep = '\t'
+		_, err = fmt.Fprintf(w, "COPY %s (user_id, ip_addr) FROM stdin;\n", c.Table)
+	} else {
+		_, err = io.WriteString(w, "user_id,ip_addr\n")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 0, 32)
+	emit := func(rec *record.Record, pos uint64) bool {
+		if n%1e5 == 0 && ctx.Err() != nil {
+			err = ctx.Err()
+			return false
+		}
+		buf = strconv.AppendUint(buf[:0], uint64(rec.UserID), 10)
+		buf = append(buf, sep)
+		buf = append(buf, rec.IP.String()...)
+		buf = append(buf, '\n')
+		if _, err = w.Write(buf); err != nil {
+			return false
+		}
+		n++
+		return true
+	}
+	if c.Dbg {
+		gen.GenerateDbg(0, emit)
+	} else {
+		gen.Generate(0, profile, emit)
+	}
+	if err != nil {
+		return n, err
+	}
+
+	if c.Format == formatCopy {
+		_, err = io.WriteString(w, "\\.\n")
+	}
+	return n, err
+}
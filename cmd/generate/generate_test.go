@@ -0,0 +1,96 @@
+package generate
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/generator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const dbgCSV = `user_id,ip_addr
+1,127.0.0.1
+1,127.0.0.2
+2,127.0.0.1
+2,127.0.0.2
+2,127.0.0.3
+3,127.0.0.3
+3,127.0.0.1
+4,127.0.0.1
+`
+
+func TestGenerate_CSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := &Command{Out: filepath.Join(dir, "conn_log.csv"), Format: formatCSV, CommonOpts: cmd.CommonOpts{Dbg: true}}
+	require.NoError(t, c.Execute(nil))
+
+	buf, err := ioutil.ReadFile(c.Out)
+	require.NoError(t, err)
+	assert.Equal(t, dbgCSV, string(buf))
+}
+
+func TestGenerate_InvalidRingSize(t *testing.T) {
+	c := &Command{GeneratorOpts: cmd.GeneratorOpts{DupRings: 1, RingSize: 2}}
+	assert.EqualError(t, c.Execute(nil), "ring size should be at least 3, got 2")
+}
+
+func TestGenerate_InvalidIPPool(t *testing.T) {
+	c := &Command{GeneratorOpts: cmd.GeneratorOpts{IPPool: generator.MaxIPPool + 1}}
+	assert.EqualError(t, c.Execute(nil), "ip pool should be at most 1665138688, got 1665138689")
+	c.IPPool = 1 << 32
+	assert.EqualError(t, c.Execute(nil), "ip pool should be at most 1665138688, got 4294967296")
+}
+
+func TestGenerate_CopyGzip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := &Command{Out: filepath.Join(dir, "conn_log.sql.gz"), Format: formatCopy, Gzip: true, Table: "conn_log", CommonOpts: cmd.CommonOpts{Dbg: true}}
+	require.NoError(t, c.Execute(nil))
+
+	f, err := os.Open(c.Out)
+	require.NoError(t, err)
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	buf, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+
+	expected := "COPY conn_log (user_id, ip_addr) FROM stdin;\n1\t127.0.0.1\n1\t127.0.0.2\n2\t127.0.0.1\n2\t127.0.0.2\n" +
+		"2\t127.0.0.3\n3\t127.0.0.3\n3\t127.0.0.1\n4\t127.0.0.1\n\\.\n"
+	assert.Equal(t, expected, string(buf))
+}
+
+func TestGenerate_Deterministic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "generate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := cmd.GeneratorOpts{
+		Seed: 7, UsersCount: 20, RequestPerUserLimit: 10, RequestPerUserMean: 5, IPsPerUserLimit: 3,
+		IPPool: 50, IPDistribution: "uniform", DupPairs: 2, Truth: filepath.Join(dir, "truth.csv"),
+	}
+	c1 := &Command{Out: filepath.Join(dir, "1.csv"), Format: formatCSV, GeneratorOpts: opts}
+	c2 := &Command{Out: filepath.Join(dir, "2.csv"), Format: formatCSV, GeneratorOpts: opts}
+	require.NoError(t, c1.Execute(nil))
+	require.NoError(t, c2.Execute(nil))
+
+	buf1, err := ioutil.ReadFile(c1.Out)
+	require.NoError(t, err)
+	buf2, err := ioutil.ReadFile(c2.Out)
+	require.NoError(t, err)
+	assert.Equal(t, buf1, buf2)
+
+	truth, err := ioutil.ReadFile(opts.Truth)
+	require.NoError(t, err)
+	assert.Equal(t, "user_id_1,user_id_2,kind,dupes\n21,22,pair,true\n23,24,pair,true\n", string(truth))
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/mullakhmetov/duplicates-checker/internal/generator"
+	"github.com/pkg/errors"
+)
+
+// GeneratorOpts keeps dataset generator options, shared by commands generating datasets
+type GeneratorOpts struct {
+	UsersCount          uint `long:"users_count" env:"CHECKER_GEN_USERS_COUNT" default:"10000" description:"unique users count"`
+	RequestPerUserLimit uint `long:"requests_limit" env:"CHECKER_GEN_REQUESTS_LIMIT" default:"1000000" description:"max requests per user"`
+	RequestPerUserMean  uint `long:"requests_mean" env:"CHECKER_GEN_REQUESTS_MEAN" default:"10000" description:"requests per user distribution mean"`
+	IPsPerUserLimit     uint `long:"ips_limit" env:"CHECKER_GEN_IPS_LIMIT" default:"10" description:"unique ips per user limit. exponentially distributed"`
+
+	Seed           int64  `long:"seed" env:"CHECKER_GEN_SEED" description:"generator seed, the same seed and options yield the same dataset. random if not set"`
+	IPPool         uint   `long:"ip_pool" env:"CHECKER_GEN_IP_POOL" default:"500" description:"size of IPs pool users' IPs are taken from, at most 1665138688, so the pool never reaches planted IPs"`
+	IPDistribution string `long:"ip_distribution" env:"CHECKER_GEN_IP_DISTRIBUTION" choice:"ring" choice:"uniform" choice:"zipf" default:"ring" description:"how users' IPs are taken from the pool"`
+	DupPairs       uint   `long:"dup_pairs" env:"CHECKER_GEN_DUP_PAIRS" description:"planted pairs of duplicate users"`
+	DupRings       uint   `long:"dup_rings" env:"CHECKER_GEN_DUP_RINGS" description:"planted rings of users, each of them is a duplicate of the next one"`
+	RingSize       uint   `long:"ring_size" env:"CHECKER_GEN_RING_SIZE" default:"3" description:"users per planted ring, at least 3 if rings are planted"`
+	NATIPs         uint   `long:"nat_ips" env:"CHECKER_GEN_NAT_IPS" description:"planted shared NAT IPs, users behind them share one IP only and are not duplicates"`
+	NATUsers       uint   `long:"nat_users" env:"CHECKER_GEN_NAT_USERS" default:"10" description:"users per planted NAT IP"`
+	Truth          string `long:"truth" env:"CHECKER_GEN_TRUTH" description:"file planted pairs of users are written to as user_id_1,user_id_2,kind,dupes lines"`
+}
+
+// Validate checks planted users can be generated as requested
+func (g *GeneratorOpts) Validate() error {
+	if g.DupRings > 0 && g.RingSize < 3 {
+		return errors.Errorf("ring size should be at least 3, got %d", g.RingSize)
+	}
+	if g.IPPool > generator.MaxIPPool {
+		return errors.Errorf("ip pool should be at most %d, got %d", generator.MaxIPPool, g.IPPool)
+	}
+	return nil
+}
+
+// Profile returns generated dataset description
+func (g *GeneratorOpts) Profile() *generator.Profile {
+	return &generator.Profile{
+		UsersCount:          g.UsersCount,
+		RequestPerUserLimit: g.RequestPerUserLimit,
+		RequestPerUserMean:  g.RequestPerUserMean,
+		IPsPerUserLimit:     g.IPsPerUserLimit,
+		IPPool:              g.IPPool,
+		IPDistribution:      g.IPDistribution,
+		DupPairs:            g.DupPairs,
+		DupRings:            g.DupRings,
+		RingSize:            g.RingSize,
+		NATIPs:              g.NATIPs,
+		NATUsers:            g.NATUsers,
+	}
+}
+
+// SaveTruth writes planted pairs of the profile to --truth file if it's set
+func (g *GeneratorOpts) SaveTruth(p *generator.Profile) error {
+	if g.Truth == "" {
+		return nil
+	}
+	w, err := CreateOutput(g.Truth, false)
+	if err != nil {
+		return err
+	}
+	if err := generator.WriteTruth(w, p); err != nil {
+		w.Close()
+		return err
+	}
+	log.Printf("[INFO] planted pairs are written to %s", g.Truth)
+	return w.Close()
+}
@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/internal/idempotency"
+)
+
+// IdempotencyOpts keeps options of idempotency keys, shared by commands accepting batches with keys
+type IdempotencyOpts struct {
+	IdempotencyTTL     time.Duration `long:"idempotency_ttl" env:"CHECKER_IDEMPOTENCY_TTL" default:"24h" description:"how long batches' idempotency keys are remembered"`
+	IdempotencyMaxKeys int           `long:"idempotency_max_keys" env:"CHECKER_IDEMPOTENCY_MAX_KEYS" default:"1000000" description:"max remembered idempotency keys, the oldest ones are forgotten first"`
+}
+
+// IdempotencyStore returns idempotency keys store kept in db
+func (o *IdempotencyOpts) IdempotencyStore(db *bolt.DB) (*idempotency.Store, error) {
+	repo, err := idempotency.NewBoltRepository(db, o.IdempotencyTTL, o.IdempotencyMaxKeys)
+	if err != nil {
+		return nil, err
+	}
+	return idempotency.NewStore(repo), nil
+}
@@ -1,36 +1,88 @@
 package importer
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
-	"math/rand"
 	"os"
 	"os/signal"
+	"runtime"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/checkpoint"
+	"github.com/mullakhmetov/duplicates-checker/internal/generator"
+	"github.com/mullakhmetov/duplicates-checker/internal/idempotency"
 	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
 )
 
 var batchSize = int(1e5)
 
+// followFlush bounds how long records of a followed file wait for commit
+var followFlush = time.Second
+
 // Command for randomly generated dataset loading
 type Command struct {
-	UsersCount          uint `long:"users_count" env:"CHECKER_GEN_USERS_COUNT" default:"10000" description:"unique users count"`
-	RequestPerUserLimit uint `long:"requests_limit" env:"CHECKER_GEN_REQUESTS_LIMIT" default:"1000000" description:"max requests per user"`
-	RequestPerUserMean  uint `long:"requests_mean" env:"CHECKER_GEN_REQUESTS_MEAN" default:"10000" description:"requests per user distribution mean"`
-	IPsPerUserLimit     uint `long:"ips_limit" env:"CHECKER_GEN_IPS_LIMIT" default:"10" description:"unique ips per user limit. exponentially distributed"`
+	Workers  int    `long:"workers" env:"CHECKER_IMPORT_WORKERS" description:"parser workers count, defaults to number of CPUs"`
+	File     string `long:"file" env:"CHECKER_IMPORT_FILE" description:"load records from plaintext file with user_id,ip_addr lines instead of generating them"`
+	Follow   bool   `long:"follow" env:"CHECKER_IMPORT_FOLLOW" description:"keep loading lines appended to --file until interrupted"`
+	Rate     int    `long:"rate" env:"CHECKER_IMPORT_RATE" description:"max records loaded per second, unlimited if not set"`
+	Batch    int    `long:"batch_size" env:"CHECKER_IMPORT_BATCH_SIZE" default:"100000" description:"records committed at once"`
+	Resume   string `long:"resume" description:"resume interrupted import run by its id"`
+	ListRuns bool   `long:"list_runs" description:"list past import runs with their status and exit"`
+
+	OnInvalid  string `long:"on_invalid" env:"CHECKER_IMPORT_ON_INVALID" choice:"fail" choice:"skip" choice:"quarantine" default:"fail" description:"what to do with invalid records"`
+	Quarantine string `long:"quarantine" env:"CHECKER_IMPORT_QUARANTINE" default:"quarantine.tsv" description:"file invalid records are appended to with --on_invalid=quarantine"`
+	DryRun     bool   `long:"dry_run" description:"parse the whole source and print validation report without touching the database"`
+
+	Tenant string `long:"tenant" env:"CHECKER_IMPORT_TENANT" description:"tenant the records are loaded into, its bucket is created if it doesn't exist. Empty is the default tenant"`
 
+	IdempotencyKey string `long:"idempotency_key" env:"CHECKER_IMPORT_IDEMPOTENCY_KEY" description:"batches are keyed by it and their positions, batches loaded under the same key already are skipped"`
+
+	cmd.IdempotencyOpts
+	cmd.GeneratorOpts
 	cmd.CommonOpts
 }
 
+// runOptions keep everything needed to reproduce the source of a run, they are persisted with the run
+type runOptions struct {
+	Dbg            bool
+	File           string
+	Follow         bool
+	Seed           int64
+	Tenant         record.Tenant
+	IdempotencyKey string
+	generator.Profile
+}
+
+func (o *runOptions) source() string {
+	switch {
+	case o.Dbg:
+		return "dbg"
+	case o.File != "" && o.Follow:
+		return "follow"
+	case o.File != "":
+		return "file"
+	default:
+		return "generator"
+	}
+}
+
 type services struct {
 	recordService record.Service
 }
 
+type repositories struct {
+	runRepo checkpoint.Repository
+}
+
 type sharedResources struct {
 	boltDB *bolt.DB
 }
@@ -44,15 +96,17 @@ type importer struct {
 	*Command
 
 	*services
+	*repositories
 	*sharedResources
 
-	gen        *generator
-	dbg        bool
 	terminated chan struct{}
 }
 
 // Execute command starts importing data to the store
 func (c *Command) Execute(args []string) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
 	log.Printf("[INFO] start import records process. Debug mode: %t", c.Dbg)
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -64,13 +118,28 @@ func (c *Command) Execute(args []string) error {
 		cancel()
 	}()
 
-	importer, err := c.newImporter(c.Dbg)
+	if c.DryRun {
+		return c.dryRun(ctx, os.Stdout)
+	}
+
+	importer, err := c.newImporter()
 	if err != nil {
 		return err
 	}
-	err = importer.run(ctx)
+	defer importer.Close()
+
+	if c.ListRuns {
+		return importer.listRuns(ctx)
+	}
+
+	run, err := importer.prepareRun(ctx)
+	if err != nil {
+		return err
+	}
+	err = importer.run(ctx, run)
 	if err != nil {
 		log.Printf("[ERROR] terminated with error %+v", err)
+		log.Printf("[INFO] resume with `import --resume=%s`", run.ID)
 		return err
 	}
 
@@ -78,83 +147,350 @@ func (c *Command) Execute(args []string) error {
 	return nil
 }
 
-func (c *Command) newImporter(dbg bool) (*importer, error) {
+func (c *Command) newImporter() (*importer, error) {
 	boltDB, err := record.NewBoltDB(c.BoltDBName, &bolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
 		return nil, err
 	}
-	recordRepo, err := record.NewBoltRepository(boltDB)
+	recordRepo, err := record.NewTenantRepository(boltDB, record.Tenant(c.Tenant))
 	if err != nil {
+		boltDB.Close()
 		return nil, err
 	}
+	return c.attach(boltDB, record.NewService(recordRepo))
+}
 
-	recordService := record.NewService(recordRepo)
+// attach makes importer loading records through recordService into boltDB
+func (c *Command) attach(boltDB *bolt.DB, recordService record.Service) (*importer, error) {
+	runRepo, err := checkpoint.NewBoltRepository(boltDB)
+	if err != nil {
+		return nil, err
+	}
 
 	s := &importer{
 		Command: c,
 		services: &services{
 			recordService: recordService,
 		},
+		repositories: &repositories{
+			runRepo: runRepo,
+		},
 		sharedResources: &sharedResources{
 			boltDB: boltDB,
 		},
-		gen:        &generator{rand.New(rand.NewSource(time.Now().UnixNano()))},
-		dbg:        dbg,
 		terminated: make(chan struct{}),
 	}
 	return s, nil
 }
 
-func (i *importer) run(ctx context.Context) error {
-	go func() {
-		// Graceful shutdown
-		<-ctx.Done()
-		i.sharedResources.Close()
-		log.Print("[INFO] importer was shut down")
-	}()
+// Load starts a new import run into boltDB opened by the caller, it's used to import in the same process
+// with other commands. boltDB is left open. started is called with the run before loading starts
+func (c *Command) Load(ctx context.Context, boltDB *bolt.DB, recordService record.Service, started func(run *checkpoint.Run)) error {
+	i, err := c.attach(boltDB, recordService)
+	if err != nil {
+		return err
+	}
+	run, err := i.prepareRun(ctx)
+	if err != nil {
+		return err
+	}
+	started(run)
+	return i.run(ctx, run)
+}
 
-	var ch chan *record.Record
-	if i.dbg {
-		ch = i.gen.generateDbg(ctx)
-	} else {
-		ch = i.gen.generate(
-			ctx,
-			i.Command.UsersCount,
-			i.Command.RequestPerUserLimit,
-			i.Command.RequestPerUserMean,
-			i.Command.IPsPerUserLimit,
-		)
-	}
-
-	records := make([]*record.Record, 0, batchSize)
-	C := 1
-	for rec := range ch {
+func (c *Command) runOptions() *runOptions {
+	seed := c.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &runOptions{
+		Dbg:            c.Dbg,
+		File:           c.File,
+		Follow:         c.Follow,
+		Seed:           seed,
+		Tenant:         record.Tenant(c.Tenant),
+		IdempotencyKey: c.IdempotencyKey,
+		Profile:        *c.GeneratorOpts.Profile(),
+	}
+}
+
+// prepareRun starts a new run or loads the one requested to be resumed
+func (i *importer) prepareRun(ctx context.Context) (*checkpoint.Run, error) {
+	if i.Command.Resume != "" {
+		run, err := i.runRepo.Get(ctx, i.Command.Resume)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't resume run %s", i.Command.Resume)
+		}
+		if !run.Resumable() {
+			return nil, errors.Errorf("run %s is already %s", run.ID, run.Status)
+		}
+		opts := &runOptions{}
+		if err := json.Unmarshal(run.Options, opts); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode run %s options", run.ID)
+		}
+		if opts.Tenant != record.Tenant(i.Command.Tenant) {
+			return nil, errors.Errorf("run %s loads tenant %q, resume it with --tenant=%s", run.ID, opts.Tenant, opts.Tenant)
+		}
+		log.Printf("[INFO] resume run %s from position %d, %d records already loaded", run.ID, run.Position, run.Records)
+		run.Status = checkpoint.StatusRunning
+		run.Error = ""
+		return run, nil
+	}
+
+	opts := i.Command.runOptions()
+	buf, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.Command.saveTruth(opts); err != nil {
+		return nil, err
+	}
+	run := checkpoint.NewRun(opts.source(), buf)
+	if err := i.runRepo.Save(ctx, run); err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] start run %s with seed %d", run.ID, opts.Seed)
+	return run, nil
+}
+
+func (i *importer) listRuns(ctx context.Context) error {
+	runs, err := i.runRepo.List(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tSOURCE\tPOSITION\tRECORDS\tSTARTED\tUPDATED")
+	for _, r := range runs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+			r.ID, r.Status, r.Source, r.Position, r.Records,
+			r.StartedAt.Format(time.RFC3339), r.UpdatedAt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+// records opens the source skipping everything before the checkpoint
+func (o *runOptions) records(ctx context.Context, skip uint64) (chan *row, chan error, error) {
+	if o.File != "" && !o.Dbg {
+		return readFile(ctx, o.File, skip, o.Follow)
+	}
+
+	ch := make(chan *row)
+	emit := func(rec *record.Record, pos uint64) bool {
 		select {
+		case ch <- &row{rec: rec, pos: pos}:
+			return true
 		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			records = append(records, rec)
-			if len(records) >= batchSize {
-				if err := i.recordService.BulkAddRecords(ctx, records); err != nil {
-					return err
-				}
-				fmt.Printf("%d records loaded\n", C)
-				records = make([]*record.Record, 0, batchSize)
+			return false
+		}
+	}
+	go func() {
+		defer close(ch)
+		gen := generator.New(o.Seed)
+		if o.Dbg {
+			gen.GenerateDbg(skip, emit)
+		} else {
+			gen.Generate(skip, &o.Profile, emit)
+		}
+	}()
+	return ch, nil, nil
+}
+
+// saveTruth writes planted pairs of generated dataset to --truth file
+func (c *Command) saveTruth(opts *runOptions) error {
+	if opts.source() != "generator" {
+		return nil
+	}
+	return c.GeneratorOpts.SaveTruth(&opts.Profile)
+}
+
+// commitBatch returns records committed at once, the package default is used if it's not set
+func (c *Command) commitBatch() int {
+	if c.Batch == 0 {
+		return batchSize
+	}
+	return c.Batch
+}
+
+func (c *Command) workers() int {
+	if c.Workers == 0 {
+		return runtime.NumCPU()
+	}
+	return c.Workers
+}
+
+// dryRun validates the whole source and prints the report
+func (c *Command) dryRun(ctx context.Context, w io.Writer) error {
+	opts := c.runOptions()
+	ch, errc, err := opts.records(ctx, 0)
+	if err != nil {
+		return err
+	}
+
+	if err := c.saveTruth(opts); err != nil {
+		return err
+	}
+
+	rep := newReport(opts.source())
+	p := newPipeline(c.workers(), c.commitBatch(), func(ctx context.Context, b *batch) error {
+		rep.add(b)
+		return nil
+	})
+	p.reject = rep.rejects.reject
+	if err := p.run(ctx, ch); err != nil {
+		return err
+	}
+	select {
+	case err := <-errc:
+		return err
+	default:
+	}
+
+	rep.print(w)
+	return nil
+}
+
+// rejects returns invalid records handler according to --on_invalid policy
+// and a function to be called when the run finishes
+func (c *Command) rejects() (reject func(r *row, err error) error, closer func() error, err error) {
+	switch c.OnInvalid {
+	case policySkip:
+		return newRejects(nil).reject, func() error { return nil }, nil
+	case policyQuarantine:
+		f, err := os.OpenFile(c.Quarantine, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		w := bufio.NewWriter(f)
+		closer := func() error {
+			if err := w.Flush(); err != nil {
+				f.Close()
+				return err
 			}
-			C++
+			return f.Close()
 		}
+		return newRejects(w).reject, closer, nil
+	default:
+		return nil, func() error { return nil }, nil
 	}
+}
 
-	if len(records) > 0 {
-		if err := i.recordService.BulkAddRecords(ctx, records); err != nil {
+// run loads the source into the store through the pipeline. The checkpoint of every batch is saved within
+// the transaction merging the batch, so a resumed run never loads a batch twice and counts it again
+func (i *importer) run(ctx context.Context, run *checkpoint.Run) (err error) {
+	defer func() {
+		switch {
+		case err == nil:
+			run.Status = checkpoint.StatusDone
+		case ctx.Err() != nil:
+			run.Status = checkpoint.StatusInterrupted
+		default:
+			run.Status = checkpoint.StatusFailed
+			run.Error = err.Error()
+		}
+		if e := i.runRepo.Save(context.Background(), run); e != nil {
+			log.Printf("[ERROR] failed to save run %s: %+v", run.ID, e)
+		}
+		close(i.terminated)
+	}()
+
+	opts := &runOptions{}
+	if err := json.Unmarshal(run.Options, opts); err != nil {
+		return errors.Wrapf(err, "failed to decode run %s options", run.ID)
+	}
+	// the source must stop as soon as the pipeline does
+	srcCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch, errc, err := opts.records(srcCtx, run.Position)
+	if err != nil {
+		return err
+	}
+
+	reject, closeRejects, err := i.Command.rejects()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := closeRejects(); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	merge := i.merge
+	if opts.IdempotencyKey != "" {
+		keys, err := i.Command.IdempotencyStore(i.boltDB)
+		if err != nil {
+			return err
+		}
+		if opts.Tenant != record.DefaultTenant {
+			keys = keys.Namespace(string(opts.Tenant))
+		}
+		merge = func(ctx context.Context, b *batch) error {
+			return i.mergeOnce(ctx, keys, fmt.Sprintf("%s/%d", opts.IdempotencyKey, b.pos), b)
+		}
+	}
+
+	rate := newLimiter(i.Command.Rate)
+	p := newPipeline(i.Command.workers(), i.Command.commitBatch(), func(ctx context.Context, b *batch) error {
+		next := *run
+		next.Position = b.pos
+		next.Records += b.records
+		saved := false
+		ctx = record.WithTxHook(ctx, func(tx *bolt.Tx) error {
+			saved = true
+			return i.runRepo.SaveTx(tx, &next)
+		})
+		if err := merge(ctx, b); err != nil {
 			return err
 		}
+		// the checkpoint is saved apart if the batch is skipped or the store has no transactions
+		if !saved {
+			if err := i.runRepo.Save(ctx, &next); err != nil {
+				return err
+			}
+		}
+		*run = next
+		fmt.Printf("%d records loaded\n", run.Records)
+		rate.wait(ctx, b.records)
+		return nil
+	})
+	p.reject = reject
+	if opts.Follow {
+		p.flushInterval = followFlush
+	}
+	err = p.run(ctx, ch)
+	log.Printf("[INFO] %s", &p.metrics)
+	if err != nil {
+		return err
 	}
 
-	close(i.terminated)
+	select {
+	case err := <-errc:
+		return err
+	default:
+	}
 	return nil
 }
 
+func (i *importer) merge(ctx context.Context, b *batch) error {
+	return i.recordService.MergeUserIPs(ctx, b.users)
+}
+
+// mergeOnce merges the batch unless it was merged under the key already, the key is saved along with the batch
+func (i *importer) mergeOnce(ctx context.Context, keys *idempotency.Store, key string, b *batch) error {
+	_, replayed, err := keys.Do(ctx, key, func(remember idempotency.Remember) (*idempotency.Result, error) {
+		res := &idempotency.Result{}
+		ctx := record.WithTxHook(ctx, func(tx *bolt.Tx) error { return remember(tx, res) })
+		if err := i.merge(ctx, b); err != nil {
+			return nil, err
+		}
+		return res, nil
+	})
+	if replayed {
+		log.Printf("[INFO] batch %s is loaded already, skipped", key)
+	}
+	return err
+}
+
 func (i *importer) Wait() {
 	<-i.terminated
 }
@@ -1,10 +1,27 @@
 package importer
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/checkpoint"
+	"github.com/mullakhmetov/duplicates-checker/internal/generator"
+	"github.com/mullakhmetov/duplicates-checker/internal/idempotency"
 	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-var testDb = "/tmp/test.db"
+var testDb = "/tmp/test_importer.db"
 
 type importerTestCases struct {
 	u1ID record.UserID
@@ -25,20 +42,409 @@ var cases = []importerTestCases{
 	importerTestCases{record.UserID(1), record.UserID(1), true},
 }
 
-// func TestImorter(t *testing.T) {
-// 	_ = os.Remove(testDb)
+func TestImporter(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+
+	ctx := context.Background()
+	c := Command{CommonOpts: cmd.CommonOpts{BoltDBName: testDb, Dbg: true}}
+	i, err := c.newImporter()
+	require.NoError(t, err)
+	defer i.Close()
+
+	run, err := i.prepareRun(ctx)
+	require.NoError(t, err)
+	require.NoError(t, i.run(ctx, run))
+	i.Wait()
+
+	for _, c := range cases {
+		res, err := i.recordService.IsDuple(ctx, c.u1ID, c.u2ID)
+		assert.NoError(t, err)
+		assert.Equal(t, c.res, res, fmt.Sprintf("%v", c))
+	}
+
+	run, err = i.runRepo.Get(ctx, run.ID)
+	require.NoError(t, err)
+	assert.Equal(t, checkpoint.StatusDone, run.Status)
+	assert.Equal(t, uint64(len(cases)), run.Records)
+}
+
+func TestImporter_Resume(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+	defer func(s int) { batchSize = s }(batchSize)
+	batchSize = 3
+
+	ctx := context.Background()
+	c := Command{CommonOpts: cmd.CommonOpts{BoltDBName: testDb, Dbg: true}}
+	i, err := c.newImporter()
+	require.NoError(t, err)
+	defer i.Close()
+
+	// pretend the first batch was committed and the run was interrupted after that
+	run, err := i.prepareRun(ctx)
+	require.NoError(t, err)
+	run.Position, run.Records, run.Status = 3, 3, checkpoint.StatusInterrupted
+	require.NoError(t, i.runRepo.Save(ctx, run))
+
+	i.Command.Resume = run.ID
+	run, err = i.prepareRun(ctx)
+	require.NoError(t, err)
+	require.NoError(t, i.run(ctx, run))
+
+	run, err = i.runRepo.Get(ctx, run.ID)
+	require.NoError(t, err)
+	assert.Equal(t, checkpoint.StatusDone, run.Status)
+	assert.Equal(t, uint64(8), run.Position)
+	assert.Equal(t, uint64(8), run.Records)
+
+	// records before the checkpoint were skipped, so users 1 and 2 share nothing,
+	// and 2 and 3 share only 127.0.0.3
+	res, err := i.recordService.IsDuple(ctx, 1, 2)
+	assert.NoError(t, err)
+	assert.False(t, res)
+	res, err = i.recordService.IsDuple(ctx, 2, 3)
+	assert.NoError(t, err)
+	assert.False(t, res)
+
+	_, err = i.prepareRun(ctx)
+	assert.Error(t, err, "completed run can't be resumed")
+}
+
+// failingRunRepository fails to save checkpoints within transactions after `after` ones are saved
+type failingRunRepository struct {
+	checkpoint.Repository
+	after int
+}
+
+func (r *failingRunRepository) SaveTx(tx *bolt.Tx, run *checkpoint.Run) error {
+	if r.after == 0 {
+		return errors.New("crashed")
+	}
+	r.after--
+	return r.Repository.SaveTx(tx, run)
+}
+
+func TestImporter_ResumeAfterFailedCheckpoint(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+	defer func(s int) { batchSize = s }(batchSize)
+	batchSize = 3
+
+	ctx := context.Background()
+	c := Command{CommonOpts: cmd.CommonOpts{BoltDBName: testDb, Dbg: true}}
+	i, err := c.newImporter()
+	require.NoError(t, err)
+	defer i.Close()
+
+	// the checkpoint of the second batch fails, so the batch isn't merged either
+	runRepo := i.runRepo
+	i.runRepo = &failingRunRepository{Repository: runRepo, after: 1}
+	run, err := i.prepareRun(ctx)
+	require.NoError(t, err)
+	assert.EqualError(t, i.run(ctx, run), "crashed")
+
+	run, err = runRepo.Get(ctx, run.ID)
+	require.NoError(t, err)
+	assert.Equal(t, checkpoint.StatusFailed, run.Status)
+	assert.Equal(t, uint64(3), run.Position)
+	assert.Equal(t, uint64(3), run.Records)
+	res, err := i.recordService.IsDuple(ctx, 1, 2)
+	require.NoError(t, err)
+	assert.False(t, res, "records of the failed batch are not merged")
+
+	i.runRepo, i.terminated = runRepo, make(chan struct{})
+	i.Command.Resume = run.ID
+	run, err = i.prepareRun(ctx)
+	require.NoError(t, err)
+	require.NoError(t, i.run(ctx, run))
+
+	run, err = runRepo.Get(ctx, run.ID)
+	require.NoError(t, err)
+	assert.Equal(t, checkpoint.StatusDone, run.Status)
+	assert.Equal(t, uint64(8), run.Position)
+	assert.Equal(t, uint64(8), run.Records)
+	for _, c := range cases {
+		res, err := i.recordService.IsDuple(ctx, c.u1ID, c.u2ID)
+		assert.NoError(t, err)
+		assert.Equal(t, c.res, res, fmt.Sprintf("%v", c))
+	}
+}
+
+func TestImporter_IdempotencyKey(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+	defer func(s int) { batchSize = s }(batchSize)
+	batchSize = 3
+
+	ctx := context.Background()
+	c := Command{
+		IdempotencyKey:  "dbg",
+		IdempotencyOpts: cmd.IdempotencyOpts{IdempotencyTTL: time.Hour, IdempotencyMaxKeys: 100},
+		CommonOpts:      cmd.CommonOpts{BoltDBName: testDb, Dbg: true},
+	}
+	i, err := c.newImporter()
+	require.NoError(t, err)
+	defer i.Close()
+
+	// the first batch was loaded by another run under the same key
+	keys, err := c.IdempotencyStore(i.boltDB)
+	require.NoError(t, err)
+	_, _, err = keys.Do(ctx, "dbg/3", func(idempotency.Remember) (*idempotency.Result, error) { return &idempotency.Result{}, nil })
+	require.NoError(t, err)
+
+	run, err := i.prepareRun(ctx)
+	require.NoError(t, err)
+	require.NoError(t, i.run(ctx, run))
+	assert.Equal(t, uint64(8), run.Records)
+
+	// so users 1 and 2 share nothing
+	res, err := i.recordService.IsDuple(ctx, 1, 2)
+	assert.NoError(t, err)
+	assert.False(t, res)
+}
+
+func TestImporter_Tenant(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+	defer func(s int) { batchSize = s }(batchSize)
+	batchSize = 3
+
+	ctx := context.Background()
+	c := Command{
+		Tenant:          "acme",
+		IdempotencyKey:  "dbg",
+		IdempotencyOpts: cmd.IdempotencyOpts{IdempotencyTTL: time.Hour, IdempotencyMaxKeys: 100},
+		CommonOpts:      cmd.CommonOpts{BoltDBName: testDb, Dbg: true},
+	}
+	i, err := c.newImporter()
+	require.NoError(t, err)
+	defer i.Close()
+
+	// the key of the default tenant doesn't skip the batch of the tenant
+	keys, err := c.IdempotencyStore(i.boltDB)
+	require.NoError(t, err)
+	_, _, err = keys.Do(ctx, "dbg/3", func(idempotency.Remember) (*idempotency.Result, error) { return &idempotency.Result{}, nil })
+	require.NoError(t, err)
+
+	run, err := i.prepareRun(ctx)
+	require.NoError(t, err)
+	require.NoError(t, i.run(ctx, run))
+	for _, c := range cases {
+		res, err := i.recordService.IsDuple(ctx, c.u1ID, c.u2ID)
+		assert.NoError(t, err)
+		assert.Equal(t, c.res, res, fmt.Sprintf("%v", c))
+	}
+
+	defaultRepo, err := record.NewBoltRepository(i.boltDB)
+	require.NoError(t, err)
+	info, err := defaultRepo.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Empty(t, info.IPs)
+	tenants, err := record.Tenants(i.boltDB)
+	require.NoError(t, err)
+	assert.Equal(t, []record.Tenant{"acme"}, tenants)
+
+	// a run is resumed into its own tenant only
+	run.Status = checkpoint.StatusInterrupted
+	require.NoError(t, i.runRepo.Save(ctx, run))
+	i.Command.Tenant, i.Command.Resume = "", run.ID
+	_, err = i.prepareRun(ctx)
+	assert.EqualError(t, err, fmt.Sprintf(`run %s loads tenant "acme", resume it with --tenant=acme`, run.ID))
+}
 
-// 	ctx := context.Background()
-// 	c := Command{CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
-// 	i, err := c.newImporter(true)
-// 	assert.NoError(t, err)
+func TestImporter_File(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
 
-// 	err = i.generateDbg(ctx)
-// 	assert.NoError(t, err)
+	log := writeTempFile(t, "user_id,ip_addr\n1, 127.0.0.1\n2, 127.0.0.1\n\n1, 127.0.0.2\n2, 127.0.0.2\n")
+	defer os.Remove(log)
 
-// 	for _, c := range cases {
-// 		res, err := i.recordService.IsDouble(ctx, c.u1ID, c.u2ID)
-// 		assert.NoError(t, err)
-// 		assert.Equal(t, c.res, res, fmt.Sprintf("%v", c))
-// 	}
-// }
+	ctx := context.Background()
+	c := Command{File: log, CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
+	i, err := c.newImporter()
+	require.NoError(t, err)
+	defer i.Close()
+
+	run, err := i.prepareRun(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "file", run.Source)
+	require.NoError(t, i.run(ctx, run))
+	assert.Equal(t, uint64(6), run.Position)
+	assert.Equal(t, uint64(4), run.Records)
+
+	res, err := i.recordService.IsDuple(ctx, 1, 2)
+	assert.NoError(t, err)
+	assert.True(t, res)
+}
+
+func TestReadFile_Follow(t *testing.T) {
+	defer func(d time.Duration) { followPoll = d }(followPoll)
+	followPoll = time.Millisecond
+
+	log := writeTempFile(t, "user_id,ip_addr\n1,127.0.0.1\n2,127.")
+	defer os.Remove(log)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, _, err := readFile(ctx, log, 0, true)
+	require.NoError(t, err)
+	assert.Equal(t, &row{line: "1,127.0.0.1", pos: 2}, <-ch)
+
+	// the incomplete line is read once it's finished
+	f, err := os.OpenFile(log, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("0.0.1\n3,127.0.0.1\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, &row{line: "2,127.0.0.1", pos: 3}, <-ch)
+	assert.Equal(t, &row{line: "3,127.0.0.1", pos: 4}, <-ch)
+
+	cancel()
+	for range ch {
+	}
+}
+
+func TestImporter_Quarantine(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+
+	log := writeTempFile(t, "1,127.0.0.1\n1,127.0.0.300\n0,127.0.0.1\n1,127.0.0.2\n")
+	defer os.Remove(log)
+	quarantine := log + ".quarantine"
+	defer os.Remove(quarantine)
+
+	ctx := context.Background()
+	c := Command{File: log, OnInvalid: policyQuarantine, Quarantine: quarantine, CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
+	i, err := c.newImporter()
+	require.NoError(t, err)
+	defer i.Close()
+
+	run, err := i.prepareRun(ctx)
+	require.NoError(t, err)
+	require.NoError(t, i.run(ctx, run))
+	assert.Equal(t, uint64(4), run.Position)
+	assert.Equal(t, uint64(2), run.Records)
+
+	buf, err := ioutil.ReadFile(quarantine)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], "2\t1,127.0.0.300\t"), lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "3\t0,127.0.0.1\t"), lines[1])
+}
+
+func TestImporter_Fail(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+
+	log := writeTempFile(t, "1,127.0.0.1\nbroken\n")
+	defer os.Remove(log)
+
+	ctx := context.Background()
+	c := Command{File: log, OnInvalid: policyFail, CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
+	i, err := c.newImporter()
+	require.NoError(t, err)
+	defer i.Close()
+
+	run, err := i.prepareRun(ctx)
+	require.NoError(t, err)
+	assert.Error(t, i.run(ctx, run))
+	assert.Equal(t, checkpoint.StatusFailed, run.Status)
+	assert.Contains(t, run.Error, "row 2")
+}
+
+func TestImporter_DryRun(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+
+	log := writeTempFile(t, "1,127.0.0.1\n1,127.0.0.300\n2,0.0.0.0\n2,127.0.0.2\n3,127.0.0.2\nbroken\n")
+	defer os.Remove(log)
+
+	c := Command{File: log, CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
+	out := &bytes.Buffer{}
+	require.NoError(t, c.dryRun(context.Background(), out))
+
+	report := out.String()
+	assert.Contains(t, report, "rows: 6, valid: 3, invalid: 3")
+	assert.Contains(t, report, "  invalid ip: 1\n    2: 1,127.0.0.300\n")
+	assert.Contains(t, report, "  reserved ip: 1\n    3: 2,0.0.0.0\n")
+	assert.Contains(t, report, "  malformed line: 1\n    6: broken\n")
+	assert.Contains(t, report, "distinct users: 3, distinct ips: 2")
+
+	_, err := os.Stat(testDb)
+	assert.True(t, os.IsNotExist(err), "dry run must not touch the database")
+}
+
+func TestImporter_PlantedTruth(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+
+	ctx := context.Background()
+	c := Command{
+		GeneratorOpts: cmd.GeneratorOpts{
+			Seed:                1,
+			UsersCount:          50,
+			RequestPerUserLimit: 20,
+			RequestPerUserMean:  5,
+			IPsPerUserLimit:     5,
+			IPPool:              100,
+			IPDistribution:      generator.DistributionZipf,
+			DupPairs:            3,
+			DupRings:            2,
+			RingSize:            4,
+			NATIPs:              2,
+			NATUsers:            3,
+		},
+		CommonOpts: cmd.CommonOpts{BoltDBName: testDb},
+	}
+	i, err := c.newImporter()
+	require.NoError(t, err)
+	defer i.Close()
+
+	run, err := i.prepareRun(ctx)
+	require.NoError(t, err)
+	require.NoError(t, i.run(ctx, run))
+
+	_, truths := generator.Plant(c.Profile())
+	for _, tr := range truths {
+		res, err := i.recordService.IsDuple(ctx, tr.U1, tr.U2)
+		require.NoError(t, err)
+		assert.Equal(t, tr.Dupes, res, "%d %d %s", tr.U1, tr.U2, tr.Kind)
+	}
+
+	// ring members are duplicates of their neighbours only
+	ring := record.UserID(50 + 2*3)
+	res, err := i.recordService.IsDuple(ctx, ring+1, ring+3)
+	require.NoError(t, err)
+	assert.False(t, res)
+}
+
+func TestParseLine(t *testing.T) {
+	rec, err := parseLine("1, 127.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, record.UserID(1), rec.UserID)
+
+	cases := map[string]error{
+		"1":            errMalformedLine,
+		"1,2,3":        errMalformedLine,
+		"a,127.0.0.1":  errInvalidUserID,
+		"-1,127.0.0.1": errInvalidUserID,
+		"0,127.0.0.1":  record.ErrZeroUserID,
+		"1,127.0.0":    record.ErrInvalidIP,
+		"1,::1":        record.ErrInvalidIP,
+		"1,0.0.0.0":    record.ErrReservedIP,
+	}
+	for line, expected := range cases {
+		_, err := parseLine(line)
+		assert.Equal(t, expected, errors.Cause(err), line)
+	}
+}
+
+func writeTempFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "conn_log")
+	require.NoError(t, err)
+	_, err = f.WriteString(content)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	return f.Name()
+}
@@ -0,0 +1,325 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+const aggregatorShards = 64
+
+// batch is an aggregated chunk of source rows ready to be written
+type batch struct {
+	users   record.UserIPs
+	rows    uint64
+	records uint64
+	pos     uint64
+}
+
+// pipeline loads rows in three stages: parser workers build records and collapse them
+// in a sharded per-user aggregator, and a single writer merges every batch into the store.
+// Chunks are aggregated one by one, so batches are written in source order and
+// a checkpoint after each write is exact. Aggregation of the next chunk overlaps with writing
+type pipeline struct {
+	workers   int
+	batchSize int
+	write     func(ctx context.Context, b *batch) error
+	// reject is called for invalid rows, the pipeline fails if it's not set or returns an error
+	reject func(r *row, err error) error
+	// flushInterval bounds how long rows wait for a batch to fill up, 0 disables it
+	flushInterval time.Duration
+
+	metrics metrics
+}
+
+type task struct {
+	rows []*row
+	agg  *aggregator
+	res  *taskResult
+	wg   *sync.WaitGroup
+}
+
+type taskResult struct {
+	valid int
+	err   error
+}
+
+func newPipeline(workers, batchSize int, write func(ctx context.Context, b *batch) error) *pipeline {
+	if workers < 1 {
+		workers = 1
+	}
+	return &pipeline{workers: workers, batchSize: batchSize, write: write}
+}
+
+// run blocks until rows are drained, ctx is cancelled or any stage fails
+func (p *pipeline) run(ctx context.Context, rows <-chan *row) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var once sync.Once
+	var failure error
+	fail := func(err error) {
+		once.Do(func() {
+			failure = err
+			cancel()
+		})
+	}
+
+	p.metrics.start = time.Now()
+	chunks := p.chunks(ctx, rows)
+
+	tasks := make(chan *task)
+	for w := 0; w < p.workers; w++ {
+		go func() {
+			for t := range tasks {
+				t.res.valid, t.res.err = t.agg.add(t.rows, p.reject)
+				t.wg.Done()
+			}
+		}()
+	}
+
+	batches := make(chan *batch, 1)
+	aggregated := make(chan struct{})
+	go func() {
+		defer close(aggregated)
+		defer close(batches)
+		defer close(tasks)
+		for chunk := range chunks {
+			started := time.Now()
+			b, err := p.aggregate(chunk, tasks)
+			p.metrics.aggregating += time.Since(started)
+			if err != nil {
+				fail(err)
+				return
+			}
+			select {
+			case batches <- b:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for b := range batches {
+		started := time.Now()
+		if err := p.write(ctx, b); err != nil {
+			fail(err)
+			break
+		}
+		p.metrics.writing += time.Since(started)
+		p.metrics.add(b)
+	}
+	<-aggregated
+	p.metrics.elapsed = time.Since(p.metrics.start)
+
+	if failure != nil {
+		return failure
+	}
+	return ctx.Err()
+}
+
+// chunks groups rows by batchSize. Incomplete chunk is sent after flushInterval if it's set
+func (p *pipeline) chunks(ctx context.Context, rows <-chan *row) chan []*row {
+	chunks := make(chan []*row, 1)
+	go func() {
+		defer close(chunks)
+		var flush <-chan time.Time
+		if p.flushInterval > 0 {
+			ticker := time.NewTicker(p.flushInterval)
+			defer ticker.Stop()
+			flush = ticker.C
+		}
+
+		chunk := make([]*row, 0, p.batchSize)
+		send := func() bool {
+			select {
+			case chunks <- chunk:
+			case <-ctx.Done():
+				return false
+			}
+			chunk = make([]*row, 0, p.batchSize)
+			return true
+		}
+		for {
+			select {
+			case r, ok := <-rows:
+				if !ok {
+					if len(chunk) > 0 {
+						send()
+					}
+					return
+				}
+				chunk = append(chunk, r)
+				if len(chunk) >= p.batchSize && !send() {
+					return
+				}
+			case <-flush:
+				if len(chunk) > 0 && !send() {
+					return
+				}
+			}
+		}
+	}()
+	return chunks
+}
+
+// aggregate splits the chunk between workers and waits for all of them
+func (p *pipeline) aggregate(chunk []*row, tasks chan *task) (*batch, error) {
+	agg := newAggregator(aggregatorShards)
+	part := (len(chunk) + p.workers - 1) / p.workers
+	results := make([]taskResult, 0, p.workers)
+	wg := &sync.WaitGroup{}
+	for from := 0; from < len(chunk); from += part {
+		to := from + part
+		if to > len(chunk) {
+			to = len(chunk)
+		}
+		results = append(results, taskResult{})
+		wg.Add(1)
+		tasks <- &task{rows: chunk[from:to], agg: agg, res: &results[len(results)-1], wg: wg}
+	}
+	wg.Wait()
+
+	b := &batch{
+		users: agg.collect(),
+		rows:  uint64(len(chunk)),
+		pos:   chunk[len(chunk)-1].pos,
+	}
+	for _, res := range results {
+		if res.err != nil {
+			return nil, res.err
+		}
+		b.records += uint64(res.valid)
+	}
+	return b, nil
+}
+
+// aggregator collapses records to per-user IP sets. Users are sharded to keep workers' lock contention low
+type aggregator struct {
+	shards []*aggregatorShard
+}
+
+type aggregatorShard struct {
+	sync.Mutex
+	users record.UserIPs
+}
+
+func newAggregator(shards int) *aggregator {
+	a := &aggregator{shards: make([]*aggregatorShard, shards)}
+	for i := range a.shards {
+		a.shards[i] = &aggregatorShard{users: make(record.UserIPs)}
+	}
+	return a
+}
+
+// add parses and validates rows and aggregates them locally first, so every shard is locked once per call.
+// Returns the number of valid rows
+func (a *aggregator) add(rows []*row, reject func(r *row, err error) error) (int, error) {
+	local := make([]record.UserIPs, len(a.shards))
+	var valid int
+	for _, r := range rows {
+		rec, err := r.parse()
+		if err != nil {
+			err = errors.Wrapf(err, "row %d", r.pos)
+			if reject == nil {
+				return 0, err
+			}
+			if err := reject(r, err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		valid++
+		s := int(rec.UserID) % len(a.shards)
+		if local[s] == nil {
+			local[s] = make(record.UserIPs)
+		}
+		local[s].Add(rec)
+	}
+
+	for s, users := range local {
+		if users == nil {
+			continue
+		}
+		shard := a.shards[s]
+		shard.Lock()
+		shard.users.Merge(users)
+		shard.Unlock()
+	}
+	return valid, nil
+}
+
+// collect returns all shards' users. Shards are disjoint, so it's a plain union
+func (a *aggregator) collect() record.UserIPs {
+	var n int
+	for _, shard := range a.shards {
+		n += len(shard.users)
+	}
+	users := make(record.UserIPs, n)
+	for _, shard := range a.shards {
+		for uID, ips := range shard.users {
+			users[uID] = ips
+		}
+	}
+	return users
+}
+
+// limiter keeps average rate of loaded records under the limit by pausing after batches
+type limiter struct {
+	rate  int
+	start time.Time
+	total uint64
+}
+
+func newLimiter(rate int) *limiter {
+	return &limiter{rate: rate, start: time.Now()}
+}
+
+// wait accounts n loaded records and blocks until the rate is under the limit or ctx is done
+func (l *limiter) wait(ctx context.Context, n uint64) {
+	if l.rate <= 0 {
+		return
+	}
+	l.total += n
+	due := l.start.Add(time.Duration(float64(l.total) / float64(l.rate) * float64(time.Second)))
+	if d := time.Until(due); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+		}
+	}
+}
+
+type metrics struct {
+	start       time.Time
+	elapsed     time.Duration
+	aggregating time.Duration
+	writing     time.Duration
+	rows        uint64
+	records     uint64
+	userDeltas  uint64
+	batches     uint64
+}
+
+func (m *metrics) add(b *batch) {
+	m.rows += b.rows
+	m.records += b.records
+	m.userDeltas += uint64(len(b.users))
+	m.batches++
+}
+
+func (m *metrics) String() string {
+	var rate float64
+	if m.elapsed > 0 {
+		rate = float64(m.rows) / m.elapsed.Seconds()
+	}
+	return fmt.Sprintf(
+		"%d records in %s (%.0f records/s), %d rejected, %d batches, %d user deltas written, aggregating %s, writing %s",
+		m.records, m.elapsed.Round(time.Millisecond), rate, m.rows-m.records, m.batches, m.userDeltas,
+		m.aggregating.Round(time.Millisecond), m.writing.Round(time.Millisecond),
+	)
+}
@@ -0,0 +1,138 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline(t *testing.T) {
+	rows := make(chan *row)
+	go func() {
+		defer close(rows)
+		for i := 1; i <= 1000; i++ {
+			rows <- &row{line: fmt.Sprintf("%d,1.0.0.%d", i%10+1, i%3+1), pos: uint64(i)}
+		}
+	}()
+
+	var batches []*batch
+	p := newPipeline(4, 300, func(ctx context.Context, b *batch) error {
+		batches = append(batches, b)
+		return nil
+	})
+	require.NoError(t, p.run(context.Background(), rows))
+
+	require.Len(t, batches, 4)
+	assert.Equal(t, []uint64{300, 600, 900, 1000}, []uint64{batches[0].pos, batches[1].pos, batches[2].pos, batches[3].pos})
+	assert.Equal(t, uint64(100), batches[3].records)
+
+	users := make(record.UserIPs)
+	for _, b := range batches {
+		users.Merge(b.users)
+	}
+	assert.Len(t, users, 10)
+	for _, ips := range users {
+		assert.Len(t, ips, 3)
+	}
+	assert.Equal(t, uint64(1000), p.metrics.records)
+	assert.Equal(t, uint64(4), p.metrics.batches)
+}
+
+func TestPipeline_ParseError(t *testing.T) {
+	rows := make(chan *row, 3)
+	rows <- &row{line: "1,1.0.0.1", pos: 1}
+	rows <- &row{line: "1,invalid", pos: 2}
+	rows <- &row{line: "2,1.0.0.1", pos: 3}
+	close(rows)
+
+	var written int
+	p := newPipeline(2, 10, func(ctx context.Context, b *batch) error {
+		written++
+		return nil
+	})
+	err := p.run(context.Background(), rows)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "row 2")
+	assert.Equal(t, 0, written)
+}
+
+func TestPipeline_Reject(t *testing.T) {
+	rows := make(chan *row, 4)
+	rows <- &row{line: "1,1.0.0.1", pos: 1}
+	rows <- &row{line: "1,invalid", pos: 2}
+	rows <- &row{rec: record.NewRecord(0, "1.0.0.1"), pos: 3}
+	rows <- &row{line: "2,1.0.0.1", pos: 4}
+	close(rows)
+
+	var rejected []string
+	var batches []*batch
+	p := newPipeline(1, 10, func(ctx context.Context, b *batch) error {
+		batches = append(batches, b)
+		return nil
+	})
+	p.reject = func(r *row, err error) error {
+		rejected = append(rejected, r.String())
+		return nil
+	}
+	require.NoError(t, p.run(context.Background(), rows))
+	assert.Equal(t, []string{"1,invalid", "0,1.0.0.1"}, rejected)
+	require.Len(t, batches, 1)
+	assert.Equal(t, uint64(4), batches[0].rows)
+	assert.Equal(t, uint64(2), batches[0].records)
+	assert.Equal(t, uint64(4), batches[0].pos)
+}
+
+func TestPipeline_WriteError(t *testing.T) {
+	rows := make(chan *row)
+	go func() {
+		defer close(rows)
+		for i := 1; i <= 100; i++ {
+			rows <- &row{rec: record.NewRecord(1, "1.0.0.1"), pos: uint64(i)}
+		}
+	}()
+
+	p := newPipeline(2, 10, func(ctx context.Context, b *batch) error {
+		return fmt.Errorf("disk is full")
+	})
+	assert.EqualError(t, p.run(context.Background(), rows), "disk is full")
+}
+
+func TestPipeline_FlushInterval(t *testing.T) {
+	rows := make(chan *row)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	written := make(chan *batch)
+	p := newPipeline(1, 100, func(ctx context.Context, b *batch) error {
+		written <- b
+		return nil
+	})
+	p.flushInterval = 10 * time.Millisecond
+	go p.run(ctx, rows)
+
+	// incomplete batch is written after the interval
+	rows <- &row{line: "1,1.0.0.1", pos: 1}
+	rows <- &row{line: "2,1.0.0.1", pos: 2}
+	b := <-written
+	assert.Equal(t, uint64(2), b.pos)
+	assert.Equal(t, uint64(2), b.records)
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(1000)
+	start := time.Now()
+	l.wait(context.Background(), 50)
+	l.wait(context.Background(), 50)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	// unlimited
+	l = newLimiter(0)
+	start = time.Now()
+	l.wait(context.Background(), 1e9)
+	assert.True(t, time.Since(start) < 100*time.Millisecond)
+}
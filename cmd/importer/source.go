@@ -0,0 +1,123 @@
+package importer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+// row is a record or a raw line to be parsed by pipeline workers
+// together with the source position right after it
+type row struct {
+	rec  *record.Record
+	line string
+	pos  uint64
+}
+
+// parse returns row's record validating it
+func (r *row) parse() (*record.Record, error) {
+	if r.rec == nil {
+		return parseLine(r.line)
+	}
+	if err := r.rec.Validate(); err != nil {
+		return nil, err
+	}
+	return r.rec, nil
+}
+
+func (r *row) String() string {
+	if r.rec == nil {
+		return r.line
+	}
+	return fmt.Sprintf("%d,%s", r.rec.UserID, r.rec.IP)
+}
+
+// followPoll is how often a followed file is checked for appended lines
+var followPoll = 200 * time.Millisecond
+
+// readFile streams raw lines of the plaintext access log with `user_id,ip_addr` lines.
+// Position is a line number. First `skip` lines are omitted. Read errors are sent to errc.
+// With follow the file is read until ctx is cancelled, lines appended to it are streamed as they are complete
+func readFile(ctx context.Context, path string, skip uint64, follow bool) (chan *row, chan error, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch := make(chan *row)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(ch)
+		defer f.Close()
+
+		r := bufio.NewReader(f)
+		var pos uint64
+		var partial string
+		for {
+			s, err := r.ReadString('\n')
+			if err == io.EOF && follow {
+				// the rest of the line isn't written yet
+				partial += s
+				select {
+				case <-time.After(followPoll):
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil && err != io.EOF {
+				errc <- err
+				return
+			}
+			s, partial = partial+s, ""
+			if s == "" {
+				return
+			}
+
+			pos++
+			line := strings.TrimSpace(s)
+			if pos > skip && line != "" && !strings.HasPrefix(line, "#") && !(pos == 1 && isHeader(line)) {
+				select {
+				case ch <- &row{line: line, pos: pos}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err == io.EOF {
+				return
+			}
+		}
+	}()
+	return ch, errc, nil
+}
+
+// Line parsing errors, record validation errors come from record package
+var (
+	errMalformedLine = errors.New("malformed line")
+	errInvalidUserID = errors.New("invalid user id")
+)
+
+func parseLine(line string) (*record.Record, error) {
+	parts := strings.Split(line, ",")
+	if len(parts) != 2 {
+		return nil, errors.Wrapf(errMalformedLine, "expected `user_id,ip_addr`, got %q", line)
+	}
+	uID, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 32)
+	if err != nil {
+		return nil, errors.Wrapf(errInvalidUserID, "%q", parts[0])
+	}
+	return record.ParseRecord(record.UserID(uID), strings.TrimSpace(parts[1]))
+}
+
+// header line starts with a column name instead of user id
+func isHeader(line string) bool {
+	return line[0] < '0' || line[0] > '9'
+}
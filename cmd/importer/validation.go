@@ -0,0 +1,111 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+// invalid rows policies
+const (
+	policyFail       = "fail"
+	policySkip       = "skip"
+	policyQuarantine = "quarantine"
+)
+
+const samplesPerKind = 5
+
+// rejects counts invalid rows by error kind keeping a few samples of each kind.
+// Rejected rows are written to quarantine if it's set
+type rejects struct {
+	sync.Mutex
+	quarantine io.Writer
+	kinds      map[string]uint64
+	samples    map[string][]string
+}
+
+func newRejects(quarantine io.Writer) *rejects {
+	return &rejects{
+		quarantine: quarantine,
+		kinds:      make(map[string]uint64),
+		samples:    make(map[string][]string),
+	}
+}
+
+// reject is pipeline's callback for invalid rows, it's safe for concurrent use
+func (r *rejects) reject(row *row, err error) error {
+	kind := errors.Cause(err).Error()
+
+	r.Lock()
+	defer r.Unlock()
+	r.kinds[kind]++
+	if len(r.samples[kind]) < samplesPerKind {
+		r.samples[kind] = append(r.samples[kind], fmt.Sprintf("%d: %s", row.pos, row))
+	}
+	if r.quarantine != nil {
+		if _, e := fmt.Fprintf(r.quarantine, "%d\t%s\t%s\n", row.pos, row, err); e != nil {
+			return errors.Wrap(e, "failed to write quarantine")
+		}
+	}
+	return nil
+}
+
+func (r *rejects) total() (n uint64) {
+	for _, c := range r.kinds {
+		n += c
+	}
+	return n
+}
+
+// report describes a source without loading it
+type report struct {
+	source  string
+	rejects *rejects
+	rows    uint64
+	records uint64
+	users   map[record.UserID]struct{}
+	ips     map[uint32]struct{}
+}
+
+func newReport(source string) *report {
+	return &report{
+		source:  source,
+		rejects: newRejects(nil),
+		users:   make(map[record.UserID]struct{}),
+		ips:     make(map[uint32]struct{}),
+	}
+}
+
+// add accumulates a batch, it's called by the pipeline's single writer
+func (r *report) add(b *batch) {
+	r.rows += b.rows
+	r.records += b.records
+	for uID, ips := range b.users {
+		r.users[uID] = struct{}{}
+		for ip := range ips {
+			r.ips[ip] = struct{}{}
+		}
+	}
+}
+
+func (r *report) print(w io.Writer) {
+	fmt.Fprintf(w, "source: %s\n", r.source)
+	fmt.Fprintf(w, "rows: %d, valid: %d, invalid: %d\n", r.rows, r.records, r.rejects.total())
+
+	kinds := make([]string, 0, len(r.rejects.kinds))
+	for kind := range r.rejects.kinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "  %s: %d\n", kind, r.rejects.kinds[kind])
+		for _, sample := range r.rejects.samples[kind] {
+			fmt.Fprintf(w, "    %s\n", sample)
+		}
+	}
+	fmt.Fprintf(w, "distinct users: %d, distinct ips: %d\n", len(r.users), len(r.ips))
+}
@@ -0,0 +1,68 @@
+package index
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/index"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+// Command groups index subcommands
+type Command struct {
+	Build BuildCommand `command:"build" description:"Writes an immutable index of users' IPs from the store, serve it with server --dataset"`
+}
+
+// BuildCommand writes an index of the store
+type BuildCommand struct {
+	Out       string        `long:"out" env:"CHECKER_INDEX_OUT" required:"true" description:"index file, it's replaced once the index is written"`
+	Reverse   bool          `long:"reverse" env:"CHECKER_INDEX_REVERSE" description:"build the index of users of every IP too"`
+	Retention time.Duration `long:"retention" env:"CHECKER_RETENTION" description:"skip users' IPs not seen for longer, the server's --retention applies to kept ones. 0 keeps all IPs"`
+	Tenant    string        `long:"tenant" env:"CHECKER_INDEX_TENANT" description:"tenant whose users are indexed. Empty is the default tenant"`
+
+	cmd.CommonOpts
+}
+
+// Execute command builds the index
+func (c *BuildCommand) Execute(args []string) error {
+	if c.Retention < 0 {
+		return errors.Errorf("retention should be non-negative, got %s", c.Retention)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		log.Printf("[WARN] interrupt signal")
+		cancel()
+	}()
+
+	boltDB, err := record.NewBoltDB(c.BoltDBName, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", c.BoltDBName)
+	}
+	defer boltDB.Close()
+	recordRepo, err := record.OpenTenantRepository(boltDB, record.Tenant(c.Tenant))
+	if err != nil {
+		return err
+	}
+
+	opts := index.BuildOptions{Reverse: c.Reverse}
+	if c.Retention > 0 {
+		opts.Since = time.Now().Add(-c.Retention)
+	}
+	start := time.Now()
+	h, err := index.Build(ctx, recordRepo, c.Out, opts)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] index %s of %d users and %d IPs is built in %s", c.Out, h.Users, h.IPs, time.Since(start))
+	return nil
+}
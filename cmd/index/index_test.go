@@ -0,0 +1,78 @@
+package index
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/index"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testDb    = "/tmp/test_index_cmd.db"
+	testIndex = "/tmp/test_index_cmd.idx"
+)
+
+func TestBuildCommand(t *testing.T) {
+	teardown := prepStore(t)
+	defer teardown()
+	ctx := context.Background()
+
+	c := &BuildCommand{Out: testIndex, Reverse: true, CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
+	require.NoError(t, c.Execute(nil))
+	idx, err := index.Open(testIndex)
+	require.NoError(t, err)
+	infos, err := idx.GetUserInfos(ctx, 1, 2, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2"}, infos[0].IPs.Strings())
+	assert.Equal(t, []string{"1.1.1.1"}, infos[1].IPs.Strings())
+	assert.Empty(t, infos[2].IPs)
+	users, err := idx.UsersOf(net.ParseIP("1.1.1.1"))
+	require.NoError(t, err)
+	assert.Equal(t, []record.UserID{1, 2}, users)
+	require.NoError(t, idx.Close())
+
+	c = &BuildCommand{Out: testIndex, Tenant: "acme", CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
+	require.NoError(t, c.Execute(nil))
+	idx, err = index.Open(testIndex)
+	require.NoError(t, err)
+	defer idx.Close()
+	infos, err = idx.GetUserInfos(ctx, 1, 3)
+	require.NoError(t, err)
+	assert.Empty(t, infos[0].IPs)
+	assert.Equal(t, []string{"3.3.3.3"}, infos[1].IPs.Strings())
+	_, err = idx.UsersOf(net.ParseIP("3.3.3.3"))
+	assert.Equal(t, index.ErrNoReverse, err)
+
+	c.Tenant = "beta"
+	assert.Equal(t, record.ErrUnknownTenant, errors.Cause(c.Execute(nil)))
+	c.Tenant, c.Retention = "", -1
+	assert.Error(t, c.Execute(nil))
+}
+
+func prepStore(t *testing.T) func() {
+	_ = os.Remove(testDb)
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	repo, err := record.NewBoltRepository(db)
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, repo.BulkAddRecords(ctx, []*record.Record{
+		record.NewRecord(1, "1.1.1.1"), record.NewRecord(1, "2.2.2.2"), record.NewRecord(2, "1.1.1.1"),
+	}))
+	acme, err := record.NewTenantRepository(db, "acme")
+	require.NoError(t, err)
+	require.NoError(t, acme.AddRecord(ctx, record.NewRecord(3, "3.3.3.3")))
+	require.NoError(t, db.Close())
+
+	return func() {
+		_ = os.Remove(testDb)
+		_ = os.Remove(testIndex)
+	}
+}
@@ -0,0 +1,134 @@
+package ingest
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/mullakhmetov/duplicates-checker/internal/syslog"
+)
+
+// Command starts syslog listeners loading received access records to the store
+type Command struct {
+	UDP           string        `long:"udp" env:"CHECKER_SYSLOG_UDP" default:":5514" description:"syslog UDP address, empty to disable"`
+	TCP           string        `long:"tcp" env:"CHECKER_SYSLOG_TCP" default:":5514" description:"syslog TCP address, empty to disable"`
+	BatchSize     int           `long:"batch_size" env:"CHECKER_SYSLOG_BATCH_SIZE" default:"10000" description:"records committed at once"`
+	FlushInterval time.Duration `long:"flush_interval" env:"CHECKER_SYSLOG_FLUSH_INTERVAL" default:"1s" description:"max time records wait for commit"`
+	SDID          string        `long:"sd_id" env:"CHECKER_SYSLOG_SD_ID" description:"structured data element with the record, any element if empty"`
+	UserParam     string        `long:"user_param" env:"CHECKER_SYSLOG_USER_PARAM" default:"user_id" description:"structured data param with user id"`
+	IPParam       string        `long:"ip_param" env:"CHECKER_SYSLOG_IP_PARAM" default:"ip" description:"structured data param with ip"`
+	MessageRegexp string        `long:"message_regexp" env:"CHECKER_SYSLOG_MESSAGE_REGEXP" description:"regexp with user_id and ip named groups applied to messages without structured data record"`
+
+	cmd.CommonOpts
+}
+
+type services struct {
+	recordService record.Service
+}
+
+type sharedResources struct {
+	boltDB *bolt.DB
+}
+
+func (s *sharedResources) Close() {
+	log.Print("[INFO] closing shared resources")
+	s.boltDB.Close()
+}
+
+type ingester struct {
+	*Command
+	srv *syslog.Server
+
+	*services
+	*sharedResources
+
+	terminated chan struct{}
+}
+
+// Execute command starts syslog ingestion
+func (c *Command) Execute(args []string) error {
+	log.Printf("[INFO] start syslog ingestion on udp %q, tcp %q. Debug mode: %t", c.UDP, c.TCP, c.Dbg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		// catch signal and invoke graceful termination
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		log.Printf("[WARN] interrupt signal")
+		cancel()
+	}()
+
+	ingester, err := c.newIngester()
+	if err != nil {
+		return err
+	}
+	if err := ingester.run(ctx); err != nil {
+		log.Printf("[ERROR] terminated with error %+v", err)
+		return err
+	}
+
+	log.Printf("[INFO] terminated")
+	return nil
+}
+
+func (c *Command) newIngester() (*ingester, error) {
+	extractor, err := syslog.NewExtractor(c.SDID, c.UserParam, c.IPParam, c.MessageRegexp)
+	if err != nil {
+		return nil, err
+	}
+
+	boltDB, err := record.NewBoltDB(c.BoltDBName, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	recordRepo, err := record.NewBoltRepository(boltDB)
+	if err != nil {
+		boltDB.Close()
+		return nil, err
+	}
+	recordService := record.NewService(recordRepo)
+
+	srv := syslog.NewServer(recordService, extractor, syslog.Options{
+		UDPAddr:       c.UDP,
+		TCPAddr:       c.TCP,
+		BatchSize:     c.BatchSize,
+		FlushInterval: c.FlushInterval,
+	})
+	if err := srv.Listen(); err != nil {
+		boltDB.Close()
+		return nil, err
+	}
+
+	i := &ingester{
+		Command: c,
+		srv:     srv,
+		services: &services{
+			recordService: recordService,
+		},
+		sharedResources: &sharedResources{
+			boltDB: boltDB,
+		},
+		terminated: make(chan struct{}),
+	}
+	return i, nil
+}
+
+func (i *ingester) run(ctx context.Context) error {
+	defer close(i.terminated)
+	defer i.sharedResources.Close()
+
+	i.srv.Run(ctx)
+	log.Print("[INFO] ingester was shut down")
+	return nil
+}
+
+func (i *ingester) Wait() {
+	<-i.terminated
+}
@@ -0,0 +1,65 @@
+package ingest
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDb = "/tmp/test_ingest.db"
+
+func TestIngest(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+
+	c := &Command{
+		UDP:           "127.0.0.1:0",
+		BatchSize:     10,
+		FlushInterval: time.Hour,
+		UserParam:     "user_id",
+		IPParam:       "ip",
+		MessageRegexp: `user=(?P<user_id>\d+) ip=(?P<ip>\S+)`,
+		CommonOpts:    cmd.CommonOpts{BoltDBName: testDb},
+	}
+	i, err := c.newIngester()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		assert.NoError(t, i.run(ctx))
+	}()
+
+	conn, err := net.Dial("udp", i.srv.UDPAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	for _, msg := range []string{
+		`<14>1 - - app - - [access user_id="1" ip="1.1.1.1"]`,
+		`<14>1 - - app - - [access user_id="1" ip="2.2.2.2"]`,
+		`<14>app: user=2 ip=1.1.1.1`,
+		`<14>app: user=2 ip=2.2.2.2`,
+	} {
+		_, err = conn.Write([]byte(msg))
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool { return i.srv.Stats().Received == 4 }, time.Second, 10*time.Millisecond)
+
+	// records are committed on shutdown, reopen the store to check them
+	cancel()
+	i.Wait()
+
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	repo, err := record.NewBoltRepository(db)
+	require.NoError(t, err)
+	res, err := record.NewService(repo).IsDuple(context.Background(), 1, 2)
+	require.NoError(t, err)
+	assert.True(t, res)
+}
@@ -0,0 +1,50 @@
+package migrate
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+// Command upgrades the store layout to the version of this build
+type Command struct {
+	DryRun bool `long:"dry_run" description:"apply pending migrations within a transaction which is rolled back"`
+
+	cmd.CommonOpts
+}
+
+// Execute command applies pending migrations
+func (c *Command) Execute(args []string) error {
+	boltDB, err := record.NewBoltDB(c.BoltDBName, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", c.BoltDBName)
+	}
+	defer boltDB.Close()
+
+	s, err := record.GetSchema(boltDB)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] %s schema version is %d, current version is %d", c.BoltDBName, s.Version, record.SchemaVersion)
+
+	applied, err := record.Migrate(context.Background(), boltDB, c.DryRun)
+	verb := "applied"
+	if c.DryRun {
+		verb = "can be applied"
+	}
+	for _, m := range applied {
+		log.Printf("[INFO] migration %d %s: %s", m.Version, verb, m.Description)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		log.Printf("[INFO] %s is up to date", c.BoltDBName)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package migrate
+
+import (
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDb = "/tmp/test_migrate.db"
+
+func TestExecute(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+	// the store created before versioning has users bucket only
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("USER_INFO"))
+		return err
+	}))
+	require.NoError(t, db.Close())
+
+	c := &Command{DryRun: true, CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
+	require.NoError(t, c.Execute(nil))
+	assert.Equal(t, 0, version(t))
+
+	c.DryRun = false
+	require.NoError(t, c.Execute(nil))
+	assert.Equal(t, record.SchemaVersion, version(t))
+}
+
+func version(t *testing.T) int {
+	db, err := record.NewBoltDB(testDb, &bolt.Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer db.Close()
+	s, err := record.GetSchema(db)
+	require.NoError(t, err)
+	return s.Version
+}
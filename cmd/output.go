@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+)
+
+// CreateOutput creates buffered file writer, gzipped if gz is set. Path "-" stands for stdout.
+// Close must be called to flush everything
+func CreateOutput(path string, gz bool) (io.WriteCloser, error) {
+	var f *os.File
+	if path == "-" {
+		f = os.Stdout
+	} else {
+		var err error
+		if f, err = os.Create(path); err != nil {
+			return nil, err
+		}
+	}
+
+	o := &output{f: f}
+	o.buf = bufio.NewWriterSize(f, 1<<16)
+	o.w = o.buf
+	if gz {
+		o.gz = gzip.NewWriter(o.buf)
+		o.w = o.gz
+	}
+	return o, nil
+}
+
+type output struct {
+	f   *os.File
+	buf *bufio.Writer
+	gz  *gzip.Writer
+	w   io.Writer
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	return o.w.Write(p)
+}
+
+// Close flushes all layers and closes the file unless it's stdout
+func (o *output) Close() error {
+	var err error
+	if o.gz != nil {
+		err = o.gz.Close()
+	}
+	if e := o.buf.Flush(); err == nil {
+		err = e
+	}
+	if o.f != os.Stdout {
+		if e := o.f.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+package purge
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+// Command removes users' IPs which aren't seen for a while
+type Command struct {
+	OlderThan time.Duration `long:"older_than" env:"CHECKER_PURGE_OLDER_THAN" required:"true" description:"remove IPs last seen before this duration ago, e.g. 4320h for 180 days"`
+	Tenant    string        `long:"tenant" env:"CHECKER_PURGE_TENANT" description:"tenant whose users are purged, it should exist. Empty is the default tenant"`
+
+	cmd.CommonOpts
+}
+
+// Execute command purges expired IPs
+func (c *Command) Execute(args []string) error {
+	if c.OlderThan <= 0 {
+		return errors.Errorf("older than should be positive, got %s", c.OlderThan)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		log.Printf("[WARN] interrupt signal")
+		cancel()
+	}()
+
+	boltDB, err := record.NewBoltDB(c.BoltDBName, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", c.BoltDBName)
+	}
+	defer boltDB.Close()
+	recordRepo, err := record.OpenTenantRepository(boltDB, record.Tenant(c.Tenant))
+	if err != nil {
+		return err
+	}
+
+	olderThan := time.Now().Add(-c.OlderThan)
+	res, err := recordRepo.Purge(ctx, olderThan)
+	if err != nil {
+		return errors.Wrapf(err, "purge is stopped after %d IPs and %d users are removed", res.IPs, res.Users)
+	}
+	log.Printf("[INFO] %d IPs last seen before %s are removed, %d users are left without IPs and removed",
+		res.IPs, olderThan.UTC().Format(time.RFC3339), res.Users)
+	return nil
+}
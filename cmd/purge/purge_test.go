@@ -0,0 +1,55 @@
+package purge
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDb = "/tmp/test_purge.db"
+
+func TestExecute(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+	ctx := context.Background()
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	repo, err := record.NewBoltRepository(db)
+	require.NoError(t, err)
+	require.NoError(t, repo.BulkAddRecords(ctx, []*record.Record{record.NewRecord(1, "1.1.1.1")}))
+	require.NoError(t, db.Close())
+
+	c := &Command{OlderThan: time.Hour, CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
+	require.NoError(t, c.Execute(nil))
+	assert.Equal(t, 1, users(t))
+
+	// a non-positive duration would remove every IP, so it's rejected
+	c.OlderThan = -time.Hour
+	assert.Error(t, c.Execute(nil))
+	assert.Equal(t, 1, users(t))
+
+	// a tenant is purged only if it exists
+	c.OlderThan, c.Tenant = time.Hour, "acme"
+	assert.Equal(t, record.ErrUnknownTenant, errors.Cause(c.Execute(nil)))
+}
+
+func users(t *testing.T) int {
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	repo, err := record.OpenBoltRepository(db)
+	require.NoError(t, err)
+	var n int
+	require.NoError(t, repo.ForEachUser(context.Background(), func(info *record.UserInfo) error {
+		n++
+		return nil
+	}))
+	return n
+}
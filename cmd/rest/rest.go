@@ -13,12 +13,20 @@ import (
 	"github.com/boltdb/bolt"
 	"github.com/gin-gonic/gin"
 	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/backup"
+	"github.com/mullakhmetov/duplicates-checker/internal/dataset"
 	"github.com/mullakhmetov/duplicates-checker/internal/healthcheck"
+	"github.com/mullakhmetov/duplicates-checker/internal/idempotency"
 	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/mullakhmetov/duplicates-checker/internal/stats"
 )
 
 type services struct {
 	recordService record.Service
+	records       record.Repository
+	committer     *record.GroupCommitter
+	datasets      *dataset.Manager
+	tenants       []*tenant
 }
 
 type sharedResources struct {
@@ -32,7 +40,8 @@ func (s *sharedResources) Close() {
 
 type server struct {
 	*Command
-	srv *http.Server
+	srv    *http.Server
+	router *gin.Engine
 
 	*services
 	*sharedResources
@@ -42,7 +51,21 @@ type server struct {
 
 // Command starts http server
 type Command struct {
-	Port int `long:"port" env:"CHECKER_PORT" default:"8080" description:"port"`
+	Port               int           `long:"port" env:"CHECKER_PORT" default:"8080" description:"port"`
+	WriteQueue         int           `long:"write_queue" env:"CHECKER_WRITE_QUEUE" default:"1024" description:"write requests waiting for commit, requests above are rejected with 429"`
+	CommitBatch        int           `long:"commit_batch" env:"CHECKER_COMMIT_BATCH" default:"10000" description:"max records written in a single transaction"`
+	Dataset            string        `long:"dataset" env:"CHECKER_DATASET" description:"bolt file or index built offline, it's loaded read-only replacing the served default tenant on SIGHUP or POST /admin/reload. Tenants are served from --boltdbname"`
+	WatchInterval      time.Duration `long:"watch_interval" env:"CHECKER_WATCH_INTERVAL" description:"reload --dataset every time the file is changed, checking it with this interval. 0 disables watching"`
+	CompactInterval    time.Duration `long:"compact_interval" env:"CHECKER_COMPACT_INTERVAL" description:"compact --dataset file and reload it with this interval. 0 disables compaction"`
+	CompactFillPercent float64       `long:"compact_fill_percent" env:"CHECKER_COMPACT_FILL_PERCENT" default:"1" description:"fill percent of pages of the compacted dataset, it's read-only so pages may be full"`
+	CacheBytes         int64         `long:"cache_bytes" env:"CHECKER_CACHE_BYTES" default:"67108864" description:"approximate memory taken by cached users' info, every tenant has its own cache. 0 disables caching"`
+	CacheTTL           time.Duration `long:"cache_ttl" env:"CHECKER_CACHE_TTL" default:"1m" description:"how long a user's info is cached, writes of other processes are seen after it. 0 keeps users until they're evicted"`
+	SweepInterval      time.Duration `long:"sweep_interval" env:"CHECKER_SWEEP_INTERVAL" default:"1h" description:"remove IPs expired by --retention with this interval, the read-only store isn't swept"`
+	BackupDir          string        `long:"backup_dir" env:"CHECKER_BACKUP_DIR" description:"dir for snapshots written by POST /admin/backup. Empty disables the endpoint"`
+	ReadOnly           bool          `long:"read_only" env:"CHECKER_READ_ONLY" description:"open the store read-only with a shared lock, so several servers may serve the same file. Write endpoints are rejected"`
+	cmd.RulesOpts
+	cmd.TenantOpts
+	cmd.IdempotencyOpts
 	cmd.CommonOpts
 }
 
@@ -64,6 +87,16 @@ func (c *Command) Execute(args []string) error {
 	if err != nil {
 		return err
 	}
+	go func() {
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		for range reload {
+			log.Printf("[INFO] reload signal")
+			if _, err := server.datasets.Reload(ctx); err != nil {
+				log.Printf("[ERROR] failed to reload dataset: %+v", err)
+			}
+		}
+	}()
 	err = server.run(ctx)
 	if err != nil {
 		log.Printf("[ERROR] terminated with error %+v", err)
@@ -79,20 +112,72 @@ func (c *Command) newServer() (*server, error) {
 	if !c.Dbg {
 		gin.SetMode("release")
 	}
+	router.Use(record.TenantHeaderMiddleware(router))
+	rules, err := c.Rules()
+	if err != nil {
+		return nil, err
+	}
+	tenantConfigs, err := c.LoadTenants(rules)
+	if err != nil {
+		return nil, err
+	}
 
 	healthcheck.RegisterHandlers(router, c.Revision)
 
-	boltDB, err := record.NewBoltDB(c.BoltDBName, &bolt.Options{Timeout: 1 * time.Second})
+	boltDB, recordRepo, err := c.openStore()
 	if err != nil {
 		return nil, err
 	}
-	recordRepo, err := record.NewBoltRepository(boltDB)
+
+	swappableRepo := record.NewSwappableRepository(recordRepo)
+	datasets := dataset.NewManager(swappableRepo, dataset.Status{
+		Path:     c.BoltDBName,
+		LoadedAt: time.Now().UTC(),
+		ReadOnly: c.ReadOnly,
+	}, c.Dataset)
+	dataset.RegisterHandlers(router, datasets)
+
+	statsCache, err := stats.NewBoltRepository(boltDB)
 	if err != nil {
+		boltDB.Close()
 		return nil, err
 	}
+	stats.RegisterHandlers(router, stats.NewService(swappableRepo, statsCache, !c.ReadOnly))
+
+	backup.RegisterHandlers(router, backup.NewService(boltDB, c.BackupDir, c.Revision))
+
+	records := record.Repository(swappableRepo)
+	if cache := c.newCache(router, swappableRepo); cache != nil {
+		// users of a swapped dataset are read again
+		swappableRepo.OnSwap(cache.Reset)
+		records = cache
+	}
+	recordService := record.NewServiceWithRules(records, rules)
+	// admin handlers swapping the dataset must not hold it
+	record.RegisterHandlers(router.Group("", record.HoldHandler(swappableRepo)), recordService)
 
-	recordService := record.NewService(recordRepo)
-	record.RegisterHandlers(router, recordService)
+	var committer *record.GroupCommitter
+	var keys *idempotency.Store
+	if c.ReadOnly {
+		record.RegisterReadOnlyIngestHandlers(router)
+	} else {
+		if keys, err = c.IdempotencyStore(boltDB); err != nil {
+			boltDB.Close()
+			return nil, err
+		}
+		committer = record.NewGroupCommitter(recordService, c.WriteQueue, c.CommitBatch)
+		record.RegisterIngestHandlers(router, committer, keys)
+		record.RegisterDeleteHandlers(router, recordService)
+	}
+
+	tenants, err := c.serveTenants(router, boltDB, keys, tenantConfigs)
+	if err != nil {
+		if committer != nil {
+			committer.Close()
+		}
+		boltDB.Close()
+		return nil, err
+	}
 
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%d", c.Port),
@@ -102,8 +187,13 @@ func (c *Command) newServer() (*server, error) {
 	s := &server{
 		Command: c,
 		srv:     srv,
+		router:  router,
 		services: &services{
 			recordService: recordService,
+			records:       records,
+			committer:     committer,
+			datasets:      datasets,
+			tenants:       tenants,
 		},
 		sharedResources: &sharedResources{
 			boltDB: boltDB,
@@ -113,19 +203,78 @@ func (c *Command) newServer() (*server, error) {
 	return s, nil
 }
 
+// newCache returns cache of repo and registers its handlers, it's nil if caching is disabled
+func (c *Command) newCache(router gin.IRoutes, repo record.Repository) *record.CachedRepository {
+	if c.CacheBytes <= 0 {
+		return nil
+	}
+	cache := record.NewCachedRepository(repo, c.CacheBytes, c.CacheTTL)
+	record.RegisterCacheHandlers(router, cache)
+	return cache
+}
+
+// openStore opens bolt file. Read-only store is opened with a shared lock and must exist already
+func (c *Command) openStore() (*bolt.DB, record.Repository, error) {
+	boltDB, err := record.NewBoltDB(c.BoltDBName, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: c.ReadOnly})
+	if err != nil {
+		return nil, nil, err
+	}
+	var recordRepo record.Repository
+	if c.ReadOnly {
+		recordRepo, err = record.OpenBoltRepository(boltDB)
+	} else {
+		recordRepo, err = record.NewBoltRepository(boltDB)
+	}
+	if err != nil {
+		boltDB.Close()
+		return nil, nil, err
+	}
+	return boltDB, recordRepo, nil
+}
+
 func (s *server) run(ctx context.Context) error {
+	if s.Dataset != "" && s.WatchInterval > 0 {
+		go s.datasets.Watch(ctx, s.WatchInterval)
+	}
+	if s.Dataset != "" && s.CompactInterval > 0 {
+		go s.datasets.CompactEvery(ctx, s.CompactInterval, s.CompactFillPercent)
+	}
+	if s.Retention > 0 && !s.ReadOnly {
+		go record.Sweep(ctx, s.records, s.Retention, s.SweepInterval)
+	}
+	for _, t := range s.tenants {
+		if t.rules.Retention > 0 && !s.ReadOnly {
+			go record.Sweep(ctx, t.repo, t.rules.Retention, s.SweepInterval)
+		}
+	}
+
+	shutdown := make(chan struct{})
 	go func() {
 		// Graceful shutdown
 		<-ctx.Done()
-		s.srv.Shutdown(ctx)
+		s.srv.Shutdown(context.Background())
+		if s.committer != nil {
+			s.committer.Close()
+		}
+		for _, t := range s.tenants {
+			if t.committer != nil {
+				t.committer.Close()
+			}
+		}
+		if err := s.datasets.Close(); err != nil {
+			log.Printf("[WARN] failed to close dataset: %+v", err)
+		}
 		s.sharedResources.Close()
 		log.Print("[INFO] server was shut down")
+		close(shutdown)
 	}()
 
 	if err := s.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return err
 	}
 
+	// resources must be released before the server is reported as terminated
+	<-shutdown
 	close(s.terminated)
 	return nil
 }
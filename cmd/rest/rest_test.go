@@ -7,6 +7,7 @@ import (
 	"math/rand"
 	"net"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"syscall"
@@ -15,6 +16,7 @@ import (
 
 	"github.com/jessevdk/go-flags"
 	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -27,8 +29,8 @@ func TestRest(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
 		server.run(ctx)
-		waitForHTTPServerStart(port)
 	}()
+	waitForHTTPServerStart(port)
 
 	// healthcheck
 	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/ping", port))
@@ -43,6 +45,243 @@ func TestRest(t *testing.T) {
 	server.Wait()
 }
 
+func TestRest_Ingest(t *testing.T) {
+	_ = os.Remove("test.db")
+	port := chooseRandomUnusedPort()
+	c := newCommand(port)
+	server, err := c.newServer()
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		server.run(ctx)
+	}()
+	waitForHTTPServerStart(port)
+
+	body := `[{"user_id": 1, "ip": "1.1.1.1"}, {"user_id": 1, "ip": "2.2.2.2"}, {"user_id": 2, "ip": "1.1.1.1"}]`
+	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/records/bulk", port), "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+
+	resp, err = http.Post(fmt.Sprintf("http://localhost:%d/records", port), "application/json", strings.NewReader(`{"user_id": 2, "ip": "2.2.2.2"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+
+	resp, err = http.Get(fmt.Sprintf("http://localhost:%d/duples/1/2", port))
+	require.NoError(t, err)
+	body2, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"dupes": true}`, string(body2))
+
+	cancel()
+	server.Wait()
+}
+
+func TestRest_Reload(t *testing.T) {
+	_ = os.Remove("test.db")
+	_ = os.Remove("dataset.db")
+	defer os.Remove("dataset.db")
+
+	db, err := record.NewBoltDB("dataset.db", nil)
+	require.NoError(t, err)
+	repo, err := record.NewBoltRepository(db)
+	require.NoError(t, err)
+	require.NoError(t, repo.BulkAddRecords(context.Background(), []*record.Record{
+		record.NewRecord(1, "1.1.1.1"), record.NewRecord(1, "2.2.2.2"),
+		record.NewRecord(2, "1.1.1.1"), record.NewRecord(2, "2.2.2.2"),
+	}))
+	require.NoError(t, db.Close())
+
+	port := chooseRandomUnusedPort()
+	c := newCommand(port)
+	c.Dataset = "dataset.db"
+	server, err := c.newServer()
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		server.run(ctx)
+	}()
+	waitForHTTPServerStart(port)
+
+	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/admin/reload", port), "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+
+	resp, err = http.Get(fmt.Sprintf("http://localhost:%d/duples/1/2", port))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"dupes": true}`, string(body))
+
+	resp, err = http.Get(fmt.Sprintf("http://localhost:%d/admin/status", port))
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"path":"dataset.db"`)
+
+	// loaded dataset is read-only
+	resp, err = http.Post(fmt.Sprintf("http://localhost:%d/records", port), "application/json", strings.NewReader(`{"user_id": 3, "ip": "3.3.3.3"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	cancel()
+	server.Wait()
+}
+
+func TestRest_ReadOnly(t *testing.T) {
+	_ = os.Remove("test.db")
+	db, err := record.NewBoltDB("test.db", nil)
+	require.NoError(t, err)
+	repo, err := record.NewBoltRepository(db)
+	require.NoError(t, err)
+	require.NoError(t, repo.BulkAddRecords(context.Background(), []*record.Record{
+		record.NewRecord(1, "1.1.1.1"), record.NewRecord(1, "2.2.2.2"),
+		record.NewRecord(2, "1.1.1.1"), record.NewRecord(2, "2.2.2.2"),
+	}))
+	require.NoError(t, db.Close())
+
+	// several read-only servers share the same file
+	ctx, cancel := context.WithCancel(context.Background())
+	var servers []*server
+	var ports []int
+	for i := 0; i < 2; i++ {
+		port := chooseRandomUnusedPort()
+		c := newCommand(port)
+		c.ReadOnly = true
+		server, err := c.newServer()
+		require.NoError(t, err)
+		go func() {
+			server.run(ctx)
+		}()
+		waitForHTTPServerStart(port)
+		servers = append(servers, server)
+		ports = append(ports, port)
+	}
+
+	for _, port := range ports {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/duples/1/2", port))
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"dupes": true}`, string(body))
+
+		resp, err = http.Post(fmt.Sprintf("http://localhost:%d/records/bulk", port), "application/json", strings.NewReader(`[{"user_id": 3, "ip": "3.3.3.3"}]`))
+		require.NoError(t, err)
+		body, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.JSONEq(t, `{"error": "server is read-only"}`, string(body))
+
+		// stats are computed but not saved to the read-only store
+		resp, err = http.Get(fmt.Sprintf("http://localhost:%d/admin/stats", port))
+		require.NoError(t, err)
+		body, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, string(body), `"users":2`)
+	}
+
+	cancel()
+	for _, s := range servers {
+		s.Wait()
+	}
+}
+
+func TestRest_Tenants(t *testing.T) {
+	_ = os.Remove("test.db")
+	tenantsFile := "/tmp/test_rest_tenants.json"
+	require.NoError(t, ioutil.WriteFile(tenantsFile, []byte(`{"acme": {"common_ips": 1}, "beta": {}}`), 0600))
+	defer os.Remove(tenantsFile)
+	port := chooseRandomUnusedPort()
+	c := newCommand(port)
+	c.Tenants = tenantsFile
+	server, err := c.newServer()
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		server.run(ctx)
+	}()
+	waitForHTTPServerStart(port)
+	url := fmt.Sprintf("http://localhost:%d", port)
+
+	do := func(method, path, tenant, body string, headers ...string) (int, string) {
+		req, err := http.NewRequest(method, url+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if tenant != "" {
+			req.Header.Set(record.TenantHeader, tenant)
+		}
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(buf)
+	}
+
+	// the same user ids in every tenant
+	records := `[{"user_id": 1, "ip": "1.1.1.1"}, {"user_id": 1, "ip": "2.2.2.2"}, {"user_id": 2, "ip": "1.1.1.1"}, {"user_id": 2, "ip": "2.2.2.2"}]`
+	code, _ := do("POST", "/records/bulk", "", records, "Idempotency-Key", "k1")
+	assert.Equal(t, 200, code)
+	code, _ = do("POST", "/t/acme/records", "", `{"user_id": 1, "ip": "1.1.1.1"}`)
+	assert.Equal(t, 200, code)
+	code, _ = do("POST", "/records", "acme", `{"user_id": 2, "ip": "1.1.1.1"}`)
+	assert.Equal(t, 200, code)
+	// keys of different tenants never match
+	code, body := do("POST", "/t/beta/records/bulk", "", `[{"user_id": 1, "ip": "3.3.3.3"}]`, "Idempotency-Key", "k1")
+	assert.Equal(t, 200, code)
+	assert.JSONEq(t, `{"added": 1}`, body)
+
+	for _, c := range []struct {
+		path, tenant, dupes string
+	}{
+		{"/duples/1/2", "", "true"},
+		// a single common IP makes acme users duplicates
+		{"/t/acme/duples/1/2", "", "true"},
+		{"/duples/1/2", "acme", "true"},
+		{"/t/beta/duples/1/2", "", "false"},
+		{"/duples/1/2", "beta", "false"},
+	} {
+		code, body := do("GET", c.path, c.tenant, "")
+		assert.Equal(t, 200, code, c)
+		assert.JSONEq(t, `{"dupes": `+c.dupes+`}`, body, c)
+	}
+
+	code, body = do("DELETE", "/users/1?ref=ticket-1", "acme", "")
+	assert.Equal(t, 200, code)
+	assert.JSONEq(t, `{"deleted": true}`, body)
+	code, body = do("GET", "/t/acme/duples/1/2", "", "")
+	assert.Equal(t, 200, code)
+	assert.JSONEq(t, `{"dupes": false}`, body)
+	_, body = do("GET", "/duples/1/2", "", "")
+	assert.JSONEq(t, `{"dupes": true}`, body, "the default tenant's user isn't deleted")
+
+	code, _ = do("GET", "/t/unknown/duples/1/2", "", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = do("GET", "/duples/1/2", "unknown", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = do("GET", "/duples/1/2", "../acme", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = do("GET", "/t/acme/duples/1/2", "beta", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = do("GET", "/ping", "unknown", "")
+	assert.Equal(t, 200, code, "header of paths which aren't served per tenant is ignored")
+
+	cancel()
+	server.Wait()
+}
+
 func TestRest_Shutdown(t *testing.T) {
 	port := chooseRandomUnusedPort()
 	c := newCommand(port)
@@ -92,7 +331,14 @@ func waitForHTTPServerStart(port int) {
 }
 
 func newCommand(port int) *Command {
-	return &Command{Port: port, CommonOpts: cmd.CommonOpts{BoltDBName: "test.db"}}
+	return &Command{
+		Port:            port,
+		WriteQueue:      16,
+		RulesOpts:       cmd.RulesOpts{CommonIPs: 2},
+		CommitBatch:     100,
+		IdempotencyOpts: cmd.IdempotencyOpts{IdempotencyTTL: time.Hour, IdempotencyMaxKeys: 100},
+		CommonOpts:      cmd.CommonOpts{BoltDBName: "test.db"},
+	}
 }
 
 func chooseRandomUnusedPort() (port int) {
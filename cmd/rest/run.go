@@ -0,0 +1,133 @@
+package rest
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/cmd/importer"
+	"github.com/mullakhmetov/duplicates-checker/internal/checkpoint"
+	"github.com/pkg/errors"
+)
+
+// RunCommand starts http server and imports records in the same process sharing the store
+type RunCommand struct {
+	Source        string `long:"source" env:"CHECKER_RUN_SOURCE" choice:"generator" choice:"file" choice:"follow" default:"generator" description:"records source, follow keeps loading lines appended to --file"`
+	File          string `long:"file" env:"CHECKER_RUN_FILE" description:"plaintext file with user_id,ip_addr lines for file and follow sources"`
+	ImportRate    int    `long:"import_rate" env:"CHECKER_RUN_IMPORT_RATE" default:"50000" description:"max records imported per second, keeps read latency low. 0 is unlimited"`
+	ImportBatch   int    `long:"import_batch" env:"CHECKER_RUN_IMPORT_BATCH" default:"5000" description:"records committed at once, small batches keep write transactions short"`
+	ImportWorkers int    `long:"import_workers" env:"CHECKER_RUN_IMPORT_WORKERS" default:"1" description:"import parser workers count"`
+
+	cmd.GeneratorOpts
+	Command
+}
+
+// Execute command starts Rest server and the import
+func (c *RunCommand) Execute(args []string) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	log.Printf("[INFO] start server on port %d importing from %s. Debug mode: %t", c.Port, c.Source, c.Dbg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		// catch signal and invoke graceful termination
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		log.Printf("[WARN] interrupt signal")
+		cancel()
+	}()
+
+	if err := c.run(ctx); err != nil {
+		log.Printf("[ERROR] terminated with error %+v", err)
+		return err
+	}
+	log.Printf("[INFO] terminated")
+	return nil
+}
+
+// run blocks until ctx is cancelled and the import is stopped
+func (c *RunCommand) run(ctx context.Context) error {
+	server, err := c.newServer()
+	if err != nil {
+		return err
+	}
+	runRepo, err := checkpoint.NewBoltRepository(server.boltDB)
+	if err != nil {
+		server.sharedResources.Close()
+		return err
+	}
+	var mu sync.Mutex
+	var runID string
+	checkpoint.RegisterHandlers(server.router, runRepo, func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return runID
+	})
+
+	// the server keeps serving after the import is finished, it's stopped only after the import
+	// is interrupted since the store is closed on stop
+	serverCtx, stopServer := context.WithCancel(context.Background())
+	imported := make(chan struct{})
+	go func() {
+		defer close(imported)
+		err := c.importCommand().Load(ctx, server.boltDB, server.recordService, func(run *checkpoint.Run) {
+			mu.Lock()
+			runID = run.ID
+			mu.Unlock()
+			log.Printf("[INFO] start import run %s", run.ID)
+		})
+		switch {
+		case ctx.Err() != nil:
+			log.Printf("[INFO] import interrupted")
+		case err != nil:
+			log.Printf("[ERROR] import failed %+v", err)
+		default:
+			log.Printf("[INFO] import finished")
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		<-imported
+		stopServer()
+	}()
+
+	return server.run(serverCtx)
+}
+
+func (c *RunCommand) validate() error {
+	if c.ReadOnly {
+		return errors.New("read-only store can't be imported to")
+	}
+	if c.Dataset != "" {
+		return errors.New("dataset reload can't be used while importing")
+	}
+	if (c.Source == "file" || c.Source == "follow") && c.File == "" {
+		return errors.Errorf("--file is required for %s source", c.Source)
+	}
+	return c.GeneratorOpts.Validate()
+}
+
+// importCommand returns import options of the run. Invalid records are skipped, so the import isn't
+// stopped by a single malformed line of a followed file
+func (c *RunCommand) importCommand() *importer.Command {
+	ic := &importer.Command{
+		Workers:         c.ImportWorkers,
+		Rate:            c.ImportRate,
+		Batch:           c.ImportBatch,
+		OnInvalid:       "skip",
+		GeneratorOpts:   c.GeneratorOpts,
+		IdempotencyOpts: c.IdempotencyOpts,
+		CommonOpts:      c.CommonOpts,
+	}
+	if c.Source != "generator" {
+		ic.File = c.File
+		ic.Follow = c.Source == "follow"
+	}
+	return ic
+}
@@ -0,0 +1,71 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCommand_Follow(t *testing.T) {
+	_ = os.Remove("test.db")
+	log := "conn_log.csv"
+	require.NoError(t, ioutil.WriteFile(log, []byte("user_id,ip_addr\n1,1.1.1.1\n2,1.1.1.1\n"), 0644))
+	defer os.Remove(log)
+
+	port := chooseRandomUnusedPort()
+	c := &RunCommand{Source: "follow", File: log, ImportRate: 1000, ImportBatch: 10, ImportWorkers: 1, Command: *newCommand(port)}
+	require.NoError(t, c.validate())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.run(ctx)
+	}()
+	waitForHTTPServerStart(port)
+
+	get := func(path string) string {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d%s", port, path))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return strings.TrimSpace(string(body))
+	}
+	require.Eventually(t, func() bool {
+		return get("/import/status") != `{"error":"import is not started"}`
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Contains(t, get("/import/status"), `"source":"follow"`)
+
+	// lines appended to the followed file are loaded while the server is serving
+	f, err := os.OpenFile(log, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("1,2.2.2.2\n2,2.2.2.2\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Eventually(t, func() bool {
+		return get("/duples/1/2") == `{"dupes":true}`
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Contains(t, get("/import/status"), `"records":4`)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestRunCommand_Validate(t *testing.T) {
+	c := &RunCommand{Source: "file"}
+	assert.Error(t, c.validate())
+	c = &RunCommand{Source: "generator", Command: Command{ReadOnly: true}}
+	assert.Error(t, c.validate())
+	c = &RunCommand{Source: "generator", GeneratorOpts: cmd.GeneratorOpts{DupRings: 1, RingSize: 2}}
+	assert.Error(t, c.validate())
+	c = &RunCommand{Source: "generator"}
+	assert.NoError(t, c.validate())
+}
@@ -0,0 +1,63 @@
+package rest
+
+import (
+	"log"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/idempotency"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+)
+
+// tenant is a tenant served along with the default one. Its handlers are bound to its own service,
+// repository and committer, so requests of the tenant never reach users of another one
+type tenant struct {
+	name      record.Tenant
+	rules     record.Rules
+	repo      record.Repository
+	committer *record.GroupCommitter
+}
+
+// serveTenants registers handlers of every configured tenant under its path. Tenants are served
+// from the store, the writable one creates buckets of new tenants. keys is nil for the read-only store
+func (c *Command) serveTenants(router *gin.Engine, boltDB *bolt.DB, keys *idempotency.Store, configs []cmd.TenantConfig) ([]*tenant, error) {
+	tenants := make([]*tenant, 0, len(configs))
+	closeAll := func() {
+		for _, t := range tenants {
+			if t.committer != nil {
+				t.committer.Close()
+			}
+		}
+	}
+	for _, conf := range configs {
+		t := &tenant{name: conf.Tenant, rules: conf.Rules}
+		var err error
+		if c.ReadOnly {
+			t.repo, err = record.OpenTenantRepository(boltDB, t.name)
+		} else {
+			t.repo, err = record.NewTenantRepository(boltDB, t.name)
+		}
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+
+		group := router.Group(record.TenantPath(t.name))
+		if cache := c.newCache(group, t.repo); cache != nil {
+			t.repo = cache
+		}
+		service := record.NewServiceWithRules(t.repo, t.rules)
+		record.RegisterHandlers(group, service)
+		if c.ReadOnly {
+			record.RegisterReadOnlyIngestHandlers(group)
+		} else {
+			t.committer = record.NewGroupCommitter(service, c.WriteQueue, c.CommitBatch)
+			record.RegisterIngestHandlers(group, t.committer, keys.Namespace(string(t.name)))
+			record.RegisterDeleteHandlers(group, service)
+		}
+		tenants = append(tenants, t)
+		log.Printf("[INFO] serve tenant %s, %d common IPs make duplicates, retention %s", t.name, t.rules.CommonIPs, t.rules.Retention)
+	}
+	return tenants, nil
+}
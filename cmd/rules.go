@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+// RulesOpts keeps rules of the default tenant, shared by the server and commands checking users offline,
+// so both give the same answers
+type RulesOpts struct {
+	CommonIPs int           `long:"common_ips" env:"CHECKER_COMMON_IPS" default:"2" description:"number of common IPs making users duplicates, tenants' rules default to it"`
+	Retention time.Duration `long:"retention" env:"CHECKER_RETENTION" description:"users' IPs not seen for longer are ignored by checks, e.g. 4320h for 180 days. 0 keeps IPs forever"`
+}
+
+// Rules returns validated rules of the default tenant
+func (o *RulesOpts) Rules() (record.Rules, error) {
+	if o.CommonIPs < 1 {
+		return record.Rules{}, errors.Errorf("common IPs should be positive, got %d", o.CommonIPs)
+	}
+	if o.Retention < 0 {
+		return record.Rules{}, errors.Errorf("retention should be non-negative, got %s", o.Retention)
+	}
+	return record.Rules{CommonIPs: o.CommonIPs, Retention: o.Retention}, nil
+}
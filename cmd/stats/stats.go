@@ -0,0 +1,129 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/mullakhmetov/duplicates-checker/internal/stats"
+	"github.com/pkg/errors"
+)
+
+// output formats
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// Command prints dataset statistics of the store
+type Command struct {
+	Cached bool   `long:"cached" description:"print the last saved stats instead of scanning the store"`
+	Save   bool   `long:"save" description:"save computed stats, so they're served by --cached and GET /admin/stats?cached=1. The store is opened for writing"`
+	Format string `long:"format" env:"CHECKER_STATS_FORMAT" choice:"text" choice:"json" default:"text" description:"output format"`
+	Out    string `long:"out" default:"-" description:"output file, - for stdout"`
+	Tenant string `long:"tenant" env:"CHECKER_STATS_TENANT" description:"tenant whose users are counted, tenants are never counted together. Empty is the default tenant, only its stats are saved and served by the server"`
+
+	cmd.CommonOpts
+}
+
+// Execute command computes stats and prints them
+func (c *Command) Execute(args []string) error {
+	if c.Cached && c.Save {
+		return errors.New("--cached and --save are mutually exclusive")
+	}
+	if c.Tenant != "" && (c.Cached || c.Save) {
+		return errors.New("stats of tenants aren't saved, --cached and --save are for the default tenant only")
+	}
+	boltDB, err := record.NewBoltDB(c.BoltDBName, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: !c.Save})
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", c.BoltDBName)
+	}
+	defer boltDB.Close()
+	recordRepo, err := record.OpenTenantRepository(boltDB, record.Tenant(c.Tenant))
+	if err != nil {
+		return err
+	}
+	cache, err := stats.NewBoltRepository(boltDB)
+	if err != nil {
+		return err
+	}
+
+	s, err := stats.NewService(recordRepo, cache, c.Save).Get(context.Background(), c.Cached)
+	if err != nil {
+		return err
+	}
+	out, err := cmd.CreateOutput(c.Out, false)
+	if err != nil {
+		return err
+	}
+	err = c.write(out, s)
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// write prints stats in the requested format
+func (c *Command) write(w io.Writer, s *stats.Stats) error {
+	if c.Format == formatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+
+	ew := &errWriter{w: w}
+	ew.printf("computed at:   %s in %s\n", s.ComputedAt.Format(time.RFC3339), s.Elapsed)
+	ew.printf("users:         %d\n", s.Users)
+	ew.printf("user IPs:      %d\n", s.UserIPs)
+	ew.printf("distinct IPs:  %d\n", s.IPs)
+	writeHistogram(ew, "IPs per user", s.IPsPerUser)
+	writeHistogram(ew, "users per IP", s.UsersPerIP)
+	ew.printf("top shared IPs:\n")
+	for _, ip := range s.TopShared {
+		ew.printf("  %-15s %d users\n", ip.IP, ip.Users)
+	}
+	if st := s.Storage; st != nil {
+		ew.printf("file size:     %d bytes, page size %d, %d free and %d pending pages\n",
+			st.FileSize, st.PageSize, st.FreePages, st.PendingPages)
+		names := make([]string, 0, len(st.Buckets))
+		for name := range st.Buckets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			b := st.Buckets[name]
+			ew.printf("bucket %s: %d keys, %d branch and %d leaf pages, %d of %d leaf bytes in use, depth %d\n",
+				name, b.KeyN, b.BranchPageN, b.LeafPageN, b.LeafInuse, b.LeafAlloc, b.Depth)
+		}
+	}
+	return ew.err
+}
+
+func writeHistogram(ew *errWriter, name string, h stats.Histogram) {
+	ew.printf("%s:\n", name)
+	for _, b := range h {
+		if b.Min == b.Max {
+			ew.printf("  %-15d %d\n", b.Min, b.Count)
+			continue
+		}
+		ew.printf("  %-15s %d\n", fmt.Sprintf("%d-%d", b.Min, b.Max), b.Count)
+	}
+}
+
+// errWriter keeps the first write error, so the text output is written without checking every line
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) printf(format string, args ...interface{}) {
+	if e.err == nil {
+		_, e.err = fmt.Fprintf(e.w, format, args...)
+	}
+}
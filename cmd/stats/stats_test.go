@@ -0,0 +1,79 @@
+package stats
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDb = "/tmp/test_stats_cmd.db"
+
+func TestExecute(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	repo, err := record.NewBoltRepository(db)
+	require.NoError(t, err)
+	require.NoError(t, repo.BulkAddRecords(context.Background(), []*record.Record{
+		record.NewRecord(1, "1.1.1.1"), record.NewRecord(1, "2.2.2.2"),
+		record.NewRecord(2, "1.1.1.1"), record.NewRecord(3, "3.3.3.3"),
+	}))
+	require.NoError(t, db.Close())
+	out := "/tmp/test_stats_cmd.out"
+	defer os.Remove(out)
+
+	c := &Command{Format: formatText, Out: out, CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
+	c.Cached = true
+	assert.Error(t, c.Execute(nil), "nothing is saved yet")
+
+	c.Cached, c.Save = false, true
+	require.NoError(t, c.Execute(nil))
+	res, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Contains(t, string(res), "users:         3\n")
+	assert.Contains(t, string(res), "distinct IPs:  3\n")
+	assert.Contains(t, string(res), "top shared IPs:\n  1.1.1.1         2 users\n")
+	assert.Contains(t, string(res), "bucket USER_INFO: 3 keys")
+
+	c.Cached, c.Save, c.Format = true, false, formatJSON
+	require.NoError(t, c.Execute(nil))
+	res, err = ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Contains(t, string(res), `"users": 3`)
+	assert.Contains(t, string(res), `"ips_per_user": [`)
+}
+
+func TestExecute_Tenant(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	_, err = record.NewBoltRepository(db)
+	require.NoError(t, err)
+	repo, err := record.NewTenantRepository(db, "acme")
+	require.NoError(t, err)
+	require.NoError(t, repo.BulkAddRecords(context.Background(), []*record.Record{
+		record.NewRecord(1, "1.1.1.1"), record.NewRecord(2, "1.1.1.1"),
+	}))
+	require.NoError(t, db.Close())
+	out := "/tmp/test_stats_cmd.out"
+	defer os.Remove(out)
+
+	c := &Command{Format: formatText, Out: out, Tenant: "acme", CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
+	require.NoError(t, c.Execute(nil))
+	res, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Contains(t, string(res), "users:         2\n")
+
+	c.Save = true
+	assert.Error(t, c.Execute(nil), "stats of tenants aren't saved")
+	c.Save, c.Tenant = false, "unknown"
+	assert.Error(t, c.Execute(nil))
+}
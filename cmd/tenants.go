@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+// TenantOpts keeps options of tenants served along with the default one
+type TenantOpts struct {
+	Tenants string `long:"tenants" env:"CHECKER_TENANTS" description:"JSON file with served tenants and their rules, e.g. {\"acme\":{\"common_ips\":3,\"retention\":\"4320h\"}}"`
+}
+
+// TenantConfig is a served tenant with its rules
+type TenantConfig struct {
+	Tenant record.Tenant
+	Rules  record.Rules
+}
+
+// tenantRules is the rules of a tenant in --tenants file, rules which aren't set are the default ones
+type tenantRules struct {
+	CommonIPs int    `json:"common_ips"`
+	Retention string `json:"retention"`
+}
+
+// LoadTenants reads --tenants file and returns tenants in order of their names.
+// Rules which aren't set for a tenant are taken from defaults
+func (o *TenantOpts) LoadTenants(defaults record.Rules) ([]TenantConfig, error) {
+	if o.Tenants == "" {
+		return nil, nil
+	}
+	buf, err := ioutil.ReadFile(o.Tenants)
+	if err != nil {
+		return nil, err
+	}
+	var file map[record.Tenant]tenantRules
+	if err := json.Unmarshal(buf, &file); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode %s", o.Tenants)
+	}
+
+	tenants := make([]TenantConfig, 0, len(file))
+	for tenant, r := range file {
+		if tenant == record.DefaultTenant {
+			return nil, errors.Errorf("%s: rules of the default tenant are set by flags", o.Tenants)
+		}
+		if err := tenant.Validate(); err != nil {
+			return nil, errors.Wrap(err, o.Tenants)
+		}
+		rules := defaults
+		if r.CommonIPs < 0 {
+			return nil, errors.Errorf("%s: tenant %s common_ips should be positive, got %d", o.Tenants, tenant, r.CommonIPs)
+		}
+		if r.CommonIPs > 0 {
+			rules.CommonIPs = r.CommonIPs
+		}
+		if r.Retention != "" {
+			if rules.Retention, err = time.ParseDuration(r.Retention); err != nil || rules.Retention < 0 {
+				return nil, errors.Errorf("%s: tenant %s retention should be a non-negative duration, got %q", o.Tenants, tenant, r.Retention)
+			}
+		}
+		tenants = append(tenants, TenantConfig{Tenant: tenant, Rules: rules})
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].Tenant < tenants[j].Tenant })
+	return tenants, nil
+}
+
+// TenantRules returns rules of the tenant: defaults for the default tenant or if --tenants isn't set,
+// otherwise the tenant's rules of the file. A tenant missing in the file isn't served, so it's an error
+func (o *TenantOpts) TenantRules(tenant record.Tenant, defaults record.Rules) (record.Rules, error) {
+	if tenant == record.DefaultTenant || o.Tenants == "" {
+		return defaults, nil
+	}
+	tenants, err := o.LoadTenants(defaults)
+	if err != nil {
+		return record.Rules{}, err
+	}
+	for _, t := range tenants {
+		if t.Tenant == tenant {
+			return t.Rules, nil
+		}
+	}
+	return record.Rules{}, errors.Errorf("tenant %s isn't listed in %s", tenant, o.Tenants)
+}
@@ -0,0 +1,115 @@
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/backup"
+	"github.com/mullakhmetov/duplicates-checker/internal/checkpoint"
+	"github.com/mullakhmetov/duplicates-checker/internal/fsck"
+	"github.com/mullakhmetov/duplicates-checker/internal/idempotency"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/mullakhmetov/duplicates-checker/internal/stats"
+	"github.com/pkg/errors"
+)
+
+// exit codes, fsck like ones. Repaired store isn't 1 as fsck does, since 1 is exit code of any failed command
+const (
+	exitRepaired = 2
+	exitBroken   = 4
+	exitFailed   = 8
+)
+
+// output formats
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// Command checks integrity of the store
+type Command struct {
+	Repair bool   `long:"repair" description:"remove or rebuild broken entries, the store is opened for writing"`
+	Format string `long:"format" env:"CHECKER_VERIFY_FORMAT" choice:"text" choice:"json" default:"text" description:"report format"`
+
+	cmd.CommonOpts
+}
+
+// Execute command checks every bucket of the store and prints the report.
+// It fails with exit code 2 if the store is repaired, 4 if it's broken and 8 if it isn't checked
+func (c *Command) Execute(args []string) error {
+	boltDB, err := record.NewBoltDB(c.BoltDBName, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: !c.Repair})
+	if err != nil {
+		return &cmd.ExitError{Code: exitFailed, Err: errors.Wrapf(err, "failed to open %s", c.BoltDBName)}
+	}
+	defer boltDB.Close()
+
+	report, err := fsck.Run(boltDB, c.Repair, checks()...)
+	if err != nil {
+		return &cmd.ExitError{Code: exitFailed, Err: errors.Wrapf(err, "failed to check %s", c.BoltDBName)}
+	}
+	if err := c.write(os.Stdout, report); err != nil {
+		return &cmd.ExitError{Code: exitFailed, Err: err}
+	}
+
+	switch report.Status {
+	case fsck.Repaired:
+		return &cmd.ExitError{Code: exitRepaired, Err: errors.Errorf("%s is repaired", c.BoltDBName)}
+	case fsck.Broken:
+		return &cmd.ExitError{Code: exitBroken, Err: errors.Errorf("%s is broken", c.BoltDBName)}
+	}
+	log.Printf("[INFO] %s is clean", c.BoltDBName)
+	return nil
+}
+
+// checks returns checks of all buckets of the store
+func checks() []fsck.Check {
+	return []fsck.Check{record.Check(), record.AuditCheck(), idempotency.Check(), checkpoint.Check(), stats.Check(), backup.Check()}
+}
+
+// write prints the report in the requested format
+func (c *Command) write(w io.Writer, r *fsck.Report) error {
+	if c.Format == formatJSON {
+		return json.NewEncoder(w).Encode(r)
+	}
+
+	buckets := make([]string, 0, len(r.Checked))
+	for b := range r.Checked {
+		buckets = append(buckets, b)
+	}
+	sort.Strings(buckets)
+	for _, b := range buckets {
+		if _, err := fmt.Fprintf(w, "%s: %d entries checked\n", b, r.Checked[b]); err != nil {
+			return err
+		}
+	}
+	for _, b := range r.Unknown {
+		if _, err := fmt.Fprintf(w, "%s: unknown bucket\n", b); err != nil {
+			return err
+		}
+	}
+	for _, p := range r.Problems {
+		where := p.Bucket
+		if where == "" {
+			where = "pages"
+		}
+		if p.Key != "" {
+			where += " " + p.Key
+		}
+		fix := ""
+		if p.Repaired {
+			fix = " (repaired)"
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s%s\n", where, p.Error, fix); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "status: %s, %d problems\n", r.Status, len(r.Problems))
+	return err
+}
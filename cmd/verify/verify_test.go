@@ -0,0 +1,70 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/cmd"
+	"github.com/mullakhmetov/duplicates-checker/internal/fsck"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDb = "/tmp/test_verify.db"
+
+func TestExecute(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	repo, err := record.NewBoltRepository(db)
+	require.NoError(t, err)
+	require.NoError(t, repo.AddRecord(context.Background(), record.NewRecord(1, "1.1.1.1")))
+	require.NoError(t, db.Close())
+
+	c := &Command{Format: formatText, CommonOpts: cmd.CommonOpts{BoltDBName: testDb}}
+	require.NoError(t, c.Execute(nil))
+
+	db, err = record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("USER_INFO")).Put([]byte{0, 0, 0, 0, 0, 0, 0, 2}, []byte("{"))
+	}))
+	require.NoError(t, db.Close())
+
+	assert.Equal(t, exitBroken, exitCode(c.Execute(nil)))
+	c.Repair = true
+	assert.Equal(t, exitRepaired, exitCode(c.Execute(nil)))
+	c.Repair = false
+	require.NoError(t, c.Execute(nil))
+
+	c.BoltDBName = "/tmp/not_existing/test_verify.db"
+	assert.Equal(t, exitFailed, exitCode(c.Execute(nil)))
+}
+
+func TestWrite(t *testing.T) {
+	r := &fsck.Report{Checked: map[string]int{"USER_INFO": 2, "IMPORT_RUNS": 1}, Unknown: []string{"FOO"}}
+	r.Add("USER_INFO", "2", "failed to decode", true)
+	r.Add("", "", "page 3: unreachable unfreed", false)
+
+	var buf bytes.Buffer
+	require.NoError(t, (&Command{Format: formatText}).write(&buf, r))
+	assert.Equal(t, `IMPORT_RUNS: 1 entries checked
+USER_INFO: 2 entries checked
+FOO: unknown bucket
+USER_INFO 2: failed to decode (repaired)
+pages: page 3: unreachable unfreed
+status: broken, 2 problems
+`, buf.String())
+}
+
+func exitCode(err error) int {
+	if e, ok := err.(*cmd.ExitError); ok {
+		return e.Code
+	}
+	return 0
+}
@@ -0,0 +1,33 @@
+package backup
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterHandlers register backup handlers in router
+func RegisterHandlers(r *gin.Engine, service *Service) {
+	res := resource{service}
+
+	r.POST("/admin/backup", res.Backup)
+}
+
+type resource struct {
+	service *Service
+}
+
+func (r resource) Backup(c *gin.Context) {
+	s, err := r.service.Backup(c)
+	if err == ErrNoDir {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] failed to backup: %+v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to backup"})
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
@@ -0,0 +1,255 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/internal/fileutil"
+	"github.com/mullakhmetov/duplicates-checker/internal/fsck"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+const (
+	bucketName  = "BACKUP"
+	metadataKey = "metadata"
+)
+
+// ErrNoMetadata is returned when the file isn't a snapshot made by Create
+var ErrNoMetadata = errors.New("snapshot has no backup metadata")
+
+// Metadata describes a snapshot, it's stored in the snapshot itself
+type Metadata struct {
+	Revision  string    `json:"revision"`
+	CreatedAt time.Time `json:"created_at"`
+	Source    string    `json:"source"`
+	// Users and UserIPs are counted over every tenant
+	Users   uint64 `json:"users"`
+	UserIPs uint64 `json:"user_ips"`
+	// Tenants are counts of every tenant but the default one. Snapshots made before tenants were
+	// counted have none, only users of the default tenant are validated for them
+	Tenants map[record.Tenant]Counts `json:"tenants,omitempty"`
+}
+
+// Counts are numbers of users and their IPs
+type Counts struct {
+	Users   uint64 `json:"users"`
+	UserIPs uint64 `json:"user_ips"`
+}
+
+// Check returns the check of the embedded metadata bucket, undecodable metadata is removed on repair
+func Check() fsck.Check {
+	return fsck.JSONCheck(bucketName, func() interface{} { return &Metadata{} })
+}
+
+// Create writes a consistent snapshot of db to path within a single read transaction, so it may be called
+// while db is served. The snapshot is written to a temporary file and renamed once its metadata is embedded
+func Create(ctx context.Context, db *bolt.DB, path, revision string) (*Metadata, error) {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	meta := &Metadata{Revision: revision, Source: db.Path()}
+	err = db.View(func(tx *bolt.Tx) error {
+		meta.CreatedAt = time.Now().UTC()
+		_, err := tx.WriteTo(f)
+		return err
+	})
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = embed(ctx, tmp, meta)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return nil, errors.Wrapf(err, "failed to write snapshot %s", path)
+	}
+	return meta, fileutil.SyncDir(path)
+}
+
+// embed counts users of every tenant of the snapshot and stores meta in it
+func embed(ctx context.Context, path string, meta *Metadata) error {
+	db, err := record.NewBoltDB(path, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	err = func() error {
+		def, tenants, err := countAll(ctx, db)
+		if err != nil {
+			return err
+		}
+		meta.Users, meta.UserIPs = def.Users, def.UserIPs
+		for _, c := range tenants {
+			meta.Users += c.Users
+			meta.UserIPs += c.UserIPs
+		}
+		if len(tenants) > 0 {
+			meta.Tenants = tenants
+		}
+		buf, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		return db.Update(func(tx *bolt.Tx) error {
+			bkt, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+			if err != nil {
+				return errors.Wrapf(err, "failed to create bucket %s", bucketName)
+			}
+			return bkt.Put([]byte(metadataKey), buf)
+		})
+	}()
+	if e := db.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// Validate checks the snapshot at path: users bucket exists, every user of every tenant is decoded
+// and counts of every tenant match the embedded metadata. It returns the metadata
+func Validate(ctx context.Context, path string) (*Metadata, error) {
+	db, err := record.NewBoltDB(path, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", path)
+	}
+	defer db.Close()
+	if _, err := record.OpenBoltRepository(db); err != nil {
+		return nil, err
+	}
+
+	meta := &Metadata{}
+	err = db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucketName))
+		if bkt == nil {
+			return ErrNoMetadata
+		}
+		v := bkt.Get([]byte(metadataKey))
+		if v == nil {
+			return ErrNoMetadata
+		}
+		return json.Unmarshal(v, meta)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	def, tenants, err := countAll(ctx, db)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid snapshot %s", path)
+	}
+	total := def
+	if meta.Tenants != nil {
+		for _, c := range tenants {
+			total.Users += c.Users
+			total.UserIPs += c.UserIPs
+		}
+	}
+	if total.Users != meta.Users || total.UserIPs != meta.UserIPs {
+		return nil, errors.Errorf("snapshot %s has %d users and %d user IPs, metadata says %d and %d",
+			path, total.Users, total.UserIPs, meta.Users, meta.UserIPs)
+	}
+	for t, want := range meta.Tenants {
+		if got := tenants[t]; got != want {
+			return nil, errors.Errorf("snapshot %s has %d users and %d user IPs of tenant %s, metadata says %d and %d",
+				path, got.Users, got.UserIPs, t, want.Users, want.UserIPs)
+		}
+	}
+	return meta, nil
+}
+
+// Restore validates the snapshot and atomically replaces db file at path with it.
+// The existing file is locked first, so a file opened by a running process is never replaced
+func Restore(ctx context.Context, from, path string) (*Metadata, error) {
+	meta, err := Validate(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		db, err := record.NewBoltDB(path, &bolt.Options{Timeout: time.Second})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to lock %s, is it opened by another process", path)
+		}
+		// the lock is released after the file is replaced
+		defer db.Close()
+	}
+
+	tmp := path + ".restore"
+	err = copyFile(from, tmp)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return nil, errors.Wrapf(err, "failed to restore %s", path)
+	}
+	return meta, fileutil.SyncDir(path)
+}
+
+// countAll returns counts of the default tenant and of every other tenant
+func countAll(ctx context.Context, db *bolt.DB) (Counts, map[record.Tenant]Counts, error) {
+	repo, err := record.OpenBoltRepository(db)
+	if err != nil {
+		return Counts{}, nil, err
+	}
+	def, err := count(ctx, repo)
+	if err != nil {
+		return Counts{}, nil, err
+	}
+	names, err := record.Tenants(db)
+	if err != nil {
+		return Counts{}, nil, err
+	}
+	tenants := make(map[record.Tenant]Counts, len(names))
+	for _, t := range names {
+		repo, err := record.OpenTenantRepository(db, t)
+		if err != nil {
+			return Counts{}, nil, err
+		}
+		if tenants[t], err = count(ctx, repo); err != nil {
+			return Counts{}, nil, errors.Wrapf(err, "tenant %s", t)
+		}
+	}
+	return def, tenants, nil
+}
+
+// count returns number of users and their IPs, every user is decoded
+func count(ctx context.Context, repo record.Repository) (c Counts, err error) {
+	err = repo.ForEachUser(ctx, func(info *record.UserInfo) error {
+		c.Users++
+		c.UserIPs += uint64(len(info.IPs))
+		return nil
+	})
+	return c, err
+}
+
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(to, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if e := dst.Close(); err == nil {
+		err = e
+	}
+	return err
+}
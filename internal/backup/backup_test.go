@@ -0,0 +1,183 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testDb     = "/tmp/test_backup.db"
+	testBackup = "/tmp/test_backup_snapshot.db"
+	testDir    = "/tmp/test_backups"
+)
+
+func TestBackupRestore(t *testing.T) {
+	db, teardown := prepDB(t)
+	defer teardown()
+	ctx := context.Background()
+
+	meta, err := Create(ctx, db, testBackup, "rev1")
+	require.NoError(t, err)
+	assert.Equal(t, "rev1", meta.Revision)
+	assert.Equal(t, testDb, meta.Source)
+	assert.Equal(t, uint64(2), meta.Users)
+	assert.Equal(t, uint64(3), meta.UserIPs)
+	_, err = os.Stat(testBackup + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	// records added after the backup are gone after restore
+	repo, err := record.OpenBoltRepository(db)
+	require.NoError(t, err)
+	require.NoError(t, repo.AddRecord(ctx, record.NewRecord(3, "3.3.3.3")))
+
+	_, err = Restore(ctx, testBackup, testDb)
+	assert.Error(t, err, "db is locked by the test")
+	require.NoError(t, db.Close())
+
+	restored, err := Restore(ctx, testBackup, testDb)
+	require.NoError(t, err)
+	assert.Equal(t, meta.CreatedAt.Unix(), restored.CreatedAt.Unix())
+
+	db, err = record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	repo, err = record.OpenBoltRepository(db)
+	require.NoError(t, err)
+	info, err := repo.GetUserInfo(ctx, 3)
+	require.NoError(t, err)
+	assert.Empty(t, info.IPs)
+	info, err = repo.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Len(t, info.IPs, 2)
+}
+
+func TestValidate(t *testing.T) {
+	db, teardown := prepDB(t)
+	defer teardown()
+	ctx := context.Background()
+
+	// a plain db file isn't a snapshot
+	require.NoError(t, db.Close())
+	_, err := Validate(ctx, testDb)
+	assert.Equal(t, ErrNoMetadata, err)
+
+	db, err = record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	_, err = Create(ctx, db, testBackup, "rev1")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// counts don't match metadata
+	snapshot, err := record.NewBoltDB(testBackup, nil)
+	require.NoError(t, err)
+	repo, err := record.OpenBoltRepository(snapshot)
+	require.NoError(t, err)
+	require.NoError(t, repo.AddRecord(ctx, record.NewRecord(3, "3.3.3.3")))
+	require.NoError(t, snapshot.Close())
+	_, err = Validate(ctx, testBackup)
+	assert.EqualError(t, err, "snapshot /tmp/test_backup_snapshot.db has 3 users and 4 user IPs, metadata says 2 and 3")
+
+	// undecodable user
+	snapshot, err = record.NewBoltDB(testBackup, nil)
+	require.NoError(t, err)
+	require.NoError(t, snapshot.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("USER_INFO")).Put([]byte{0, 0, 0, 0, 0, 0, 0, 1}, []byte("{"))
+	}))
+	require.NoError(t, snapshot.Close())
+	_, err = Validate(ctx, testBackup)
+	assert.Error(t, err)
+	_, err = Restore(ctx, testBackup, testDb)
+	assert.Error(t, err)
+}
+
+func TestValidate_Tenants(t *testing.T) {
+	db, teardown := prepDB(t)
+	defer teardown()
+	ctx := context.Background()
+	acme, err := record.NewTenantRepository(db, "acme")
+	require.NoError(t, err)
+	require.NoError(t, acme.AddRecord(ctx, record.NewRecord(1, "3.3.3.3")))
+
+	meta, err := Create(ctx, db, testBackup, "rev1")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), meta.Users)
+	assert.Equal(t, uint64(4), meta.UserIPs)
+	assert.Equal(t, map[record.Tenant]Counts{"acme": {Users: 1, UserIPs: 1}}, meta.Tenants)
+	_, err = Validate(ctx, testBackup)
+	require.NoError(t, err)
+
+	// a snapshot which lost a tenant's users isn't valid
+	snapshot, err := record.NewBoltDB(testBackup, nil)
+	require.NoError(t, err)
+	require.NoError(t, snapshot.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("USER_INFO:acme"))
+	}))
+	require.NoError(t, snapshot.Close())
+	_, err = Validate(ctx, testBackup)
+	assert.EqualError(t, err, "snapshot /tmp/test_backup_snapshot.db has 2 users and 3 user IPs, metadata says 3 and 4")
+}
+
+func TestAPI(t *testing.T) {
+	db, teardown := prepDB(t)
+	defer teardown()
+
+	r := gin.Default()
+	RegisterHandlers(r, NewService(db, "", "rev1"))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/admin/backup", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+
+	r = gin.Default()
+	RegisterHandlers(r, NewService(db, testDir, "rev1"))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/admin/backup", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	s := Snapshot{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &s))
+	assert.Equal(t, testDir, filepath.Dir(s.Path))
+	assert.Equal(t, uint64(2), s.Users)
+	files, err := ioutil.ReadDir(testDir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	meta, err := Validate(context.Background(), s.Path)
+	require.NoError(t, err)
+	assert.Equal(t, "rev1", meta.Revision)
+}
+
+func prepDB(t *testing.T) (*bolt.DB, func()) {
+	_ = os.Remove(testDb)
+	_ = os.Remove(testBackup)
+	_ = os.RemoveAll(testDir)
+
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	repo, err := record.NewBoltRepository(db)
+	require.NoError(t, err)
+	require.NoError(t, repo.BulkAddRecords(context.Background(), []*record.Record{
+		record.NewRecord(1, "1.1.1.1"), record.NewRecord(1, "2.2.2.2"), record.NewRecord(2, "1.1.1.1"),
+	}))
+
+	teardown := func() {
+		_ = db.Close()
+		_ = os.Remove(testDb)
+		_ = os.Remove(testBackup)
+		_ = os.RemoveAll(testDir)
+	}
+	return db, teardown
+}
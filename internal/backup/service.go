@@ -0,0 +1,56 @@
+package backup
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// ErrNoDir is returned when backups of the live store are disabled
+var ErrNoDir = errors.New("backup dir is not set")
+
+// Snapshot is a written backup file
+type Snapshot struct {
+	Path string `json:"path"`
+	Metadata
+}
+
+// Service makes snapshots of the live store to the backup dir
+type Service struct {
+	db       *bolt.DB
+	dir      string
+	revision string
+
+	mu sync.Mutex
+}
+
+// NewService returns Service backing db up to dir. Empty dir disables backups
+func NewService(db *bolt.DB, dir, revision string) *Service {
+	return &Service{db: db, dir: dir, revision: revision}
+}
+
+// Backup writes a new snapshot named by its creation time. Only one backup runs at a time
+func (s *Service) Backup(ctx context.Context) (*Snapshot, error) {
+	if s.dir == "" {
+		return nil, ErrNoDir
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(s.dir, "backup-"+time.Now().UTC().Format("20060102-150405.000")+".db")
+	meta, err := Create(ctx, s.db, path, s.revision)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] backup %s of %d users is written", path, meta.Users)
+	return &Snapshot{Path: path, Metadata: *meta}, nil
+}
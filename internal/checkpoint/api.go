@@ -0,0 +1,57 @@
+package checkpoint
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterHandlers register import progress handler in router. current returns ID of the reported run,
+// it's empty until the run is started
+func RegisterHandlers(r *gin.Engine, repo Repository, current func() string) {
+	res := resource{repo, current}
+
+	r.GET("/import/status", res.Status)
+}
+
+type resource struct {
+	repo    Repository
+	current func() string
+}
+
+type runStatus struct {
+	ID        string    `json:"id"`
+	Source    string    `json:"source"`
+	Status    Status    `json:"status"`
+	Position  uint64    `json:"position"`
+	Records   uint64    `json:"records"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (r resource) Status(c *gin.Context) {
+	id := r.current()
+	if id == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "import is not started"})
+		return
+	}
+	run, err := r.repo.Get(c, id)
+	if err != nil {
+		log.Printf("[ERROR] failed to get run %s: %+v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	c.JSON(http.StatusOK, runStatus{
+		ID:        run.ID,
+		Source:    run.Source,
+		Status:    run.Status,
+		Position:  run.Position,
+		Records:   run.Records,
+		Error:     run.Error,
+		StartedAt: run.StartedAt,
+		UpdatedAt: run.UpdatedAt,
+	})
+}
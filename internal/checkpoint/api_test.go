@@ -0,0 +1,37 @@
+package checkpoint
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPI_Status(t *testing.T) {
+	repo, teardown := prepBoltRepo(t)
+	defer teardown()
+
+	var current string
+	r := gin.Default()
+	RegisterHandlers(r, repo, func() string { return current })
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/import/status", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+
+	run := NewRun("follow", nil)
+	run.Position, run.Records = 10, 8
+	require.NoError(t, repo.Save(context.Background(), run))
+	current = run.ID
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":"`+run.ID+`"`)
+	assert.Contains(t, w.Body.String(), `"status":"running","position":10,"records":8`)
+}
@@ -0,0 +1,60 @@
+package checkpoint
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Status of an import run
+type Status string
+
+// Import run statuses
+const (
+	StatusRunning     Status = "running"
+	StatusInterrupted Status = "interrupted"
+	StatusFailed      Status = "failed"
+	StatusDone        Status = "done"
+)
+
+// Run describes a single import run and how far it got.
+// Position is the source position right after the last committed batch,
+// Records is the number of records committed so far
+type Run struct {
+	ID        string
+	Source    string
+	Options   json.RawMessage
+	Status    Status
+	Position  uint64
+	Records   uint64
+	Error     string
+	StartedAt time.Time
+	UpdatedAt time.Time
+}
+
+// NewRun creates a running Run with a fresh ID. Options keep everything needed to
+// reconstruct the source on resume
+func NewRun(source string, options json.RawMessage) *Run {
+	now := time.Now().UTC()
+	return &Run{
+		ID:        newID(now),
+		Source:    source,
+		Options:   options,
+		Status:    StatusRunning,
+		StartedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// Resumable returns true if the run may be continued
+func (r *Run) Resumable() bool {
+	return r.Status != StatusDone
+}
+
+// IDs are sortable by start time, random suffix prevents collisions of runs started within a second
+func newID(t time.Time) string {
+	b := make([]byte, 3)
+	_, _ = rand.Read(b)
+	return t.Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
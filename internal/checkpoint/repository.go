@@ -0,0 +1,98 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/internal/fsck"
+	"github.com/pkg/errors"
+)
+
+const bucketName = "IMPORT_RUNS"
+
+// ErrNotFound is returned when there is no run with requested ID
+var ErrNotFound = errors.New("import run not found")
+
+// Repository keeps import runs and their checkpoints
+type Repository interface {
+	Save(ctx context.Context, run *Run) error
+	SaveTx(tx *bolt.Tx, run *Run) error
+	Get(ctx context.Context, id string) (*Run, error)
+	List(ctx context.Context) ([]*Run, error)
+}
+
+type boltRepository struct {
+	DB  *bolt.DB
+	BKT string
+}
+
+// Save creates or updates the run and bumps its UpdatedAt
+func (b *boltRepository) Save(ctx context.Context, run *Run) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		return b.SaveTx(tx, run)
+	})
+}
+
+// SaveTx is Save within the caller's transaction, so the checkpoint is committed along with the batch
+func (b *boltRepository) SaveTx(tx *bolt.Tx, run *Run) error {
+	run.UpdatedAt = time.Now().UTC()
+	buf, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(b.BKT)).Put([]byte(run.ID), buf)
+}
+
+// Get returns the run by ID or ErrNotFound
+func (b *boltRepository) Get(ctx context.Context, id string) (*Run, error) {
+	run := &Run{}
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(b.BKT)).Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, run)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// List returns all runs ordered by start time
+func (b *boltRepository) List(ctx context.Context) ([]*Run, error) {
+	var runs []*Run
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(b.BKT)).ForEach(func(k, v []byte) error {
+			run := &Run{}
+			if err := json.Unmarshal(v, run); err != nil {
+				return errors.Wrapf(err, "failed to decode run %s", k)
+			}
+			runs = append(runs, run)
+			return nil
+		})
+	})
+	return runs, err
+}
+
+// Check returns the check of runs bucket, undecodable entries are removed on repair
+func Check() fsck.Check {
+	return fsck.JSONCheck(bucketName, func() interface{} { return &Run{} })
+}
+
+// NewBoltRepository makes boltdb Repository implementation, creates a bucket if it doesn't exist
+func NewBoltRepository(db *bolt.DB) (Repository, error) {
+	r := boltRepository{db, bucketName}
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, e := tx.CreateBucketIfNotExists([]byte(r.BKT)); e != nil {
+			return errors.Wrapf(e, "failed to create bucket %s", r.BKT)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
@@ -0,0 +1,82 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDb = "/tmp/test_checkpoint.db"
+
+func TestBoltRepo_SaveGet(t *testing.T) {
+	r, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+
+	run := NewRun("generator", json.RawMessage(`{"Seed":1}`))
+	require.NoError(t, r.Save(ctx, run))
+
+	run.Position = 100
+	run.Records = 90
+	require.NoError(t, r.Save(ctx, run))
+
+	got, err := r.Get(ctx, run.ID)
+	require.NoError(t, err)
+	assert.Equal(t, run.ID, got.ID)
+	assert.Equal(t, uint64(100), got.Position)
+	assert.Equal(t, uint64(90), got.Records)
+	assert.Equal(t, StatusRunning, got.Status)
+	assert.JSONEq(t, `{"Seed":1}`, string(got.Options))
+
+	_, err = r.Get(ctx, "unknown")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestBoltRepo_List(t *testing.T) {
+	r, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+
+	run1 := NewRun("dbg", nil)
+	run1.ID = "20200101-000000-000000"
+	run2 := NewRun("file", nil)
+	run2.ID = "20200102-000000-000000"
+	require.NoError(t, r.Save(ctx, run2))
+	require.NoError(t, r.Save(ctx, run1))
+
+	runs, err := r.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, run1.ID, runs[0].ID)
+	assert.Equal(t, run2.ID, runs[1].ID)
+}
+
+func TestRun_Resumable(t *testing.T) {
+	run := NewRun("dbg", nil)
+	assert.True(t, run.Resumable())
+	run.Status = StatusInterrupted
+	assert.True(t, run.Resumable())
+	run.Status = StatusDone
+	assert.False(t, run.Resumable())
+}
+
+func prepBoltRepo(t *testing.T) (repo Repository, teardown func()) {
+	_ = os.Remove(testDb)
+
+	db, err := bolt.Open(testDb, 0600, nil)
+	require.NoError(t, err)
+
+	repo, err = NewBoltRepository(db)
+	require.NoError(t, err)
+
+	teardown = func() {
+		assert.NoError(t, db.Close())
+		_ = os.Remove(testDb)
+	}
+	return repo, teardown
+}
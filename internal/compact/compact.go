@@ -0,0 +1,175 @@
+package compact
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/internal/fileutil"
+	"github.com/pkg/errors"
+)
+
+// txMaxSize is how many bytes of keys and values are copied in a single write transaction
+var txMaxSize int64 = 64 << 20
+
+const (
+	// fill percent limits of bolt, values out of them are silently clamped by bolt
+	minFillPercent = 0.1
+	maxFillPercent = 1.0
+)
+
+// Result describes a compacted file
+type Result struct {
+	Path       string        `json:"path"`
+	SizeBefore int64         `json:"size_before"`
+	SizeAfter  int64         `json:"size_after"`
+	Elapsed    time.Duration `json:"elapsed"`
+}
+
+// File compacts bolt file at path and atomically replaces it. The file is locked while it's compacted,
+// so a file opened by a running process is never compacted
+func File(ctx context.Context, path string, fillPercent float64) (*Result, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", path)
+	}
+	// the lock is released after the file is replaced
+	defer db.Close()
+	return Replace(ctx, db, path, fillPercent)
+}
+
+// Replace copies db to a new file next to path and renames it to path.
+// Records written to db after the copy started are lost, so db must not be written meanwhile
+func Replace(ctx context.Context, db *bolt.DB, path string, fillPercent float64) (*Result, error) {
+	start := time.Now()
+	res := &Result{Path: path}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	res.SizeBefore = fi.Size()
+
+	tmp := path + ".compact"
+	err = Copy(ctx, db, tmp, fillPercent)
+	if err == nil {
+		fi, err = os.Stat(tmp)
+	}
+	if err == nil {
+		res.SizeAfter = fi.Size()
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return nil, errors.Wrapf(err, "failed to compact %s", path)
+	}
+	if err := fileutil.SyncDir(path); err != nil {
+		return nil, err
+	}
+	res.Elapsed = time.Since(start)
+	return res, nil
+}
+
+// Copy writes all buckets of src to a new bolt file at path within a single read transaction of src.
+// Pages of the new file are filled up to fillPercent, see bolt.Bucket.FillPercent
+func Copy(ctx context.Context, src *bolt.DB, path string, fillPercent float64) error {
+	if fillPercent < minFillPercent || fillPercent > maxFillPercent {
+		return errors.Errorf("fill percent must be from %.1f to %.1f, got %g", minFillPercent, maxFillPercent, fillPercent)
+	}
+	_ = os.Remove(path)
+	dst, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	c := &compactor{ctx: ctx, dst: dst, fillPercent: fillPercent}
+	if c.tx, err = dst.Begin(true); err != nil {
+		dst.Close()
+		return err
+	}
+	err = src.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return c.copyBucket(b, [][]byte{name})
+		})
+	})
+	if err == nil {
+		err = c.tx.Commit()
+	} else {
+		_ = c.tx.Rollback()
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if e := dst.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+type compactor struct {
+	ctx         context.Context
+	dst         *bolt.DB
+	tx          *bolt.Tx
+	size        int64
+	fillPercent float64
+}
+
+// copyBucket copies src with nested buckets to the bucket at path of the destination
+func (c *compactor) copyBucket(src *bolt.Bucket, path [][]byte) error {
+	dst, err := c.bucket(path)
+	if err != nil {
+		return err
+	}
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			if err := c.copyBucket(src.Bucket(k), append(path[:len(path):len(path)], k)); err != nil {
+				return err
+			}
+			// nested copy may have committed the transaction
+			dst, err = c.bucket(path)
+			return err
+		}
+		if c.size += int64(len(k) + len(v)); c.size > txMaxSize {
+			if err := c.commit(); err != nil {
+				return err
+			}
+			if dst, err = c.bucket(path); err != nil {
+				return err
+			}
+		}
+		return dst.Put(k, v)
+	})
+}
+
+// commit commits the destination transaction and begins the next one
+func (c *compactor) commit() error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	if err := c.tx.Commit(); err != nil {
+		return err
+	}
+	var err error
+	c.tx, err = c.dst.Begin(true)
+	c.size = 0
+	return err
+}
+
+// bucket returns the destination bucket at path creating missing ones
+func (c *compactor) bucket(path [][]byte) (*bolt.Bucket, error) {
+	b, err := c.tx.CreateBucketIfNotExists(path[0])
+	for _, name := range path[1:] {
+		if err != nil {
+			break
+		}
+		b.FillPercent = c.fillPercent
+		b, err = b.CreateBucketIfNotExists(name)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create bucket %s", path[len(path)-1])
+	}
+	b.FillPercent = c.fillPercent
+	return b, nil
+}
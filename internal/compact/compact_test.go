@@ -0,0 +1,83 @@
+package compact
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDb = "/tmp/test_compact.db"
+
+func TestFile(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+	db, err := bolt.Open(testDb, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("A"))
+		require.NoError(t, err)
+		require.NoError(t, b.SetSequence(42))
+		nested, err := b.CreateBucket([]byte("nested"))
+		require.NoError(t, err)
+		require.NoError(t, nested.Put([]byte("k"), []byte("v")))
+		garbage, err := tx.CreateBucket([]byte("GARBAGE"))
+		require.NoError(t, err)
+		for i := 0; i < 1e4; i++ {
+			require.NoError(t, b.Put(key(i), make([]byte, 100)))
+			require.NoError(t, garbage.Put(key(i), make([]byte, 1000)))
+		}
+		return nil
+	}))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("GARBAGE"))
+	}))
+	require.NoError(t, db.Close())
+
+	prev := txMaxSize
+	txMaxSize = 1 << 16
+	defer func() { txMaxSize = prev }()
+
+	_, err = File(context.Background(), testDb, 1.5)
+	assert.Error(t, err)
+	res, err := File(context.Background(), testDb, 1)
+	require.NoError(t, err)
+	assert.True(t, res.SizeAfter < res.SizeBefore/4, "%d is compacted to %d", res.SizeBefore, res.SizeAfter)
+	fi, err := os.Stat(testDb)
+	require.NoError(t, err)
+	assert.Equal(t, res.SizeAfter, fi.Size())
+
+	db, err = bolt.Open(testDb, 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		assert.Nil(t, tx.Bucket([]byte("GARBAGE")))
+		b := tx.Bucket([]byte("A"))
+		assert.Equal(t, uint64(42), b.Sequence())
+		// the nested bucket and its key are counted too
+		assert.Equal(t, 10002, b.Stats().KeyN)
+		assert.Equal(t, []byte("v"), b.Bucket([]byte("nested")).Get([]byte("k")))
+		return nil
+	}))
+}
+
+func TestFile_Locked(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+	db, err := bolt.Open(testDb, 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = File(context.Background(), testDb, 0.5)
+	assert.Error(t, err)
+}
+
+func key(i int) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(i))
+	return k
+}
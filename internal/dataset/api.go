@@ -0,0 +1,38 @@
+package dataset
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterHandlers register dataset management handlers in router
+func RegisterHandlers(r *gin.Engine, manager *Manager) {
+	res := resource{manager}
+
+	r.GET("/admin/status", res.Status)
+	r.POST("/admin/reload", res.Reload)
+}
+
+type resource struct {
+	manager *Manager
+}
+
+func (r resource) Status(c *gin.Context) {
+	c.JSON(http.StatusOK, r.manager.Status())
+}
+
+func (r resource) Reload(c *gin.Context) {
+	status, err := r.manager.Reload(c)
+	if err == ErrNoPath {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] failed to reload dataset: %+v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reload dataset"})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
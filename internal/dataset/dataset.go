@@ -0,0 +1,245 @@
+package dataset
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/internal/compact"
+	"github.com/mullakhmetov/duplicates-checker/internal/index"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+// ErrNoPath is returned on reload when there is no dataset file to load
+var ErrNoPath = errors.New("dataset path is not set")
+
+// ErrImmutable is returned on compaction of an index dataset
+var ErrImmutable = errors.New("index dataset can't be compacted, rebuild it instead")
+
+// dataset file formats
+const (
+	FormatBolt  = "bolt"
+	FormatIndex = "index"
+)
+
+// Status describes the served dataset
+type Status struct {
+	Path     string    `json:"path"`
+	LoadedAt time.Time `json:"loaded_at"`
+	ReadOnly bool      `json:"read_only"`
+	Format   string    `json:"format,omitempty"`
+}
+
+// Manager loads datasets built offline and swaps them behind the served repository. A dataset is a bolt
+// file or an index built by `index build`. Loaded datasets are opened read-only, so the file may be
+// replaced while it's served. Only the default tenant is swapped, tenants are kept in the served store
+type Manager struct {
+	repo    *record.SwappableRepository
+	path    string
+	timeout time.Duration
+
+	mu     sync.Mutex
+	status Status
+	// db is the loaded bolt dataset, it's nil if an index is loaded
+	db     *bolt.DB
+	closer io.Closer
+	loaded os.FileInfo
+}
+
+// NewManager returns Manager of repo. status describes the dataset repo serves initially,
+// path is the file loaded on every reload. Watch reloads it only if it's changed after that
+func NewManager(repo *record.SwappableRepository, status Status, path string) *Manager {
+	m := &Manager{repo: repo, status: status, path: path, timeout: time.Second}
+	if path != "" {
+		m.loaded, _ = os.Stat(path)
+	}
+	return m
+}
+
+// Status returns the served dataset description
+func (m *Manager) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// Reload opens the dataset file, warms it and swaps the served repository.
+// The previous dataset is closed after requests to it and its holds are finished, unless it's the initial one
+func (m *Manager) Reload(ctx context.Context) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reload(ctx)
+}
+
+func (m *Manager) reload(ctx context.Context) (Status, error) {
+	if m.path == "" {
+		return m.status, ErrNoPath
+	}
+
+	fi, err := os.Stat(m.path)
+	if err != nil {
+		return m.status, err
+	}
+	isIndex, err := index.IsIndex(m.path)
+	if err != nil {
+		return m.status, err
+	}
+	start := time.Now()
+	var repo record.Repository
+	var db *bolt.DB
+	var closer io.Closer
+	format := FormatBolt
+	if isIndex {
+		idx, err := index.Open(m.path)
+		if err != nil {
+			return m.status, err
+		}
+		repo, closer, format = idx, idx, FormatIndex
+	} else {
+		if db, err = openBolt(m.path, m.timeout); err != nil {
+			return m.status, err
+		}
+		if repo, err = record.OpenBoltRepository(db); err != nil {
+			db.Close()
+			return m.status, err
+		}
+		closer = db
+	}
+	log.Printf("[INFO] dataset %s is warmed in %s", m.path, time.Since(start))
+
+	m.repo.Swap(repo)
+	if m.closer != nil {
+		if err := m.closer.Close(); err != nil {
+			log.Printf("[WARN] failed to close previous dataset: %+v", err)
+		}
+	}
+	m.db, m.closer, m.loaded = db, closer, fi
+	m.status = Status{Path: m.path, LoadedAt: time.Now().UTC(), ReadOnly: true, Format: format}
+	log.Printf("[INFO] %s dataset %s is loaded", format, m.path)
+	return m.status, nil
+}
+
+// openBolt opens the bolt file read-only and warms it
+func openBolt(path string, timeout time.Duration) (*bolt.DB, error) {
+	db, err := record.NewBoltDB(path, &bolt.Options{ReadOnly: true, Timeout: timeout})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", path)
+	}
+	if err := warm(db); err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "failed to warm %s", path)
+	}
+	return db, nil
+}
+
+// Watch reloads the dataset every time its file is changed, the file is checked every interval.
+// It blocks until ctx is cancelled
+func (m *Manager) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !m.changed() {
+				continue
+			}
+			if _, err := m.Reload(ctx); err != nil {
+				log.Printf("[ERROR] failed to reload dataset: %+v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Compact compacts the dataset file and reloads it. The file is loaded first unless it's served already,
+// so the served dataset is never written and the file replaced meanwhile isn't lost. Index datasets
+// are immutable, ErrImmutable is returned for them
+func (m *Manager) Compact(ctx context.Context, fillPercent float64) (*compact.Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.path == "" {
+		return nil, ErrNoPath
+	}
+	if fi, err := os.Stat(m.path); err != nil || m.closer == nil || m.stale(fi) {
+		if _, err := m.reload(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if m.db == nil {
+		return nil, ErrImmutable
+	}
+
+	res, err := compact.Replace(ctx, m.db, m.path, fillPercent)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] dataset %s is compacted from %d to %d bytes in %s", m.path, res.SizeBefore, res.SizeAfter, res.Elapsed)
+	if _, err := m.reload(ctx); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// CompactEvery compacts the dataset every interval. It blocks until ctx is cancelled
+func (m *Manager) CompactEvery(ctx context.Context, interval time.Duration, fillPercent float64) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_, err := m.Compact(ctx, fillPercent)
+			switch {
+			case err == ErrImmutable:
+				log.Printf("[DEBUG] index dataset is served, compaction skipped")
+			case err != nil:
+				log.Printf("[ERROR] failed to compact dataset: %+v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// changed returns true if the dataset file differs from the loaded one
+func (m *Manager) changed() bool {
+	fi, err := os.Stat(m.path)
+	if err != nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stale(fi)
+}
+
+// stale returns true if fi differs from the loaded file
+func (m *Manager) stale(fi os.FileInfo) bool {
+	return m.loaded == nil || !os.SameFile(fi, m.loaded) ||
+		!fi.ModTime().Equal(m.loaded.ModTime()) || fi.Size() != m.loaded.Size()
+}
+
+// Close closes the loaded dataset. The initial one is left to its owner
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closer == nil {
+		return nil
+	}
+	err := m.closer.Close()
+	m.db, m.closer = nil, nil
+	return err
+}
+
+// warm reads every bucket through, so the first requests don't hit the disk
+func warm(db *bolt.DB) error {
+	return db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return b.ForEach(func(k, v []byte) error { return nil })
+		})
+	})
+}
@@ -0,0 +1,282 @@
+package dataset
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/mullakhmetov/duplicates-checker/internal/index"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testDb      = "/tmp/test_dataset.db"
+	testDataset = "/tmp/test_dataset_new.db"
+)
+
+func TestManager_Reload(t *testing.T) {
+	repo, teardown := prepRepo(t)
+	defer teardown()
+	ctx := context.Background()
+
+	m := NewManager(repo, Status{Path: testDb}, "")
+	_, err := m.Reload(ctx)
+	assert.Equal(t, ErrNoPath, err)
+
+	buildDataset(t, record.NewRecord(1, "1.1.1.1"))
+	m = NewManager(repo, Status{Path: testDb}, testDataset)
+	defer m.Close()
+	status, err := m.Reload(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, testDataset, status.Path)
+	assert.True(t, status.ReadOnly)
+	assert.Equal(t, status, m.Status())
+
+	info, err := repo.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Len(t, info.IPs, 1)
+
+	err = repo.AddRecord(ctx, record.NewRecord(2, "2.2.2.2"))
+	assert.Equal(t, bolt.ErrDatabaseReadOnly, err)
+
+	// broken dataset doesn't replace the served one
+	_ = os.Remove(testDataset)
+	db, err := bolt.Open(testDataset, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	_, err = m.Reload(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, status, m.Status())
+	info, err = repo.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Len(t, info.IPs, 1)
+}
+
+func TestManager_Watch(t *testing.T) {
+	repo, teardown := prepRepo(t)
+	defer teardown()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	buildDataset(t, record.NewRecord(1, "1.1.1.1"))
+	m := NewManager(repo, Status{Path: testDb}, testDataset)
+	defer m.Close()
+	go m.Watch(ctx, 10*time.Millisecond)
+
+	// the file existing on start isn't loaded until it's changed
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, testDb, m.Status().Path)
+
+	buildDataset(t, record.NewRecord(1, "1.1.1.1"), record.NewRecord(1, "2.2.2.2"))
+	require.Eventually(t, func() bool { return m.Status().Path == testDataset }, time.Second, 10*time.Millisecond)
+	info, err := repo.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Len(t, info.IPs, 2)
+}
+
+func TestManager_Compact(t *testing.T) {
+	repo, teardown := prepRepo(t)
+	defer teardown()
+	ctx := context.Background()
+
+	buildDataset(t, record.NewRecord(1, "1.1.1.1"), record.NewRecord(1, "2.2.2.2"))
+	m := NewManager(repo, Status{Path: testDb}, testDataset)
+	defer m.Close()
+
+	// the dataset is loaded before it's compacted
+	res, err := m.Compact(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, testDataset, res.Path)
+	assert.Equal(t, testDataset, m.Status().Path)
+	assert.False(t, m.changed())
+
+	info, err := repo.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Len(t, info.IPs, 2)
+
+	// the file replaced after the load is compacted instead of the served one
+	buildDataset(t, record.NewRecord(2, "3.3.3.3"))
+	_, err = m.Compact(ctx, 1)
+	require.NoError(t, err)
+	info, err = repo.GetUserInfo(ctx, 2)
+	require.NoError(t, err)
+	assert.Len(t, info.IPs, 1)
+}
+
+func TestManager_ReloadIndex(t *testing.T) {
+	repo, teardown := prepRepo(t)
+	defer teardown()
+	ctx := context.Background()
+
+	buildIndex(t, record.NewRecord(1, "1.1.1.1"), record.NewRecord(2, "1.1.1.1"), record.NewRecord(2, "2.2.2.2"))
+	m := NewManager(repo, Status{Path: testDb}, testDataset)
+	defer m.Close()
+	status, err := m.Reload(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, FormatIndex, status.Format)
+
+	infos, err := repo.GetUserInfos(ctx, 1, 2)
+	require.NoError(t, err)
+	assert.Len(t, infos[0].IPs, 1)
+	assert.Len(t, infos[1].IPs, 2)
+	assert.Equal(t, bolt.ErrDatabaseReadOnly, repo.AddRecord(ctx, record.NewRecord(3, "3.3.3.3")))
+	_, err = m.Compact(ctx, 1)
+	assert.Equal(t, ErrImmutable, err)
+
+	// an index is swapped for a bolt dataset and back
+	buildDataset(t, record.NewRecord(3, "3.3.3.3"))
+	status, err = m.Reload(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, FormatBolt, status.Format)
+	info, err := repo.GetUserInfo(ctx, 3)
+	require.NoError(t, err)
+	assert.Len(t, info.IPs, 1)
+
+	buildIndex(t, record.NewRecord(4, "4.4.4.4"))
+	_, err = m.Reload(ctx)
+	require.NoError(t, err)
+	infos, err = repo.GetUserInfos(ctx, 3, 4)
+	require.NoError(t, err)
+	assert.Empty(t, infos[0].IPs)
+	assert.Len(t, infos[1].IPs, 1)
+}
+
+func TestManager_ReloadIndexConcurrently(t *testing.T) {
+	repo, teardown := prepRepo(t)
+	defer teardown()
+	ctx := context.Background()
+	records := []*record.Record{
+		record.NewRecord(1, "1.1.1.1"), record.NewRecord(1, "2.2.2.2"),
+		record.NewRecord(2, "1.1.1.1"), record.NewRecord(2, "2.2.2.2"),
+	}
+	buildIndex(t, records...)
+	m := NewManager(repo, Status{Path: testDb}, testDataset)
+	defer m.Close()
+	_, err := m.Reload(ctx)
+	require.NoError(t, err)
+
+	// users are read in place, so requests hold the index they're served from and the cache is reset on swaps
+	cache := record.NewCachedRepository(repo, 1<<20, time.Hour)
+	repo.OnSwap(cache.Reset)
+	service := record.NewService(cache)
+	done := make(chan struct{})
+	checked := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			var err error
+			for err == nil {
+				select {
+				case <-done:
+					checked <- nil
+					return
+				default:
+				}
+				release := repo.Hold()
+				var dupes bool
+				if dupes, err = service.IsDuple(ctx, 1, 2); err == nil && !dupes {
+					err = errors.New("users aren't duplicates")
+				}
+				release()
+			}
+			checked <- err
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		buildIndex(t, records...)
+		_, err := m.Reload(ctx)
+		require.NoError(t, err)
+	}
+	close(done)
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, <-checked)
+	}
+
+	// users cached from the previous index aren't served after a swap
+	buildDataset(t)
+	_, err = m.Reload(ctx)
+	require.NoError(t, err)
+	release := repo.Hold()
+	defer release()
+	e, err := service.Explain(ctx, 1, 2)
+	require.NoError(t, err)
+	assert.False(t, e.Dupes)
+	assert.Equal(t, "[]", fmt.Sprint(e.CommonIPs))
+}
+
+func TestAPI(t *testing.T) {
+	repo, teardown := prepRepo(t)
+	defer teardown()
+
+	buildDataset(t, record.NewRecord(1, "1.1.1.1"))
+	m := NewManager(repo, Status{Path: testDb}, testDataset)
+	defer m.Close()
+	r := gin.Default()
+	RegisterHandlers(r, m)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/status", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"path":"/tmp/test_dataset.db"`)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/admin/reload", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"path":"/tmp/test_dataset_new.db"`)
+	assert.Contains(t, w.Body.String(), `"read_only":true`)
+}
+
+// buildDataset replaces the dataset file with a new one containing records
+func buildDataset(t *testing.T, records ...*record.Record) {
+	tmp := testDataset + ".tmp"
+	_ = os.Remove(tmp)
+	db, err := record.NewBoltDB(tmp, nil)
+	require.NoError(t, err)
+	repo, err := record.NewBoltRepository(db)
+	require.NoError(t, err)
+	require.NoError(t, repo.BulkAddRecords(context.Background(), records))
+	require.NoError(t, db.Close())
+	require.NoError(t, os.Rename(tmp, testDataset))
+}
+
+// buildIndex replaces the dataset file with an index of records
+func buildIndex(t *testing.T, records ...*record.Record) {
+	tmp := testDataset + ".db"
+	_ = os.Remove(tmp)
+	defer os.Remove(tmp)
+	db, err := record.NewBoltDB(tmp, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	repo, err := record.NewBoltRepository(db)
+	require.NoError(t, err)
+	require.NoError(t, repo.BulkAddRecords(context.Background(), records))
+	_, err = index.Build(context.Background(), repo, testDataset, index.BuildOptions{})
+	require.NoError(t, err)
+}
+
+func prepRepo(t *testing.T) (*record.SwappableRepository, func()) {
+	_ = os.Remove(testDb)
+	_ = os.Remove(testDataset)
+
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	repo, err := record.NewBoltRepository(db)
+	require.NoError(t, err)
+
+	teardown := func() {
+		assert.NoError(t, db.Close())
+		_ = os.Remove(testDb)
+		_ = os.Remove(testDataset)
+	}
+	return record.NewSwappableRepository(repo), teardown
+}
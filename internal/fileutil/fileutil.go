@@ -0,0 +1,20 @@
+// Package fileutil keeps helpers of writing files safely, shared by packages replacing files atomically
+package fileutil
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// SyncDir flushes the directory of path, so a rename into it survives a crash
+func SyncDir(path string) error {
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if e := d.Close(); err == nil {
+		err = e
+	}
+	return err
+}
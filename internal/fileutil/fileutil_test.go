@@ -0,0 +1,12 @@
+package fileutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncDir(t *testing.T) {
+	assert.NoError(t, SyncDir("/tmp/test_fileutil.db"))
+	assert.Error(t, SyncDir("/nonexistent/test_fileutil.db"))
+}
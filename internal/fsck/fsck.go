@@ -0,0 +1,200 @@
+package fsck
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// Status is the overall result of a check
+type Status int
+
+// statuses in the order of severity
+const (
+	Clean Status = iota
+	Repaired
+	Broken
+)
+
+func (s Status) String() string {
+	switch s {
+	case Clean:
+		return "clean"
+	case Repaired:
+		return "repaired"
+	default:
+		return "broken"
+	}
+}
+
+// MarshalJSON encodes status as its name
+func (s Status) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(s.String())), nil
+}
+
+// Problem is a broken entry found by a check
+type Problem struct {
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key,omitempty"`
+	Error    string `json:"error"`
+	Repaired bool   `json:"repaired"`
+}
+
+// Report lists problems found by checks
+type Report struct {
+	Status   Status         `json:"status"`
+	Checked  map[string]int `json:"checked"`
+	Unknown  []string       `json:"unknown_buckets,omitempty"`
+	Problems []Problem      `json:"problems"`
+}
+
+// Add adds a problem of the bucket entry with printable key, empty key stands for the bucket itself
+func (r *Report) Add(bucket, key, err string, repaired bool) {
+	r.Problems = append(r.Problems, Problem{Bucket: bucket, Key: key, Error: err, Repaired: repaired})
+	s := Repaired
+	if !repaired {
+		s = Broken
+	}
+	if s > r.Status {
+		r.Status = s
+	}
+}
+
+// Check verifies buckets it's responsible for. Every found problem is added to the report, with repair
+// tx is writable and broken entries are removed or rebuilt. A missing bucket isn't a problem, unless
+// the check reports it. Prefixes are prefixes of names of the buckets the check is responsible for too
+type Check struct {
+	Buckets  []string
+	Prefixes []string
+	Run      func(tx *bolt.Tx, repair bool, r *Report) error
+}
+
+// Run checks bolt pages and runs checks within a single transaction, which is writable and committed
+// if repair is set. Broken pages are never repaired, the file should be restored or compacted then.
+// Buckets no check is responsible for are reported as unknown
+func Run(db *bolt.DB, repair bool, checks ...Check) (*Report, error) {
+	r := &Report{Checked: make(map[string]int), Problems: []Problem{}}
+	fn := func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			r.Add("", "", err.Error(), false)
+		}
+		known := make(map[string]bool)
+		var prefixes []string
+		for _, c := range checks {
+			for _, b := range c.Buckets {
+				known[b] = true
+			}
+			prefixes = append(prefixes, c.Prefixes...)
+			if err := c.Run(tx, repair, r); err != nil {
+				return err
+			}
+		}
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if !known[string(name)] && !hasPrefix(string(name), prefixes) {
+				r.Unknown = append(r.Unknown, string(name))
+			}
+			return nil
+		})
+	}
+	var err error
+	if repair {
+		err = db.Update(fn)
+	} else {
+		err = db.View(fn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func hasPrefix(name string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// JSONCheck checks every value of the bucket is decoded to a value returned by newValue.
+// Broken entries are removed on repair
+func JSONCheck(bucket string, newValue func() interface{}) Check {
+	return Check{
+		Buckets: []string{bucket},
+		Run: func(tx *bolt.Tx, repair bool, r *Report) error {
+			bkt := tx.Bucket([]byte(bucket))
+			if bkt == nil {
+				return nil
+			}
+			var broken [][]byte
+			err := bkt.ForEach(func(k, v []byte) error {
+				r.Checked[bucket]++
+				if v == nil {
+					r.Add(bucket, string(k), "unexpected nested bucket", repair)
+					broken = append(broken, Copy(k))
+					return nil
+				}
+				if err := json.Unmarshal(v, newValue()); err != nil {
+					r.Add(bucket, string(k), err.Error(), repair)
+					broken = append(broken, Copy(k))
+				}
+				return nil
+			})
+			if err != nil || !repair {
+				return err
+			}
+			return Remove(bkt, broken)
+		},
+	}
+}
+
+// JSONKeyCheck checks the value of the key in the bucket shared with other checks is decoded
+// to a value returned by newValue. Broken value is removed on repair
+func JSONKeyCheck(bucket, key string, newValue func() interface{}) Check {
+	return Check{
+		Buckets: []string{bucket},
+		Run: func(tx *bolt.Tx, repair bool, r *Report) error {
+			bkt := tx.Bucket([]byte(bucket))
+			if bkt == nil {
+				return nil
+			}
+			v := bkt.Get([]byte(key))
+			if v == nil {
+				return nil
+			}
+			r.Checked[bucket]++
+			if err := json.Unmarshal(v, newValue()); err != nil {
+				r.Add(bucket, key, err.Error(), repair)
+				if repair {
+					return bkt.Delete([]byte(key))
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// Remove removes keys and nested buckets from bkt. Keys must be collected
+// before as bolt buckets can't be modified while they're iterated
+func Remove(bkt *bolt.Bucket, keys [][]byte) error {
+	for _, k := range keys {
+		if bkt.Bucket(k) != nil {
+			if err := bkt.DeleteBucket(k); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := bkt.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy copies a key, keys returned by bolt are valid only until the transaction is modified
+func Copy(k []byte) []byte {
+	return append([]byte(nil), k...)
+}
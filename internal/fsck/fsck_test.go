@@ -0,0 +1,57 @@
+package fsck
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDb = "/tmp/test_fsck.db"
+
+func TestRun(t *testing.T) {
+	_ = os.Remove(testDb)
+	defer os.Remove(testDb)
+	db, err := bolt.Open(testDb, 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("JSON"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("ok"), []byte(`{"a":1}`)))
+		require.NoError(t, b.Put([]byte("broken"), []byte(`{"a":`)))
+		_, err = b.CreateBucket([]byte("nested"))
+		require.NoError(t, err)
+		_, err = tx.CreateBucket([]byte("UNKNOWN"))
+		return err
+	}))
+	check := JSONCheck("JSON", func() interface{} { return &map[string]int{} })
+
+	r, err := Run(db, false, check, JSONCheck("MISSING", nil))
+	require.NoError(t, err)
+	assert.Equal(t, Broken, r.Status)
+	assert.Equal(t, map[string]int{"JSON": 3}, r.Checked)
+	assert.Equal(t, []string{"UNKNOWN"}, r.Unknown)
+	assert.Equal(t, []Problem{
+		{Bucket: "JSON", Key: "broken", Error: "unexpected end of JSON input"},
+		{Bucket: "JSON", Key: "nested", Error: "unexpected nested bucket"},
+	}, r.Problems)
+
+	r, err = Run(db, true, check)
+	require.NoError(t, err)
+	assert.Equal(t, Repaired, r.Status)
+	assert.Len(t, r.Problems, 2)
+	assert.True(t, r.Problems[0].Repaired)
+
+	r, err = Run(db, false, check)
+	require.NoError(t, err)
+	assert.Equal(t, Clean, r.Status)
+	assert.Equal(t, map[string]int{"JSON": 1}, r.Checked)
+
+	buf, err := json.Marshal(r)
+	require.NoError(t, err)
+	assert.Contains(t, string(buf), `"status":"clean"`)
+}
@@ -0,0 +1,280 @@
+package generator
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+)
+
+// IP distributions of generated users
+const (
+	DistributionRing    = "ring"
+	DistributionUniform = "uniform"
+	DistributionZipf    = "zipf"
+)
+
+const zipfS = 1.1
+
+// first address of generated IPs. 0.0.0.0/8 is reserved and would be rejected by validation
+const firstIP = uint32(1 << 24) // 1.0.0.0
+
+// first address of planted IPs. Planted IPs never intersect with the pool,
+// so planted users are duplicates of each other only
+const firstPlantedIP = uint32(100<<24 | 64<<16) // 100.64.0.0
+
+// MaxIPPool is the largest pool of IPs, a larger one would run into planted IPs
+const MaxIPPool = uint(firstPlantedIP - firstIP)
+
+type dbgRecord struct {
+	uID uint32
+	IP  string
+}
+
+// Profile describes generated dataset
+type Profile struct {
+	UsersCount          uint
+	RequestPerUserLimit uint
+	RequestPerUserMean  uint
+	IPsPerUserLimit     uint
+	IPPool              uint
+	IPDistribution      string
+
+	DupPairs uint
+	DupRings uint
+	RingSize uint
+	NATIPs   uint
+	NATUsers uint
+}
+
+// PlantedUser is a user with predefined IPs
+type PlantedUser struct {
+	UserID record.UserID
+	IPs    []string
+}
+
+// Truth is a planted pair of users and whether they are duplicates
+type Truth struct {
+	U1, U2 record.UserID
+	Kind   string
+	Dupes  bool
+}
+
+// Emit receives generated record and its position, generation stops if it returns false
+type Emit func(rec *record.Record, pos uint64) bool
+
+// Generator makes datasets, the same seed and profile always yield the same dataset
+type Generator struct {
+	random *rand.Rand
+}
+
+// New returns Generator seeded with seed
+func New(seed int64) *Generator {
+	return &Generator{rand.New(rand.NewSource(seed))}
+}
+
+// GenerateDbg emits the dataset from the specification example. First `skip` records are omitted
+func (g *Generator) GenerateDbg(skip uint64, emit Emit) {
+	logs := []dbgRecord{
+		dbgRecord{1, "127.0.0.1"},
+		dbgRecord{1, "127.0.0.2"},
+		dbgRecord{2, "127.0.0.1"},
+		dbgRecord{2, "127.0.0.2"},
+		dbgRecord{2, "127.0.0.3"},
+		dbgRecord{3, "127.0.0.3"},
+		dbgRecord{3, "127.0.0.1"},
+		dbgRecord{4, "127.0.0.1"},
+	}
+
+	for i, log := range logs {
+		pos := uint64(i + 1)
+		if pos <= skip {
+			continue
+		}
+		if !emit(record.NewRecord(record.UserID(log.uID), log.IP), pos) {
+			return
+		}
+	}
+}
+
+// Generate emits randomly generated records of users from 1 to p.UsersCount followed by planted users.
+// First `skip` records are generated but omitted, so the same seed always yields the same positions
+func (g *Generator) Generate(skip uint64, p *Profile, emit Emit) {
+	getIP := g.ipsGetter(p)
+	planted, _ := Plant(p)
+
+	var i, ipsCount, reqCount uint
+	var pos uint64
+	var ips []string
+
+	send := func(uID record.UserID, ip string) bool {
+		pos++
+		if pos <= skip {
+			return true
+		}
+		return emit(record.NewRecord(uID, ip), pos)
+	}
+
+	for uID := record.UserID(1); uID < record.UserID(p.UsersCount); uID++ {
+		ipsCount = g.getUserIPSCount(p.IPsPerUserLimit)
+		ips = make([]string, 0, ipsCount)
+		for i = uint(0); i <= ipsCount; i++ {
+			ips = append(ips, getIP())
+		}
+		reqCount = g.getUserRequestsCount(p.RequestPerUserMean, p.RequestPerUserLimit)
+
+		for i = uint(1); i <= reqCount; i++ {
+			if !send(uID, ips[i%ipsCount]) {
+				return
+			}
+		}
+	}
+
+	for _, u := range planted {
+		reqCount = g.getUserRequestsCount(p.RequestPerUserMean, p.RequestPerUserLimit)
+		if reqCount < uint(len(u.IPs)) {
+			reqCount = uint(len(u.IPs))
+		}
+		for i = uint(0); i < reqCount; i++ {
+			if !send(u.UserID, u.IPs[i%uint(len(u.IPs))]) {
+				return
+			}
+		}
+	}
+}
+
+// Plant returns users with predefined IPs, their IDs follow generated users' ones.
+// Duplicate pairs share two IPs. Every ring member shares two IPs with the next one only.
+// Users behind a shared NAT IP share that single IP and are not duplicates
+func Plant(p *Profile) ([]PlantedUser, []Truth) {
+	var users []PlantedUser
+	var truths []Truth
+
+	nextUser := record.UserID(p.UsersCount)
+	newUser := func() record.UserID {
+		nextUser++
+		return nextUser
+	}
+	nextIP := firstPlantedIP
+	newIP := func() string {
+		nextIP++
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, nextIP)
+		return ip.String()
+	}
+
+	for n := uint(0); n < p.DupPairs; n++ {
+		s1, s2 := newIP(), newIP()
+		u1 := PlantedUser{newUser(), []string{s1, s2, newIP()}}
+		u2 := PlantedUser{newUser(), []string{s1, s2, newIP()}}
+		users = append(users, u1, u2)
+		truths = append(truths, Truth{u1.UserID, u2.UserID, "pair", true})
+	}
+
+	if p.RingSize >= 3 {
+		for n := uint(0); n < p.DupRings; n++ {
+			ring := make([]PlantedUser, p.RingSize)
+			for j := range ring {
+				ring[j] = PlantedUser{UserID: newUser()}
+			}
+			for j := range ring {
+				next := &ring[(j+1)%len(ring)]
+				s1, s2 := newIP(), newIP()
+				ring[j].IPs = append(ring[j].IPs, s1, s2)
+				next.IPs = append(next.IPs, s1, s2)
+				truths = append(truths, Truth{ring[j].UserID, next.UserID, "ring", true})
+			}
+			users = append(users, ring...)
+		}
+	}
+
+	for n := uint(0); n < p.NATIPs; n++ {
+		nat := newIP()
+		var prev record.UserID
+		for j := uint(0); j < p.NATUsers; j++ {
+			u := PlantedUser{newUser(), []string{nat, newIP()}}
+			users = append(users, u)
+			if prev != 0 {
+				truths = append(truths, Truth{prev, u.UserID, "nat", false})
+			}
+			prev = u.UserID
+		}
+	}
+
+	return users, truths
+}
+
+// WriteTruth writes planted pairs as `user_id_1,user_id_2,kind,dupes` lines
+func WriteTruth(w io.Writer, p *Profile) error {
+	_, truths := Plant(p)
+	if _, err := fmt.Fprintln(w, "user_id_1,user_id_2,kind,dupes"); err != nil {
+		return err
+	}
+	for _, t := range truths {
+		if _, err := fmt.Fprintf(w, "%d,%d,%s,%t\n", t.U1, t.U2, t.Kind, t.Dupes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns an exponentially distributed value from 1 to int(MaxFloat64) with `max` limit
+// Represents how many different IPs used by user
+func (g *Generator) getUserIPSCount(max uint) uint {
+
+	count := uint(g.random.ExpFloat64() + 1)
+	if count > max {
+		count = max
+	}
+	return count
+}
+
+// Returns normally distributed value from 1 to int(MaxFloat64) with `max` limit
+// Represents how many requests user did
+func (g *Generator) getUserRequestsCount(mean, max uint) (res uint) {
+	desiredStdDev := 1.0
+	res = uint(g.random.NormFloat64()*desiredStdDev + float64(mean))
+	if res > max {
+		res = max
+	}
+	return res
+}
+
+// ipsGetter returns IPs from the pool according to the profile's distribution
+func (g *Generator) ipsGetter(p *Profile) func() string {
+	pool := uint32(p.IPPool)
+	if pool == 0 {
+		pool = 1
+	}
+	var next func() uint32
+	switch p.IPDistribution {
+	case DistributionUniform:
+		next = func() uint32 { return uint32(g.random.Int63n(int64(pool))) }
+	case DistributionZipf:
+		zipf := rand.NewZipf(g.random, zipfS, 1, uint64(pool-1))
+		next = func() uint32 { return uint32(zipf.Uint64()) }
+	default:
+		next = ring(pool)
+	}
+	return func() string {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, firstIP+next())
+		return ip.String()
+	}
+}
+
+// ring over all pool's IPs
+func ring(pool uint32) func() uint32 {
+	curr := uint32(0)
+	return func() uint32 {
+		if curr == pool-1 {
+			curr = uint32(0)
+		} else {
+			curr++
+		}
+		return curr
+	}
+}
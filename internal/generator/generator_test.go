@@ -0,0 +1,88 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testProfile = Profile{
+	UsersCount:          50,
+	RequestPerUserLimit: 20,
+	RequestPerUserMean:  5,
+	IPsPerUserLimit:     5,
+	IPPool:              100,
+	IPDistribution:      DistributionZipf,
+	DupPairs:            3,
+	DupRings:            2,
+	RingSize:            4,
+	NATIPs:              2,
+	NATUsers:            3,
+}
+
+func TestGenerator_Deterministic(t *testing.T) {
+	for _, d := range []string{DistributionRing, DistributionUniform, DistributionZipf} {
+		p := testProfile
+		p.IPDistribution = d
+
+		first := collect(t, &p, 42, 0)
+		assert.Equal(t, first, collect(t, &p, 42, 0), d)
+		assert.NotEqual(t, first, collect(t, &p, 43, 0), d)
+
+		// skipped records are generated anyway, so positions don't depend on skip
+		assert.Equal(t, first[10:], collect(t, &p, 42, 10), d)
+	}
+}
+
+func TestGenerator_Stop(t *testing.T) {
+	var n int
+	New(1).Generate(0, &testProfile, func(rec *record.Record, pos uint64) bool {
+		n++
+		return n < 5
+	})
+	assert.Equal(t, 5, n)
+}
+
+func TestGenerateDbg(t *testing.T) {
+	var res []string
+	New(1).GenerateDbg(6, func(rec *record.Record, pos uint64) bool {
+		res = append(res, fmt.Sprintf("%d:%d,%s", pos, rec.UserID, rec.IP))
+		return true
+	})
+	assert.Equal(t, []string{"7:3,127.0.0.1", "8:4,127.0.0.1"}, res)
+}
+
+func TestPlant(t *testing.T) {
+	users, truths := Plant(&testProfile)
+	assert.Len(t, users, 3*2+2*4+2*3)
+	assert.Equal(t, testProfile.UsersCount+1, uint(users[0].UserID))
+
+	kinds := map[string]int{}
+	for _, tr := range truths {
+		kinds[tr.Kind]++
+		assert.Equal(t, tr.Kind != "nat", tr.Dupes)
+	}
+	assert.Equal(t, map[string]int{"pair": 3, "ring": 8, "nat": 4}, kinds)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteTruth(buf, &testProfile))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, "user_id_1,user_id_2,kind,dupes", lines[0])
+	assert.Equal(t, "51,52,pair,true", lines[1])
+	assert.Len(t, lines, len(truths)+1)
+}
+
+func collect(t *testing.T, p *Profile, seed int64, skip uint64) []string {
+	var res []string
+	New(seed).Generate(skip, p, func(rec *record.Record, pos uint64) bool {
+		require.NoError(t, rec.Validate())
+		res = append(res, fmt.Sprintf("%d:%d,%s", pos, rec.UserID, rec.IP))
+		return true
+	})
+	return res
+}
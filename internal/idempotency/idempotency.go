@@ -0,0 +1,121 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// MaxKeyLength bounds client supplied keys
+const MaxKeyLength = 255
+
+// Errors of applying a batch under an idempotency key
+var (
+	ErrInProgress = errors.New("batch with the same idempotency key is in progress")
+	ErrInvalidKey = errors.New("idempotency key is too long")
+)
+
+// Result is the outcome of a batch applied under an idempotency key.
+// It's returned as is when the batch is replayed
+type Result struct {
+	Key       string
+	Status    int
+	Body      json.RawMessage
+	CreatedAt time.Time
+}
+
+// Remember saves the result of a batch within the transaction writing the batch
+type Remember func(tx *bolt.Tx, res *Result) error
+
+// Store applies batches at most once per idempotency key
+type Store struct {
+	repo      Repository
+	namespace string
+
+	*inflight
+}
+
+type inflight struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+// NewStore returns Store remembering results in repo
+func NewStore(repo Repository) *Store {
+	return &Store{repo: repo, inflight: &inflight{keys: make(map[string]struct{})}}
+}
+
+// Namespace returns Store sharing results with s, whose keys never match keys of s or of other namespaces.
+// Keys are prefixed by the namespace and NUL, which can't be sent in a header or a command line argument
+func (s *Store) Namespace(ns string) *Store {
+	return &Store{repo: s.repo, namespace: s.namespace + ns + "\x00", inflight: s.inflight}
+}
+
+// Do calls apply unless a result for the key is remembered already, in that case the remembered result
+// is returned with replayed set. Only successful results are remembered, so a failed batch may be retried.
+// Concurrent calls with the same key fail with ErrInProgress. Empty key disables the check.
+// apply should call remember with its result within the transaction writing the batch, so the key is
+// committed along with the batch. Otherwise the result is saved after apply returns, then a crash
+// in between lets the batch be applied again
+func (s *Store) Do(ctx context.Context, key string, apply func(remember Remember) (*Result, error)) (res *Result, replayed bool, err error) {
+	if key == "" {
+		res, err = apply(func(tx *bolt.Tx, res *Result) error { return nil })
+		return res, false, err
+	}
+	if len(key) > MaxKeyLength {
+		return nil, false, ErrInvalidKey
+	}
+	key = s.namespace + key
+	if !s.acquire(key) {
+		return nil, false, ErrInProgress
+	}
+	defer s.release(key)
+
+	res, err = s.repo.Get(ctx, key)
+	if err == nil {
+		return res, true, nil
+	}
+	if err != ErrNotFound {
+		return nil, false, err
+	}
+
+	remembered := false
+	res, err = apply(func(tx *bolt.Tx, res *Result) error {
+		res.Key = key
+		if err := s.repo.SaveTx(tx, res); err != nil {
+			return err
+		}
+		remembered = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if !remembered {
+		res.Key = key
+		if err := s.repo.Save(ctx, res); err != nil {
+			return nil, false, errors.Wrapf(err, "batch is applied but key %q is not saved", key)
+		}
+	}
+	return res, false, nil
+}
+
+func (f *inflight) acquire(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.keys[key]; ok {
+		return false
+	}
+	f.keys[key] = struct{}{}
+	return true
+}
+
+func (f *inflight) release(key string) {
+	f.mu.Lock()
+	delete(f.keys, key)
+	f.mu.Unlock()
+}
@@ -0,0 +1,149 @@
+package idempotency
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_Do(t *testing.T) {
+	repo, _, teardown := prepBoltRepo(t, time.Hour, 10)
+	defer teardown()
+	s := NewStore(repo)
+	ctx := context.Background()
+
+	var applied int
+	apply := func(Remember) (*Result, error) {
+		applied++
+		return &Result{Status: 200}, nil
+	}
+
+	res, replayed, err := s.Do(ctx, "k1", apply)
+	require.NoError(t, err)
+	assert.False(t, replayed)
+	assert.Equal(t, "k1", res.Key)
+
+	res, replayed, err = s.Do(ctx, "k1", apply)
+	require.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, 200, res.Status)
+	assert.Equal(t, 1, applied)
+
+	// empty key disables the check
+	_, _, err = s.Do(ctx, "", apply)
+	require.NoError(t, err)
+	_, _, err = s.Do(ctx, "", apply)
+	require.NoError(t, err)
+	assert.Equal(t, 3, applied)
+
+	_, _, err = s.Do(ctx, strings.Repeat("k", MaxKeyLength+1), apply)
+	assert.Equal(t, ErrInvalidKey, err)
+}
+
+func TestStore_Namespace(t *testing.T) {
+	repo, _, teardown := prepBoltRepo(t, time.Hour, 10)
+	defer teardown()
+	s := NewStore(repo)
+	ctx := context.Background()
+
+	var applied int
+	apply := func(Remember) (*Result, error) {
+		applied++
+		return &Result{Status: 200}, nil
+	}
+	for _, store := range []*Store{s, s.Namespace("a"), s.Namespace("b"), s.Namespace("a").Namespace("b")} {
+		_, replayed, err := store.Do(ctx, "k1", apply)
+		require.NoError(t, err)
+		assert.False(t, replayed)
+	}
+	assert.Equal(t, 4, applied)
+
+	_, replayed, err := s.Namespace("a").Do(ctx, "k1", apply)
+	require.NoError(t, err)
+	assert.True(t, replayed)
+}
+
+func TestStore_DoFailed(t *testing.T) {
+	repo, _, teardown := prepBoltRepo(t, time.Hour, 10)
+	defer teardown()
+	s := NewStore(repo)
+	ctx := context.Background()
+
+	_, _, err := s.Do(ctx, "k1", func(Remember) (*Result, error) { return nil, errors.New("failed") })
+	assert.EqualError(t, err, "failed")
+
+	// failed batch isn't remembered
+	_, replayed, err := s.Do(ctx, "k1", func(Remember) (*Result, error) { return &Result{Status: 200}, nil })
+	require.NoError(t, err)
+	assert.False(t, replayed)
+}
+
+func TestStore_DoInProgress(t *testing.T) {
+	repo, _, teardown := prepBoltRepo(t, time.Hour, 10)
+	defer teardown()
+	s := NewStore(repo)
+	ctx := context.Background()
+
+	_, _, err := s.Do(ctx, "k1", func(Remember) (*Result, error) {
+		_, _, err := s.Do(ctx, "k1", func(Remember) (*Result, error) { return &Result{}, nil })
+		assert.Equal(t, ErrInProgress, err)
+		return &Result{Status: 200}, nil
+	})
+	require.NoError(t, err)
+}
+
+// failingRepository fails to save results apart from batches
+type failingRepository struct {
+	Repository
+	saves int
+}
+
+func (r *failingRepository) Save(ctx context.Context, res *Result) error {
+	r.saves++
+	return errors.New("failed")
+}
+
+func TestStore_DoRemember(t *testing.T) {
+	repo, _, teardown := prepBoltRepo(t, time.Hour, 10)
+	defer teardown()
+	db := repo.(*boltRepository).DB
+	failing := &failingRepository{Repository: repo}
+	s := NewStore(failing)
+	ctx := context.Background()
+
+	// the key is written by the batch transaction, so it's rolled back along with the batch
+	_, _, err := s.Do(ctx, "k1", func(remember Remember) (*Result, error) {
+		return nil, db.Update(func(tx *bolt.Tx) error {
+			if err := remember(tx, &Result{Status: 200}); err != nil {
+				return err
+			}
+			return errors.New("rolled back")
+		})
+	})
+	assert.EqualError(t, err, "rolled back")
+	_, err = repo.Get(ctx, "k1")
+	assert.Equal(t, ErrNotFound, err)
+
+	res := &Result{Status: 200}
+	_, replayed, err := s.Do(ctx, "k1", func(remember Remember) (*Result, error) {
+		return res, db.Update(func(tx *bolt.Tx) error { return remember(tx, res) })
+	})
+	require.NoError(t, err)
+	assert.False(t, replayed)
+	assert.Equal(t, 0, failing.saves, "the key isn't saved apart")
+	got, err := repo.Get(ctx, "k1")
+	require.NoError(t, err)
+	assert.Equal(t, 200, got.Status)
+
+	// the result of a batch which isn't remembered within its transaction is saved after it,
+	// failing to save is an error even though the batch is applied
+	_, _, err = s.Do(ctx, "k2", func(Remember) (*Result, error) { return &Result{Status: 200}, nil })
+	assert.EqualError(t, err, `batch is applied but key "k2" is not saved: failed`)
+	assert.Equal(t, 1, failing.saves)
+}
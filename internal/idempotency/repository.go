@@ -0,0 +1,160 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+const (
+	bucketName       = "IDEMPOTENCY_KEYS"
+	expiryBucketName = "IDEMPOTENCY_EXPIRY"
+)
+
+// ErrNotFound is returned when there is no remembered result for the key
+var ErrNotFound = errors.New("idempotency key not found")
+
+// Repository remembers results by idempotency keys
+type Repository interface {
+	Get(ctx context.Context, key string) (*Result, error)
+	Save(ctx context.Context, res *Result) error
+	SaveTx(tx *bolt.Tx, res *Result) error
+}
+
+// boltRepository keeps results by keys and an index of keys ordered by creation time,
+// so expired and excess keys are pruned from the index head on every save.
+// Keys count is kept in the results bucket sequence
+type boltRepository struct {
+	DB        *bolt.DB
+	BKT       string
+	ExpiryBKT string
+
+	ttl     time.Duration
+	maxKeys uint64
+	now     func() time.Time
+}
+
+// Get returns the result by key or ErrNotFound. Expired results are not returned even if they aren't pruned yet
+func (b *boltRepository) Get(ctx context.Context, key string) (*Result, error) {
+	res := &Result{}
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(b.BKT)).Get([]byte(key))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, res)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if b.expired(res.CreatedAt) {
+		return nil, ErrNotFound
+	}
+	return res, nil
+}
+
+// Save remembers the result, sets its CreatedAt and prunes expired keys and keys above the limit
+func (b *boltRepository) Save(ctx context.Context, res *Result) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		return b.SaveTx(tx, res)
+	})
+}
+
+// SaveTx is Save within the caller's transaction, so the result is remembered along with the batch
+func (b *boltRepository) SaveTx(tx *bolt.Tx, res *Result) error {
+	res.CreatedAt = b.now().UTC()
+	buf, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	bkt := tx.Bucket([]byte(b.BKT))
+	expiry := tx.Bucket([]byte(b.ExpiryBKT))
+
+	count := bkt.Sequence()
+	if v := bkt.Get([]byte(res.Key)); v != nil {
+		old := &Result{}
+		if err := json.Unmarshal(v, old); err != nil {
+			return errors.Wrapf(err, "failed to decode key %q", res.Key)
+		}
+		if err := expiry.Delete(expiryKey(old)); err != nil {
+			return err
+		}
+	} else {
+		count++
+	}
+	if err := bkt.Put([]byte(res.Key), buf); err != nil {
+		return err
+	}
+	if err := expiry.Put(expiryKey(res), nil); err != nil {
+		return err
+	}
+
+	count, err = b.prune(bkt, expiry, count)
+	if err != nil {
+		return err
+	}
+	return bkt.SetSequence(count)
+}
+
+func (b *boltRepository) prune(bkt, expiry *bolt.Bucket, count uint64) (uint64, error) {
+	c := expiry.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.First() {
+		createdAt := time.Unix(0, int64(binary.BigEndian.Uint64(k[:8])))
+		if count <= b.maxKeys && !b.expired(createdAt) {
+			break
+		}
+		if err := c.Delete(); err != nil {
+			return count, err
+		}
+		if err := bkt.Delete(k[8:]); err != nil {
+			return count, err
+		}
+		count--
+	}
+	return count, nil
+}
+
+func (b *boltRepository) expired(createdAt time.Time) bool {
+	return b.now().Sub(createdAt) > b.ttl
+}
+
+// index key is the creation time in nanoseconds followed by the key itself
+func expiryKey(res *Result) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, uint64(res.CreatedAt.UnixNano()))
+	buf.WriteString(res.Key)
+	return buf.Bytes()
+}
+
+// NewBoltRepository makes boltdb Repository implementation, creates buckets if they don't exist.
+// Results are remembered for ttl, at most maxKeys of them are kept
+func NewBoltRepository(db *bolt.DB, ttl time.Duration, maxKeys int) (Repository, error) {
+	if maxKeys < 1 {
+		return nil, errors.Errorf("max keys should be positive, got %d", maxKeys)
+	}
+	r := boltRepository{
+		DB:        db,
+		BKT:       bucketName,
+		ExpiryBKT: expiryBucketName,
+		ttl:       ttl,
+		maxKeys:   uint64(maxKeys),
+		now:       time.Now,
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{r.BKT, r.ExpiryBKT} {
+			if _, e := tx.CreateBucketIfNotExists([]byte(name)); e != nil {
+				return errors.Wrapf(e, "failed to create bucket %s", name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
@@ -0,0 +1,99 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDb = "/tmp/test_idempotency.db"
+
+func TestBoltRepo_SaveGet(t *testing.T) {
+	r, _, teardown := prepBoltRepo(t, time.Hour, 10)
+	defer teardown()
+	ctx := context.Background()
+
+	require.NoError(t, r.Save(ctx, &Result{Key: "k1", Status: 200, Body: json.RawMessage(`{"added":1}`)}))
+
+	got, err := r.Get(ctx, "k1")
+	require.NoError(t, err)
+	assert.Equal(t, 200, got.Status)
+	assert.JSONEq(t, `{"added":1}`, string(got.Body))
+
+	_, err = r.Get(ctx, "unknown")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestBoltRepo_TTL(t *testing.T) {
+	r, clock, teardown := prepBoltRepo(t, time.Hour, 10)
+	defer teardown()
+	ctx := context.Background()
+
+	require.NoError(t, r.Save(ctx, &Result{Key: "k1"}))
+	*clock = clock.Add(30 * time.Minute)
+	require.NoError(t, r.Save(ctx, &Result{Key: "k2"}))
+
+	*clock = clock.Add(45 * time.Minute)
+	_, err := r.Get(ctx, "k1")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = r.Get(ctx, "k2")
+	assert.NoError(t, err)
+
+	// expired key is pruned on the next save
+	require.NoError(t, r.Save(ctx, &Result{Key: "k3"}))
+	assert.Equal(t, []string{"k2", "k3"}, keys(t, r))
+}
+
+func TestBoltRepo_MaxKeys(t *testing.T) {
+	r, clock, teardown := prepBoltRepo(t, time.Hour, 2)
+	defer teardown()
+	ctx := context.Background()
+
+	for _, k := range []string{"k1", "k2", "k1", "k3"} {
+		*clock = clock.Add(time.Second)
+		require.NoError(t, r.Save(ctx, &Result{Key: k}))
+	}
+	// k1 was saved again after k2, so k2 is the oldest one
+	assert.Equal(t, []string{"k1", "k3"}, keys(t, r))
+}
+
+func keys(t *testing.T, r Repository) []string {
+	b := r.(*boltRepository)
+	var res []string
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(b.BKT))
+		assert.Equal(t, uint64(bkt.Stats().KeyN), bkt.Sequence())
+		assert.Equal(t, bkt.Stats().KeyN, tx.Bucket([]byte(b.ExpiryBKT)).Stats().KeyN)
+		return bkt.ForEach(func(k, v []byte) error {
+			res = append(res, string(k))
+			return nil
+		})
+	})
+	require.NoError(t, err)
+	return res
+}
+
+func prepBoltRepo(t *testing.T, ttl time.Duration, maxKeys int) (repo Repository, clock *time.Time, teardown func()) {
+	_ = os.Remove(testDb)
+
+	db, err := bolt.Open(testDb, 0600, nil)
+	require.NoError(t, err)
+
+	repo, err = NewBoltRepository(db, ttl, maxKeys)
+	require.NoError(t, err)
+	now := time.Date(2020, 1, 15, 10, 0, 0, 0, time.UTC)
+	clock = &now
+	repo.(*boltRepository).now = func() time.Time { return *clock }
+
+	teardown = func() {
+		assert.NoError(t, db.Close())
+		_ = os.Remove(testDb)
+	}
+	return repo, clock, teardown
+}
@@ -0,0 +1,114 @@
+package idempotency
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/internal/fsck"
+	"github.com/pkg/errors"
+)
+
+// Check returns the check of results and their expiry index: every result is decoded and stored
+// under its key, the index has exactly one entry per result and the keys count matches.
+// On repair broken results are removed and the index is rebuilt
+func Check() fsck.Check {
+	return fsck.Check{Buckets: []string{bucketName, expiryBucketName}, Run: check}
+}
+
+func check(tx *bolt.Tx, repair bool, r *fsck.Report) error {
+	bkt := tx.Bucket([]byte(bucketName))
+	expiry := tx.Bucket([]byte(expiryBucketName))
+	if bkt == nil && expiry == nil {
+		return nil
+	}
+	if bkt == nil || expiry == nil {
+		missing := bucketName
+		if expiry == nil {
+			missing = expiryBucketName
+		}
+		r.Add(missing, "", "bucket doesn't exist", repair)
+		if !repair {
+			return nil
+		}
+		var err error
+		if bkt, err = tx.CreateBucketIfNotExists([]byte(bucketName)); err != nil {
+			return err
+		}
+		if expiry, err = tx.CreateBucketIfNotExists([]byte(expiryBucketName)); err != nil {
+			return err
+		}
+	}
+
+	var broken [][]byte
+	indexed := make(map[string]bool)
+	err := bkt.ForEach(func(k, v []byte) error {
+		r.Checked[bucketName]++
+		res := &Result{}
+		var err error
+		if v == nil {
+			err = errors.New("unexpected nested bucket")
+		} else if e := json.Unmarshal(v, res); e != nil {
+			err = errors.Wrap(e, "failed to decode")
+		} else if res.Key != string(k) {
+			err = errors.Errorf("result of key %q", res.Key)
+		}
+		if err != nil {
+			r.Add(bucketName, string(k), err.Error(), repair)
+			broken = append(broken, fsck.Copy(k))
+			return nil
+		}
+		indexed[string(expiryKey(res))] = false
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var dangling [][]byte
+	err = expiry.ForEach(func(k, v []byte) error {
+		r.Checked[expiryBucketName]++
+		if _, ok := indexed[string(k)]; !ok {
+			r.Add(expiryBucketName, hex.EncodeToString(k), "no result of the index entry", repair)
+			dangling = append(dangling, fsck.Copy(k))
+			return nil
+		}
+		indexed[string(k)] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for k, ok := range indexed {
+		if !ok {
+			missing = append(missing, k)
+		}
+	}
+	sort.Strings(missing)
+	for _, k := range missing {
+		r.Add(expiryBucketName, hex.EncodeToString([]byte(k)), fmt.Sprintf("no index entry of key %q", k[8:]), repair)
+	}
+	count := uint64(len(indexed))
+	if bkt.Sequence() != count {
+		r.Add(bucketName, "", fmt.Sprintf("keys count is %d, found %d keys", bkt.Sequence(), count), repair)
+	}
+	if !repair {
+		return nil
+	}
+
+	if err := fsck.Remove(bkt, broken); err != nil {
+		return err
+	}
+	if err := fsck.Remove(expiry, dangling); err != nil {
+		return err
+	}
+	for _, k := range missing {
+		if err := expiry.Put([]byte(k), nil); err != nil {
+			return err
+		}
+	}
+	return bkt.SetSequence(count)
+}
@@ -0,0 +1,49 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/internal/fsck"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	r, _, teardown := prepBoltRepo(t, time.Hour, 10)
+	defer teardown()
+	ctx := context.Background()
+	db := r.(*boltRepository).DB
+
+	for _, k := range []string{"k1", "k2", "k3"} {
+		require.NoError(t, r.Save(ctx, &Result{Key: k}))
+	}
+	report, err := fsck.Run(db, false, Check())
+	require.NoError(t, err)
+	assert.Equal(t, fsck.Clean, report.Status)
+	assert.Equal(t, map[string]int{bucketName: 3, expiryBucketName: 3}, report.Checked)
+
+	// k1 is broken, k2 has no index entry and the index has an entry of unknown key
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucketName))
+		expiry := tx.Bucket([]byte(expiryBucketName))
+		require.NoError(t, bkt.Put([]byte("k1"), []byte("{")))
+		res, err := r.Get(ctx, "k2")
+		require.NoError(t, err)
+		require.NoError(t, expiry.Delete(expiryKey(res)))
+		return expiry.Put(expiryKey(&Result{Key: "k4", CreatedAt: res.CreatedAt}), nil)
+	}))
+
+	report, err = fsck.Run(db, true, Check())
+	require.NoError(t, err)
+	assert.Equal(t, fsck.Repaired, report.Status)
+	// the index entry of broken k1 is dangling too
+	assert.Len(t, report.Problems, 5)
+
+	report, err = fsck.Run(db, false, Check())
+	require.NoError(t, err)
+	assert.Equal(t, fsck.Clean, report.Status)
+	assert.Equal(t, []string{"k2", "k3"}, keys(t, r))
+}
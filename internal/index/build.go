@@ -0,0 +1,187 @@
+package index
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/mullakhmetov/duplicates-checker/internal/fileutil"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+// BuildOptions configure the built index
+type BuildOptions struct {
+	// Reverse builds the index of users of every IP. It takes 8 bytes of memory per user's IP while building
+	Reverse bool
+	// Since skips IPs last seen before it, users left without IPs are skipped. Zero time keeps all IPs
+	Since time.Time
+}
+
+// Build writes the index of repo's users to path. Users are read within a single transaction, user IDs,
+// offsets and last seen times are kept in memory while IPs are streamed to the file. IPs of repositories
+// keeping no last seen times are seen at the build time. The index is written to a temporary
+// file and renamed once it's complete, so a served index may be replaced
+func Build(ctx context.Context, repo record.Repository, path string, opts BuildOptions) (*Header, error) {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	h, err := build(ctx, repo, f, opts)
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return nil, errors.Wrapf(err, "failed to build index %s", path)
+	}
+	return h, fileutil.SyncDir(path)
+}
+
+func build(ctx context.Context, repo record.Repository, f *os.File, opts BuildOptions) (*Header, error) {
+	w := &sectionWriter{w: bufio.NewWriterSize(f, 1<<20)}
+	w.skip(headerSize)
+
+	builtAt := time.Now().UTC().Truncate(time.Second)
+	var users []record.UserID
+	var offsets []uint64
+	var seen []uint32
+	var pairs []uint64
+	var n uint64
+	err := repo.ForEachUser(ctx, func(info *record.UserInfo) error {
+		start := n
+		timed := len(info.LastSeen) == len(info.IPs)
+		for i, ip := range info.IPs {
+			if !opts.Since.IsZero() && timed && int64(info.LastSeen[i]) < opts.Since.Unix() {
+				continue
+			}
+			w.uint32(ip)
+			if timed {
+				seen = append(seen, info.LastSeen[i])
+			} else {
+				seen = append(seen, uint32(builtAt.Unix()))
+			}
+			if opts.Reverse {
+				pairs = append(pairs, uint64(ip)<<32|uint64(info.UserID))
+			}
+			n++
+		}
+		if n > start {
+			users = append(users, info.UserID)
+			offsets = append(offsets, start)
+		}
+		return w.err
+	})
+	if err != nil {
+		return nil, err
+	}
+	offsets = append(offsets, n)
+
+	for _, ts := range seen {
+		w.uint32(ts)
+	}
+	w.align()
+	for _, uID := range users {
+		w.uint32(uint32(uID))
+	}
+	w.align()
+	for _, off := range offsets {
+		w.uint64(off)
+	}
+
+	h := &Header{Version: Version, Reverse: opts.Reverse, Users: uint64(len(users)), IPs: n, BuiltAt: builtAt}
+	if opts.Reverse {
+		h.ReverseIPs = writeReverse(w, pairs)
+	}
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	if size := h.layout().size; w.n != size {
+		return nil, errors.Errorf("written %d bytes, %d bytes expected", w.n, size)
+	}
+
+	h.Checksum = w.crc
+	if _, err := f.WriteAt(h.marshal(), 0); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// writeReverse writes the reverse index of IP and user pairs and returns the number of distinct IPs
+func writeReverse(w *sectionWriter, pairs []uint64) uint64 {
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i] < pairs[j] })
+	var offsets []uint64
+	for i, p := range pairs {
+		if i == 0 || p>>32 != pairs[i-1]>>32 {
+			w.uint32(uint32(p >> 32))
+			offsets = append(offsets, uint64(i))
+		}
+	}
+	distinct := uint64(len(offsets))
+	offsets = append(offsets, uint64(len(pairs)))
+
+	w.align()
+	for _, off := range offsets {
+		w.uint64(off)
+	}
+	for _, p := range pairs {
+		w.uint32(uint32(p))
+	}
+	return distinct
+}
+
+// sectionWriter writes little-endian values counting their CRC-32C, the first error is kept in err
+type sectionWriter struct {
+	w   *bufio.Writer
+	n   int64
+	crc uint32
+	buf [8]byte
+	err error
+}
+
+// skip writes n zero bytes which aren't counted in the checksum
+func (w *sectionWriter) skip(n int) {
+	if w.err == nil {
+		_, w.err = w.w.Write(make([]byte, n))
+		w.n += int64(n)
+	}
+}
+
+func (w *sectionWriter) write(b []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(b)
+		w.crc = crc32.Update(w.crc, crcTable, b)
+		w.n += int64(len(b))
+	}
+}
+
+func (w *sectionWriter) uint32(v uint32) {
+	binary.LittleEndian.PutUint32(w.buf[:4], v)
+	w.write(w.buf[:4])
+}
+
+func (w *sectionWriter) uint64(v uint64) {
+	binary.LittleEndian.PutUint64(w.buf[:], v)
+	w.write(w.buf[:])
+}
+
+// align pads the written values up to 8 bytes
+func (w *sectionWriter) align() {
+	if pad := align(w.n) - w.n; pad > 0 {
+		w.write(make([]byte, pad))
+	}
+}
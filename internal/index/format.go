@@ -0,0 +1,134 @@
+package index
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Version is the format version written by Build. Version 1 indexes have no last seen times and aren't read
+const Version = 2
+
+const headerSize = 64
+
+// flags of the header
+const flagReverse = 1
+
+var magic = [8]byte{'D', 'U', 'P', 'S', 'I', 'D', 'X', 0}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Errors of opening an index file
+var (
+	ErrNotIndex           = errors.New("file is not an index")
+	ErrUnsupportedVersion = errors.New("unsupported index version")
+	ErrCorrupted          = errors.New("index is corrupted")
+)
+
+// Header describes the index file. The file is the header followed by sections of little-endian values,
+// sections of 8 byte values are aligned to 8 bytes:
+//
+//	header    64 bytes, see Header
+//	ips       uint32 IPs of every user in user order, IPs of a user are sorted
+//	seen      uint32 unix time of the last record of every IP in the order of ips
+//	users     uint32 user IDs in ascending order
+//	offsets   uint64 index of the first IP of every user, followed by the number of IPs
+//
+// and, if the reverse index is built:
+//
+//	rev_ips     uint32 distinct IPs in ascending order
+//	rev_offsets uint64 index of the first user of every distinct IP, followed by the number of users' IPs
+//	rev_users   uint32 user IDs of every distinct IP in ascending order
+type Header struct {
+	Version uint32
+	Reverse bool
+	Users   uint64
+	// IPs is the number of users' IPs, every user's IP is counted
+	IPs uint64
+	// ReverseIPs is the number of distinct IPs of the reverse index
+	ReverseIPs uint64
+	BuiltAt    time.Time
+	// Checksum is CRC-32C of everything after the header
+	Checksum uint32
+}
+
+func (h *Header) marshal() []byte {
+	buf := make([]byte, headerSize)
+	copy(buf, magic[:])
+	binary.LittleEndian.PutUint32(buf[8:], h.Version)
+	if h.Reverse {
+		binary.LittleEndian.PutUint32(buf[12:], flagReverse)
+	}
+	binary.LittleEndian.PutUint64(buf[16:], h.Users)
+	binary.LittleEndian.PutUint64(buf[24:], h.IPs)
+	binary.LittleEndian.PutUint64(buf[32:], h.ReverseIPs)
+	binary.LittleEndian.PutUint64(buf[40:], uint64(h.BuiltAt.Unix()))
+	binary.LittleEndian.PutUint32(buf[48:], h.Checksum)
+	return buf
+}
+
+func (h *Header) unmarshal(buf []byte) error {
+	if len(buf) < headerSize || string(buf[:8]) != string(magic[:]) {
+		return ErrNotIndex
+	}
+	h.Version = binary.LittleEndian.Uint32(buf[8:])
+	if h.Version != Version {
+		return errors.Wrapf(ErrUnsupportedVersion, "version %d, this build reads version %d", h.Version, Version)
+	}
+	h.Reverse = binary.LittleEndian.Uint32(buf[12:])&flagReverse != 0
+	h.Users = binary.LittleEndian.Uint64(buf[16:])
+	h.IPs = binary.LittleEndian.Uint64(buf[24:])
+	h.ReverseIPs = binary.LittleEndian.Uint64(buf[32:])
+	h.BuiltAt = time.Unix(int64(binary.LittleEndian.Uint64(buf[40:])), 0).UTC()
+	h.Checksum = binary.LittleEndian.Uint32(buf[48:])
+	return nil
+}
+
+// layout is the position of every section in the file
+type layout struct {
+	ips, seen, users, offsets    int64
+	revIPs, revOffsets, revUsers int64
+	size                         int64
+}
+
+func (h *Header) layout() layout {
+	var l layout
+	l.ips = headerSize
+	l.seen = l.ips + 4*int64(h.IPs)
+	l.users = align(l.seen + 4*int64(h.IPs))
+	l.offsets = align(l.users + 4*int64(h.Users))
+	l.size = l.offsets + 8*int64(h.Users+1)
+	if h.Reverse {
+		l.revIPs = l.size
+		l.revOffsets = align(l.revIPs + 4*int64(h.ReverseIPs))
+		l.revUsers = l.revOffsets + 8*int64(h.ReverseIPs+1)
+		l.size = l.revUsers + 4*int64(h.IPs)
+	}
+	return l
+}
+
+// align rounds off up to 8 bytes
+func align(off int64) int64 {
+	return (off + 7) &^ 7
+}
+
+// IsIndex returns true if the file at path is an index of any version, so it isn't opened as a bolt file
+func IsIndex(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	buf := make([]byte, len(magic))
+	if _, err := io.ReadFull(f, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return string(buf) == string(magic[:]), nil
+}
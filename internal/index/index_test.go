@@ -0,0 +1,336 @@
+package index
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/internal/generator"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testDb    = "/tmp/test_index.db"
+	testIndex = "/tmp/test_index.idx"
+)
+
+var testProfile = generator.Profile{
+	UsersCount:          500,
+	RequestPerUserLimit: 30,
+	RequestPerUserMean:  8,
+	IPsPerUserLimit:     10,
+	IPPool:              300,
+	DupPairs:            5,
+	DupRings:            2,
+	RingSize:            4,
+	NATIPs:              3,
+	NATUsers:            4,
+}
+
+func TestIndex_EqualsBolt(t *testing.T) {
+	ctx := context.Background()
+	for _, d := range []string{generator.DistributionRing, generator.DistributionUniform, generator.DistributionZipf} {
+		p := testProfile
+		p.IPDistribution = d
+		boltRepo, teardown := prepDataset(t, &p, 42)
+
+		h, err := Build(ctx, boltRepo, testIndex, BuildOptions{Reverse: true})
+		require.NoError(t, err, d)
+		idx, err := Open(testIndex)
+		require.NoError(t, err, d)
+		assert.Equal(t, h.Users, idx.Header().Users, d)
+
+		// every user and a few unknown ones
+		var maxID record.UserID
+		require.NoError(t, boltRepo.ForEachUser(ctx, func(info *record.UserInfo) error {
+			maxID = info.UserID
+			return nil
+		}))
+		for uID := record.UserID(0); uID <= maxID+3; uID++ {
+			want, err := boltRepo.GetUserInfo(ctx, uID)
+			require.NoError(t, err)
+			got, err := idx.GetUserInfo(ctx, uID)
+			require.NoError(t, err)
+			assert.Equal(t, want.UserID, got.UserID, "%s user %d", d, uID)
+			assert.Equal(t, []uint32(want.IPs), []uint32(got.IPs), "%s user %d", d, uID)
+		}
+
+		assert.Equal(t, users(t, boltRepo, 0, 0), users(t, idx, 0, 0), d)
+		assert.Equal(t, users(t, boltRepo, 100, 200), users(t, idx, 100, 200), d)
+		assert.Equal(t, users(t, boltRepo, maxID, 0), users(t, idx, maxID, 0), d)
+		assert.Empty(t, users(t, idx, maxID+1, 0), d)
+
+		// users of every IP match the ones scanned from bolt
+		usersOf := map[uint32][]record.UserID{}
+		require.NoError(t, boltRepo.ForEachUser(ctx, func(info *record.UserInfo) error {
+			for _, ip := range info.IPs {
+				usersOf[ip] = append(usersOf[ip], info.UserID)
+			}
+			return nil
+		}))
+		assert.Equal(t, uint64(len(usersOf)), idx.Header().ReverseIPs, d)
+		for ip, want := range usersOf {
+			got, err := idx.UsersOf(record.IPSet{ip}.IP(0))
+			require.NoError(t, err)
+			assert.Equal(t, want, got, "%s IP %s", d, record.IPSet{ip}.IP(0))
+		}
+		got, err := idx.UsersOf(net.ParseIP("255.255.255.255"))
+		require.NoError(t, err)
+		assert.Empty(t, got)
+
+		// both backends give the same answers to the service
+		boltService, idxService := record.NewService(boltRepo), record.NewService(idx)
+		_, truths := generator.Plant(&p)
+		for _, tr := range truths {
+			dupes, err := idxService.IsDuple(ctx, tr.U1, tr.U2)
+			require.NoError(t, err)
+			assert.Equal(t, tr.Dupes, dupes, "%s planted %d and %d", d, tr.U1, tr.U2)
+		}
+		rnd := rand.New(rand.NewSource(1))
+		for i := 0; i < 2000; i++ {
+			u1, u2 := record.UserID(rnd.Intn(int(maxID)+3)), record.UserID(rnd.Intn(int(maxID)+3))
+			want, err := boltService.IsDuple(ctx, u1, u2)
+			require.NoError(t, err)
+			got, err := idxService.IsDuple(ctx, u1, u2)
+			require.NoError(t, err)
+			assert.Equal(t, want, got, "%s users %d and %d", d, u1, u2)
+		}
+
+		require.NoError(t, idx.Close())
+		teardown()
+	}
+}
+
+func TestIndex_Empty(t *testing.T) {
+	repo, teardown := prepDataset(t, nil, 0)
+	defer teardown()
+	ctx := context.Background()
+
+	h, err := Build(ctx, repo, testIndex, BuildOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), h.Users)
+	idx, err := Open(testIndex)
+	require.NoError(t, err)
+	defer idx.Close()
+
+	info, err := idx.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Empty(t, info.IPs)
+	assert.Empty(t, users(t, idx, 0, 0))
+	_, err = idx.UsersOf(net.ParseIP("1.1.1.1"))
+	assert.Equal(t, ErrNoReverse, err)
+}
+
+// timedRepository serves users with last seen times
+type timedRepository struct {
+	record.Repository
+	users []*record.UserInfo
+}
+
+func (r *timedRepository) ForEachUser(ctx context.Context, fn func(info *record.UserInfo) error) error {
+	for _, info := range r.users {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestBuild_Since(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	old, recent := uint32(now.Add(-48*time.Hour).Unix()), uint32(now.Add(-time.Hour).Unix())
+	repo := &timedRepository{users: []*record.UserInfo{
+		{UserID: 1, IPs: record.IPSet{1, 2}, LastSeen: []uint32{old, recent}},
+		{UserID: 2, IPs: record.IPSet{1}, LastSeen: []uint32{old}},
+		{UserID: 3, IPs: record.IPSet{2, 3}, LastSeen: []uint32{recent, recent}},
+	}}
+	defer os.Remove(testIndex)
+
+	h, err := Build(ctx, repo, testIndex, BuildOptions{Since: now.Add(-24 * time.Hour), Reverse: true})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), h.Users)
+	assert.Equal(t, uint64(3), h.IPs)
+	assert.Equal(t, uint64(2), h.ReverseIPs)
+
+	idx, err := Open(testIndex)
+	require.NoError(t, err)
+	defer idx.Close()
+	infos, err := idx.GetUserInfos(ctx, 1, 2, 3)
+	require.NoError(t, err)
+	assert.Equal(t, record.IPSet{2}, infos[0].IPs)
+	assert.Empty(t, infos[1].IPs)
+	assert.Equal(t, record.IPSet{2, 3}, infos[2].IPs)
+	assert.Equal(t, []uint32{recent, recent}, infos[2].LastSeen)
+	users, err := idx.UsersOf(net.ParseIP("0.0.0.2"))
+	require.NoError(t, err)
+	assert.Equal(t, []record.UserID{1, 3}, users)
+
+	// IPs expired after the index is built are ignored by the service
+	service := record.NewServiceWithRules(idx, record.Rules{CommonIPs: 1, Retention: 2 * time.Hour})
+	dupes, err := service.IsDuple(ctx, 1, 3)
+	require.NoError(t, err)
+	assert.True(t, dupes)
+	service = record.NewServiceWithRules(idx, record.Rules{CommonIPs: 1, Retention: time.Minute})
+	dupes, err = service.IsDuple(ctx, 1, 3)
+	require.NoError(t, err)
+	assert.False(t, dupes)
+
+	// IPs of repositories keeping no last seen times are seen at the build time
+	repo = &timedRepository{users: []*record.UserInfo{{UserID: 1, IPs: record.IPSet{1}}}}
+	h, err = Build(ctx, repo, testIndex, BuildOptions{Since: now.Add(-24 * time.Hour)})
+	require.NoError(t, err)
+	idx2, err := Open(testIndex)
+	require.NoError(t, err)
+	defer idx2.Close()
+	info, err := idx2.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{uint32(h.BuiltAt.Unix())}, info.LastSeen)
+}
+
+func TestOpen_Invalid(t *testing.T) {
+	ctx := context.Background()
+	repo := &timedRepository{users: []*record.UserInfo{{UserID: 1, IPs: record.IPSet{1, 2}}}}
+	defer os.Remove(testIndex)
+	_, err := Build(ctx, repo, testIndex, BuildOptions{})
+	require.NoError(t, err)
+	ok, err := IsIndex(testIndex)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	buf, err := ioutil.ReadFile(testIndex)
+	require.NoError(t, err)
+
+	corrupt := func(buf []byte) error {
+		require.NoError(t, ioutil.WriteFile(testIndex, buf, 0600))
+		_, err := Open(testIndex)
+		return errors.Cause(err)
+	}
+	flipped := append([]byte(nil), buf...)
+	flipped[headerSize] ^= 1
+	assert.Equal(t, ErrCorrupted, corrupt(flipped))
+	assert.Equal(t, ErrCorrupted, corrupt(buf[:len(buf)-1]))
+	newer := append([]byte(nil), buf...)
+	newer[8] = Version + 1
+	assert.Equal(t, ErrUnsupportedVersion, corrupt(newer))
+	older := append([]byte(nil), buf...)
+	older[8] = 1
+	assert.Equal(t, ErrUnsupportedVersion, corrupt(older), "version 1 has no last seen times")
+	// counts of users, IPs and reverse IPs overflowing the layout to the file size
+	for _, off := range []int{16, 24, 32} {
+		huge := append([]byte(nil), buf...)
+		binary.LittleEndian.PutUint64(huge[off:], 1<<62)
+		assert.Equal(t, ErrCorrupted, corrupt(huge), "count at %d", off)
+	}
+	assert.Equal(t, ErrNotIndex, corrupt(buf[:10]))
+	assert.Equal(t, ErrNotIndex, corrupt(make([]byte, len(buf))))
+
+	// bolt files aren't indexes
+	_, teardown := prepDataset(t, nil, 0)
+	defer teardown()
+	ok, err = IsIndex(testDb)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, err = Open(testDb)
+	assert.Equal(t, ErrNotIndex, errors.Cause(err))
+}
+
+func TestRepository_ReadOnly(t *testing.T) {
+	ctx := context.Background()
+	repo := &timedRepository{users: []*record.UserInfo{{UserID: 1, IPs: record.IPSet{1}}}}
+	defer os.Remove(testIndex)
+	_, err := Build(ctx, repo, testIndex, BuildOptions{})
+	require.NoError(t, err)
+	idx, err := Open(testIndex)
+	require.NoError(t, err)
+	defer idx.Close()
+
+	assert.Equal(t, bolt.ErrDatabaseReadOnly, idx.AddRecord(ctx, record.NewRecord(1, "1.1.1.1")))
+	assert.Equal(t, bolt.ErrDatabaseReadOnly, idx.MergeUserIPs(ctx, record.UserIPs{}))
+	_, err = idx.DeleteUsers(ctx, []record.UserID{1}, "ticket-1")
+	assert.Equal(t, bolt.ErrDatabaseReadOnly, err)
+	_, err = idx.Purge(ctx, time.Now())
+	assert.Equal(t, bolt.ErrDatabaseReadOnly, err)
+
+	// IPs are capped, appending to them copies
+	info, err := idx.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	_ = append(info.IPs, 2)
+	info, err = idx.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, record.IPSet{1}, info.IPs)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = idx.GetUserInfos(cancelled, 1)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, context.Canceled, idx.ForEachUser(cancelled, func(info *record.UserInfo) error { return nil }))
+}
+
+func BenchmarkGetUserInfos(b *testing.B) {
+	ctx := context.Background()
+	p := testProfile
+	p.IPDistribution = generator.DistributionZipf
+	boltRepo, teardown := prepDataset(b, &p, 42)
+	defer teardown()
+	_, err := Build(ctx, boltRepo, testIndex, BuildOptions{})
+	require.NoError(b, err)
+	idx, err := Open(testIndex)
+	require.NoError(b, err)
+	defer idx.Close()
+
+	for _, bench := range []struct {
+		name string
+		repo record.Repository
+	}{{"bolt", boltRepo}, {"index", idx}} {
+		repo := bench.repo
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				u := record.UserID(i % int(p.UsersCount))
+				if _, err := repo.GetUserInfos(ctx, u, u+1); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func users(t testing.TB, repo record.Repository, from, to record.UserID) map[record.UserID][]uint32 {
+	res := map[record.UserID][]uint32{}
+	require.NoError(t, repo.ForEachUserInRange(context.Background(), from, to, func(info *record.UserInfo) error {
+		res[info.UserID] = info.IPs
+		return nil
+	}))
+	return res
+}
+
+// prepDataset loads the generated dataset to a new bolt store, nil profile leaves it empty
+func prepDataset(t testing.TB, p *generator.Profile, seed int64) (record.Repository, func()) {
+	_ = os.Remove(testDb)
+	db, err := record.NewBoltDB(testDb, nil)
+	require.NoError(t, err)
+	repo, err := record.NewBoltRepository(db)
+	require.NoError(t, err)
+	if p != nil {
+		var records []*record.Record
+		generator.New(seed).Generate(0, p, func(rec *record.Record, pos uint64) bool {
+			records = append(records, rec)
+			return true
+		})
+		require.NoError(t, repo.BulkAddRecords(context.Background(), records))
+	}
+	return repo, func() {
+		assert.NoError(t, db.Close())
+		_ = os.Remove(testDb)
+		_ = os.Remove(testIndex)
+	}
+}
@@ -0,0 +1,293 @@
+package index
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"os"
+	"sort"
+	"time"
+	"unsafe"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+// ErrNoReverse is returned by UsersOf when the index is built without the reverse index
+var ErrNoReverse = errors.New("index has no reverse index")
+
+// littleEndian is true if the file's byte order is the native one, so sections are used in place
+var littleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// Repository is a read-only record.Repository over a memory mapped index. Users are found by binary search
+// of the user table and their IPs and last seen times are returned in place without copying, so they must not
+// be modified and must not be used after Close. A served index is closed once requests holding it are
+// finished, see record.SwappableRepository.Hold.
+// Writes return bolt.ErrDatabaseReadOnly like a read-only store does
+type Repository struct {
+	header Header
+	data   []byte
+
+	ips        []uint32
+	seen       []uint32
+	users      []record.UserID
+	offsets    []uint64
+	revIPs     []uint32
+	revOffsets []uint64
+	revUsers   []record.UserID
+}
+
+// Open maps the index file and checks its checksum, which reads the file through, so the first requests
+// don't hit the disk. The file may be replaced or removed while it's served
+func Open(path string) (*Repository, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < headerSize {
+		return nil, errors.Wrapf(ErrNotIndex, "failed to open %s", path)
+	}
+	data, err := mmap(f, int(fi.Size()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to map %s", path)
+	}
+	r := &Repository{data: data}
+	if err := r.load(); err != nil {
+		_ = munmap(data)
+		return nil, errors.Wrapf(err, "failed to open %s", path)
+	}
+	return r, nil
+}
+
+func (r *Repository) load() error {
+	h := &r.header
+	if err := h.unmarshal(r.data); err != nil {
+		return err
+	}
+	// every counted value takes at least 4 bytes, larger counts would overflow the layout
+	if limit := uint64(len(r.data) / 4); h.IPs > limit || h.Users > limit || h.ReverseIPs > limit {
+		return errors.Wrapf(ErrCorrupted, "counts of %d users, %d IPs and %d reverse IPs exceed the size of %d bytes",
+			h.Users, h.IPs, h.ReverseIPs, len(r.data))
+	}
+	l := h.layout()
+	if l.size != int64(len(r.data)) {
+		return errors.Wrapf(ErrCorrupted, "size is %d bytes, %d bytes expected", len(r.data), l.size)
+	}
+	if crc32.Checksum(r.data[headerSize:], crcTable) != h.Checksum {
+		return errors.Wrap(ErrCorrupted, "checksum mismatch")
+	}
+
+	r.ips = uint32s(r.data[l.ips:], h.IPs)
+	r.seen = uint32s(r.data[l.seen:], h.IPs)
+	r.users = userIDs(r.data[l.users:], h.Users)
+	r.offsets = uint64s(r.data[l.offsets:], h.Users+1)
+	if err := checkOffsets(r.offsets, h.IPs); err != nil {
+		return err
+	}
+	if h.Reverse {
+		r.revIPs = uint32s(r.data[l.revIPs:], h.ReverseIPs)
+		r.revOffsets = uint64s(r.data[l.revOffsets:], h.ReverseIPs+1)
+		r.revUsers = userIDs(r.data[l.revUsers:], h.IPs)
+		if err := checkOffsets(r.revOffsets, h.IPs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkOffsets checks offsets are ascending and within n values, so lookups never go out of sections
+func checkOffsets(offsets []uint64, n uint64) error {
+	if offsets[0] != 0 || offsets[len(offsets)-1] != n {
+		return errors.Wrap(ErrCorrupted, "offsets are out of range")
+	}
+	for i := 1; i < len(offsets); i++ {
+		if offsets[i] < offsets[i-1] {
+			return errors.Wrapf(ErrCorrupted, "offset %d is less than the previous one", i)
+		}
+	}
+	return nil
+}
+
+// Header returns the header of the index
+func (r *Repository) Header() Header {
+	return r.header
+}
+
+// GetUserInfo returns UserInfo of the user, UserInfo of unknown user has no IPs
+func (r *Repository) GetUserInfo(ctx context.Context, userID record.UserID) (*record.UserInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.userInfo(userID), nil
+}
+
+// GetUserInfos returns UserInfos of users in the order of userIDs
+func (r *Repository) GetUserInfos(ctx context.Context, userIDs ...record.UserID) ([]*record.UserInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	infos := make([]*record.UserInfo, len(userIDs))
+	for i, uID := range userIDs {
+		infos[i] = r.userInfo(uID)
+	}
+	return infos, nil
+}
+
+func (r *Repository) userInfo(userID record.UserID) *record.UserInfo {
+	i := r.search(userID)
+	if i == len(r.users) || r.users[i] != userID {
+		return &record.UserInfo{}
+	}
+	return r.at(i)
+}
+
+// search returns the index of the first user not less than userID
+func (r *Repository) search(userID record.UserID) int {
+	return sort.Search(len(r.users), func(i int) bool { return r.users[i] >= userID })
+}
+
+// at returns UserInfo of i-th user, its IPs and last seen times are capped, so appending to them never
+// writes the mapping
+func (r *Repository) at(i int) *record.UserInfo {
+	from, to := r.offsets[i], r.offsets[i+1]
+	return &record.UserInfo{UserID: r.users[i], IPs: record.IPSet(r.ips[from:to:to]), LastSeen: r.seen[from:to:to]}
+}
+
+// ForEachUser calls fn for every user in ascending order of IDs. Iteration stops on the first error of fn
+// or on ctx cancellation
+func (r *Repository) ForEachUser(ctx context.Context, fn func(info *record.UserInfo) error) error {
+	return r.ForEachUserInRange(ctx, 0, 0, fn)
+}
+
+// ForEachUserInRange is ForEachUser for users from `from` to `to` inclusive, zero `to` means no upper bound
+func (r *Repository) ForEachUserInRange(ctx context.Context, from, to record.UserID, fn func(info *record.UserInfo) error) error {
+	for i := r.search(from); i < len(r.users); i++ {
+		if to != 0 && r.users[i] > to {
+			return nil
+		}
+		if i%1e4 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := fn(r.at(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UsersOf returns users of the IP in ascending order, the returned slice must not be modified
+func (r *Repository) UsersOf(ip net.IP) ([]record.UserID, error) {
+	if !r.header.Reverse {
+		return nil, ErrNoReverse
+	}
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil, nil
+	}
+	v := binary.BigEndian.Uint32(ip4)
+	i := sort.Search(len(r.revIPs), func(i int) bool { return r.revIPs[i] >= v })
+	if i == len(r.revIPs) || r.revIPs[i] != v {
+		return nil, nil
+	}
+	from, to := r.revOffsets[i], r.revOffsets[i+1]
+	return r.revUsers[from:to:to], nil
+}
+
+// StorageStats returns the file size, the index has no pages and buckets
+func (r *Repository) StorageStats(ctx context.Context) (*record.StorageStats, error) {
+	return &record.StorageStats{FileSize: int64(len(r.data)), Buckets: map[string]bolt.BucketStats{}}, nil
+}
+
+// AddRecord returns bolt.ErrDatabaseReadOnly, the index is immutable
+func (r *Repository) AddRecord(ctx context.Context, rec *record.Record) error {
+	return bolt.ErrDatabaseReadOnly
+}
+
+// BulkAddRecords returns bolt.ErrDatabaseReadOnly, the index is immutable
+func (r *Repository) BulkAddRecords(ctx context.Context, records []*record.Record) error {
+	return bolt.ErrDatabaseReadOnly
+}
+
+// MergeUserIPs returns bolt.ErrDatabaseReadOnly, the index is immutable
+func (r *Repository) MergeUserIPs(ctx context.Context, users record.UserIPs) error {
+	return bolt.ErrDatabaseReadOnly
+}
+
+// DeleteUser returns bolt.ErrDatabaseReadOnly, the index is immutable
+func (r *Repository) DeleteUser(ctx context.Context, userID record.UserID, ref string) (bool, error) {
+	return false, bolt.ErrDatabaseReadOnly
+}
+
+// DeleteUsers returns bolt.ErrDatabaseReadOnly, the index is immutable
+func (r *Repository) DeleteUsers(ctx context.Context, userIDs []record.UserID, ref string) (int, error) {
+	return 0, bolt.ErrDatabaseReadOnly
+}
+
+// Purge returns bolt.ErrDatabaseReadOnly, the index is immutable
+func (r *Repository) Purge(ctx context.Context, olderThan time.Time) (*record.Purged, error) {
+	return nil, bolt.ErrDatabaseReadOnly
+}
+
+// Clean returns bolt.ErrDatabaseReadOnly, the index is immutable
+func (r *Repository) Clean(ctx context.Context) error {
+	return bolt.ErrDatabaseReadOnly
+}
+
+// Close unmaps the index, returned users' IPs and last seen times must not be used after that
+func (r *Repository) Close() error {
+	if r.data == nil {
+		return nil
+	}
+	err := munmap(r.data)
+	r.data = nil
+	return err
+}
+
+// maxMapped bounds arrays the mapped values are viewed through: 2GB on 32-bit platforms and 256TB on 64-bit ones
+const maxMapped = 1<<(31+17*(32<<(^uint(0)>>63)/64)) - 1
+
+// uint32s returns n values at the start of b. The values are used in place if the byte order is native
+func uint32s(b []byte, n uint64) []uint32 {
+	if n == 0 {
+		return nil
+	}
+	if !littleEndian {
+		s := make([]uint32, n)
+		for i := range s {
+			s[i] = binary.LittleEndian.Uint32(b[4*i:])
+		}
+		return s
+	}
+	return (*[maxMapped / 4]uint32)(unsafe.Pointer(&b[0]))[:n:n]
+}
+
+// uint64s is uint32s for 8 byte values
+func uint64s(b []byte, n uint64) []uint64 {
+	if n == 0 {
+		return nil
+	}
+	if !littleEndian {
+		s := make([]uint64, n)
+		for i := range s {
+			s[i] = binary.LittleEndian.Uint64(b[8*i:])
+		}
+		return s
+	}
+	return (*[maxMapped / 8]uint64)(unsafe.Pointer(&b[0]))[:n:n]
+}
+
+// userIDs is uint32s for user IDs
+func userIDs(b []byte, n uint64) []record.UserID {
+	s := uint32s(b, n)
+	return *(*[]record.UserID)(unsafe.Pointer(&s))
+}
@@ -1,19 +1,38 @@
 package record
 
 import (
+	"log"
 	"net/http"
 	"strconv"
 
+	"github.com/boltdb/bolt"
 	"github.com/gin-gonic/gin"
 )
 
 // RegisterHandlers register record service handlers in router
-func RegisterHandlers(r *gin.Engine, service Service) {
+func RegisterHandlers(r gin.IRoutes, service Service) {
 	res := resource{service}
 
 	r.GET("/duples/:u1/:u2", res.IsDuple)
 }
 
+// HoldHandler keeps the repository served by repo from being closed until the request is handled,
+// so users read in place stay valid while the response is built
+func HoldHandler(repo *SwappableRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		release := repo.Hold()
+		defer release()
+		c.Next()
+	}
+}
+
+// RegisterDeleteHandlers register users' data erasure handlers in router
+func RegisterDeleteHandlers(r gin.IRoutes, service Service) {
+	res := resource{service}
+
+	r.DELETE("/users/:id", res.DeleteUser)
+}
+
 type resource struct {
 	service Service
 }
@@ -25,9 +44,36 @@ func (r resource) IsDuple(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User id param should be integer"})
 		return
 	}
-	res, err := r.service.IsDuple(c, UserID(u1), UserID(u2))
+	// the check is stopped once the client is gone
+	res, err := r.service.IsDuple(c.Request.Context(), UserID(u1), UserID(u2))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
 	}
 	c.JSON(http.StatusOK, gin.H{"dupes": res})
 }
+
+// DeleteUser erases user's data. ref query param is the erasure request reference recorded in the audit
+func (r resource) DeleteUser(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User id param should be positive integer"})
+		return
+	}
+	ref := c.Query("ref")
+	if ref == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ref param is required"})
+		return
+	}
+	deleted, err := r.service.DeleteUser(c, UserID(id), ref)
+	if err == bolt.ErrDatabaseReadOnly {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "served dataset is read-only"})
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] failed to delete user: %+v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
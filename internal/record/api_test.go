@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/boltdb/bolt"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -13,7 +14,7 @@ import (
 func TestSuccIsDupleTrue(t *testing.T) {
 	router, ms := setupRouter()
 
-	ms.On("IsDuple", mock.AnythingOfType("*gin.Context"), UserID(1), UserID(1)).Return(true, nil)
+	ms.On("IsDuple", mock.Anything, UserID(1), UserID(1)).Return(true, nil)
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/duples/1/1", nil)
 	router.ServeHTTP(w, req)
@@ -24,7 +25,7 @@ func TestSuccIsDupleTrue(t *testing.T) {
 func TestSuccIsDupleFalse(t *testing.T) {
 	router, ms := setupRouter()
 
-	ms.On("IsDuple", mock.AnythingOfType("*gin.Context"), UserID(1), UserID(2)).Return(false, nil)
+	ms.On("IsDuple", mock.Anything, UserID(1), UserID(2)).Return(false, nil)
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/duples/1/2", nil)
 	router.ServeHTTP(w, req)
@@ -46,9 +47,35 @@ func TestFailIsDuple(t *testing.T) {
 	assert.Equal(t, 400, w.Code)
 }
 
+func TestDeleteUser(t *testing.T) {
+	router, ms := setupRouter()
+
+	ms.On("DeleteUser", mock.AnythingOfType("*gin.Context"), UserID(1), "ticket-1").Return(true, nil)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/users/1?ref=ticket-1", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"deleted": true}`, w.Body.String())
+	ms.AssertExpectations(t)
+
+	for _, url := range []string{"/users/1", "/users/0?ref=ticket-1", "/users/asdf?ref=ticket-1"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("DELETE", url, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code, url)
+	}
+
+	ms.On("DeleteUser", mock.AnythingOfType("*gin.Context"), UserID(2), "ticket-2").Return(false, bolt.ErrDatabaseReadOnly)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/users/2?ref=ticket-2", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 503, w.Code)
+}
+
 func setupRouter() (*gin.Engine, *MockedService) {
 	r := gin.Default()
 	ms := new(MockedService)
 	RegisterHandlers(r, ms)
+	RegisterDeleteHandlers(r, ms)
 	return r, ms
 }
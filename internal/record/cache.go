@@ -0,0 +1,232 @@
+package record
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Approximate memory taken by a cached user: the list element, the map entry and UserInfo,
+// and by every IP: its 4 bytes and 4 bytes of LastSeen
+const (
+	cacheEntryBytes = 160
+	cacheIPBytes    = 8
+)
+
+// CacheStats describes cache usage, counters are accumulated since the cache is created
+type CacheStats struct {
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	MaxBytes  int64  `json:"max_bytes"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Negative  uint64 `json:"negative_hits"`
+	Evictions uint64 `json:"evictions"`
+	Expired   uint64 `json:"expired"`
+}
+
+// CachedRepository is a read-through LRU cache of GetUserInfo over the repository. The cache is bounded
+// by approximate memory taken by cached users. Unknown users are cached too. Users written through
+// the cache are invalidated, writes made by other processes are seen after ttl
+type CachedRepository struct {
+	Repository
+	maxBytes int64
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[UserID]*list.Element
+	// gen is changed on every invalidation, a user read before it isn't cached since it may be stale
+	gen   uint64
+	stats CacheStats
+}
+
+type cacheEntry struct {
+	userID   UserID
+	info     *UserInfo
+	size     int64
+	cachedAt time.Time
+}
+
+// NewCachedRepository returns repo cached within maxBytes, zero ttl keeps users until they're evicted
+func NewCachedRepository(repo Repository, maxBytes int64, ttl time.Duration) *CachedRepository {
+	return &CachedRepository{
+		Repository: repo,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		now:        time.Now,
+		lru:        list.New(),
+		items:      make(map[UserID]*list.Element),
+		stats:      CacheStats{MaxBytes: maxBytes},
+	}
+}
+
+// GetUserInfo returns the cached UserInfo or reads it from the repository.
+// Returned UserInfo is shared by callers, it must not be modified
+func (c *CachedRepository) GetUserInfo(ctx context.Context, userID UserID) (*UserInfo, error) {
+	infos, err := c.GetUserInfos(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return infos[0], nil
+}
+
+// GetUserInfos returns cached UserInfos, users which aren't cached are read from the repository
+// in a single batch. Returned UserInfos are shared by callers, they must not be modified
+func (c *CachedRepository) GetUserInfos(ctx context.Context, userIDs ...UserID) ([]*UserInfo, error) {
+	infos := make([]*UserInfo, len(userIDs))
+	var missed []UserID
+	var missedAt []int
+	c.mu.Lock()
+	for i, uID := range userIDs {
+		if infos[i] = c.get(uID); infos[i] == nil {
+			missed = append(missed, uID)
+			missedAt = append(missedAt, i)
+		}
+	}
+	gen := c.gen
+	c.mu.Unlock()
+	if len(missed) == 0 {
+		return infos, nil
+	}
+
+	read, err := c.Repository.GetUserInfos(ctx, missed...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for j, i := range missedAt {
+		infos[i] = read[j]
+		if gen == c.gen {
+			c.add(missed[j], read[j])
+		}
+	}
+	return infos, nil
+}
+
+// AddRecord adds the record to the repository and invalidates its user
+func (c *CachedRepository) AddRecord(ctx context.Context, record *Record) error {
+	defer c.Invalidate(record.UserID)
+	return c.Repository.AddRecord(ctx, record)
+}
+
+// BulkAddRecords adds records to the repository and invalidates their users
+func (c *CachedRepository) BulkAddRecords(ctx context.Context, records []*Record) error {
+	ids := make([]UserID, len(records))
+	for i, r := range records {
+		ids[i] = r.UserID
+	}
+	defer c.Invalidate(ids...)
+	return c.Repository.BulkAddRecords(ctx, records)
+}
+
+// MergeUserIPs merges users' IPs into the repository and invalidates the users
+func (c *CachedRepository) MergeUserIPs(ctx context.Context, users UserIPs) error {
+	ids := make([]UserID, 0, len(users))
+	for uID := range users {
+		ids = append(ids, uID)
+	}
+	defer c.Invalidate(ids...)
+	return c.Repository.MergeUserIPs(ctx, users)
+}
+
+// DeleteUser deletes the user from the repository and invalidates it
+func (c *CachedRepository) DeleteUser(ctx context.Context, userID UserID, ref string) (bool, error) {
+	defer c.Invalidate(userID)
+	return c.Repository.DeleteUser(ctx, userID, ref)
+}
+
+// DeleteUsers deletes users from the repository and invalidates them
+func (c *CachedRepository) DeleteUsers(ctx context.Context, userIDs []UserID, ref string) (int, error) {
+	defer c.Invalidate(userIDs...)
+	return c.Repository.DeleteUsers(ctx, userIDs, ref)
+}
+
+// Purge purges expired IPs of the repository and resets the cache
+func (c *CachedRepository) Purge(ctx context.Context, olderThan time.Time) (*Purged, error) {
+	defer c.Reset()
+	return c.Repository.Purge(ctx, olderThan)
+}
+
+// Clean cleans the repository and resets the cache
+func (c *CachedRepository) Clean(ctx context.Context) error {
+	defer c.Reset()
+	return c.Repository.Clean(ctx)
+}
+
+// Invalidate removes users from the cache. Users read concurrently aren't cached,
+// so the cache isn't filled with values read before a write
+func (c *CachedRepository) Invalidate(userIDs ...UserID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for _, uID := range userIDs {
+		if el, ok := c.items[uID]; ok {
+			c.remove(el)
+		}
+	}
+}
+
+// Reset removes all users from the cache, it should be called when the repository is swapped
+func (c *CachedRepository) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.lru.Init()
+	c.items = make(map[UserID]*list.Element)
+	c.stats.Entries, c.stats.Bytes = 0, 0
+}
+
+// Stats returns cache usage
+func (c *CachedRepository) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// get returns the cached UserInfo or nil if the user isn't cached or it's expired
+func (c *CachedRepository) get(userID UserID) *UserInfo {
+	if el, ok := c.items[userID]; ok {
+		e := el.Value.(*cacheEntry)
+		if c.ttl == 0 || c.now().Sub(e.cachedAt) < c.ttl {
+			c.lru.MoveToFront(el)
+			c.stats.Hits++
+			if len(e.info.IPs) == 0 {
+				c.stats.Negative++
+			}
+			return e.info
+		}
+		c.remove(el)
+		c.stats.Expired++
+	}
+	c.stats.Misses++
+	return nil
+}
+
+func (c *CachedRepository) add(userID UserID, info *UserInfo) {
+	if el, ok := c.items[userID]; ok {
+		c.remove(el)
+	}
+	e := &cacheEntry{userID: userID, info: info, size: cacheEntryBytes + int64(len(info.IPs))*cacheIPBytes, cachedAt: c.now()}
+	if e.size > c.maxBytes {
+		return
+	}
+	c.items[userID] = c.lru.PushFront(e)
+	c.stats.Entries++
+	c.stats.Bytes += e.size
+	for c.stats.Bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *CachedRepository) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.items, e.userID)
+	c.stats.Entries--
+	c.stats.Bytes -= e.size
+}
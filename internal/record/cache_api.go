@@ -0,0 +1,14 @@
+package record
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterCacheHandlers register users cache monitoring handlers in router
+func RegisterCacheHandlers(r gin.IRoutes, cache *CachedRepository) {
+	r.GET("/admin/cache", func(c *gin.Context) {
+		c.JSON(http.StatusOK, cache.Stats())
+	})
+}
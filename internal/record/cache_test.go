@@ -0,0 +1,187 @@
+package record
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingRepository counts batch reads of the repository
+type countingRepository struct {
+	Repository
+	gets int64
+}
+
+func (r *countingRepository) GetUserInfos(ctx context.Context, userIDs ...UserID) ([]*UserInfo, error) {
+	atomic.AddInt64(&r.gets, 1)
+	return r.Repository.GetUserInfos(ctx, userIDs...)
+}
+
+func TestCachedRepository(t *testing.T) {
+	repo, _, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+	counting := &countingRepository{Repository: repo}
+	c := NewCachedRepository(counting, 1<<20, 0)
+
+	require.NoError(t, c.AddRecord(ctx, NewRecord(1, "1.1.1.1")))
+	for i := 0; i < 3; i++ {
+		info, err := c.GetUserInfo(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, NewIPSet(net.ParseIP("1.1.1.1")), info.IPs)
+		// unknown user is cached too
+		info, err = c.GetUserInfo(ctx, 2)
+		require.NoError(t, err)
+		assert.Empty(t, info.IPs)
+	}
+	assert.Equal(t, int64(2), counting.gets)
+	stats := c.Stats()
+	assert.Equal(t, uint64(4), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Negative)
+	assert.Equal(t, uint64(2), stats.Misses)
+	assert.Equal(t, 2, stats.Entries)
+	assert.Equal(t, int64(2*cacheEntryBytes+cacheIPBytes), stats.Bytes)
+
+	// writes invalidate users
+	require.NoError(t, c.BulkAddRecords(ctx, []*Record{NewRecord(1, "2.2.2.2"), NewRecord(2, "2.2.2.2")}))
+	info, err := c.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Len(t, info.IPs, 2)
+	info, err = c.GetUserInfo(ctx, 2)
+	require.NoError(t, err)
+	assert.Len(t, info.IPs, 1)
+	_, err = c.DeleteUser(ctx, 2, "ticket-1")
+	require.NoError(t, err)
+	info, err = c.GetUserInfo(ctx, 2)
+	require.NoError(t, err)
+	assert.Empty(t, info.IPs)
+	assert.Equal(t, int64(5), counting.gets)
+
+	c.Reset()
+	_, err = c.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), counting.gets)
+}
+
+func TestCachedRepository_GetUserInfos(t *testing.T) {
+	repo, _, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+	require.NoError(t, repo.BulkAddRecords(ctx, []*Record{NewRecord(1, "1.1.1.1"), NewRecord(2, "2.2.2.2")}))
+	counting := &countingRepository{Repository: repo}
+	c := NewCachedRepository(counting, 1<<20, 0)
+
+	_, err := c.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	// only users which aren't cached are read, in one batch
+	infos, err := c.GetUserInfos(ctx, 2, 1, 3)
+	require.NoError(t, err)
+	assert.Equal(t, NewIPSet(net.ParseIP("2.2.2.2")), infos[0].IPs)
+	assert.Equal(t, NewIPSet(net.ParseIP("1.1.1.1")), infos[1].IPs)
+	assert.Empty(t, infos[2].IPs)
+	assert.Equal(t, int64(2), counting.gets)
+
+	infos, err = c.GetUserInfos(ctx, 1, 2, 3)
+	require.NoError(t, err)
+	assert.Len(t, infos, 3)
+	assert.Equal(t, int64(2), counting.gets)
+	assert.Equal(t, uint64(4), c.Stats().Hits)
+}
+
+func TestCachedRepository_TTL(t *testing.T) {
+	repo, _, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+	counting := &countingRepository{Repository: repo}
+	c := NewCachedRepository(counting, 1<<20, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	_, err := c.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	now = now.Add(59 * time.Second)
+	_, err = c.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), counting.gets)
+
+	now = now.Add(time.Second)
+	_, err = c.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), counting.gets)
+	assert.Equal(t, uint64(1), c.Stats().Expired)
+}
+
+func TestCachedRepository_Evict(t *testing.T) {
+	repo, _, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+	var big []*Record
+	for i := 1; i <= 21; i++ {
+		big = append(big, NewRecord(3, net.IPv4(byte(i), 1, 1, 1).String()))
+	}
+	require.NoError(t, repo.BulkAddRecords(ctx, big))
+	counting := &countingRepository{Repository: repo}
+	c := NewCachedRepository(counting, 2*cacheEntryBytes, 0)
+
+	for _, uID := range []UserID{1, 2, 1, 4} {
+		_, err := c.GetUserInfo(ctx, uID)
+		require.NoError(t, err)
+	}
+	// 2 is the least recently used one
+	stats := c.Stats()
+	assert.Equal(t, uint64(1), stats.Evictions)
+	assert.Equal(t, 2, stats.Entries)
+	assert.Equal(t, int64(2*cacheEntryBytes), stats.Bytes)
+	_, err := c.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), counting.gets)
+	_, err = c.GetUserInfo(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), counting.gets)
+
+	// a user larger than the cache isn't cached
+	_, err = c.GetUserInfo(ctx, 3)
+	require.NoError(t, err)
+	_, err = c.GetUserInfo(ctx, 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), counting.gets)
+}
+
+func TestCachedRepository_Concurrent(t *testing.T) {
+	repo, _, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+	c := NewCachedRepository(repo, 1<<20, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := c.GetUserInfo(ctx, UserID(j%10+1))
+				assert.NoError(t, err)
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				assert.NoError(t, c.AddRecord(ctx, NewRecord(UserID(j+1), net.IPv4(1, 1, 1, byte(i)).String())))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// values read before writes aren't left cached
+	for j := 0; j < 10; j++ {
+		info, err := c.GetUserInfo(ctx, UserID(j+1))
+		require.NoError(t, err)
+		assert.Len(t, info.IPs, 4)
+	}
+}
@@ -0,0 +1,143 @@
+package record
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Errors of committing records
+var (
+	ErrQueueFull = errors.New("write queue is full")
+	ErrClosed    = errors.New("committer is closed")
+)
+
+// Committer accepts records to be written
+type Committer interface {
+	Commit(ctx context.Context, records []*Record) error
+}
+
+type commitRequest struct {
+	records []*Record
+	hooks   []TxHook
+	done    chan error
+}
+
+// GroupCommitter writes records of concurrent requests together. While a transaction is in progress
+// new requests pile up in the queue and all of them share the next one. Requests are rejected
+// with ErrQueueFull instead of waiting when the queue is full
+type GroupCommitter struct {
+	service  Service
+	queue    chan *commitRequest
+	maxBatch int
+
+	// mu orders enqueueing against closing, so nothing is enqueued once the queue is drained
+	mu      sync.Mutex
+	closed  bool
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewGroupCommitter returns started GroupCommitter. queueSize bounds waiting requests,
+// maxBatch bounds records written in a single transaction
+func NewGroupCommitter(service Service, queueSize, maxBatch int) *GroupCommitter {
+	g := &GroupCommitter{
+		service:  service,
+		queue:    make(chan *commitRequest, queueSize),
+		maxBatch: maxBatch,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go g.run()
+	return g
+}
+
+// Commit enqueues records and waits until they are written. ErrClosed is returned once Close is called.
+// Hooks of ctx are called within the transaction writing the records, see WithTxHook. They're called
+// even if ctx is done while the records wait in the queue
+func (g *GroupCommitter) Commit(ctx context.Context, records []*Record) error {
+	req := &commitRequest{records: records, hooks: txHooks(ctx), done: make(chan error, 1)}
+	if err := g.enqueue(req); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (g *GroupCommitter) enqueue(req *commitRequest) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return ErrClosed
+	}
+	select {
+	case g.queue <- req:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close writes everything queued and stops the committer
+func (g *GroupCommitter) Close() {
+	g.mu.Lock()
+	if !g.closed {
+		g.closed = true
+		close(g.stop)
+	}
+	g.mu.Unlock()
+	<-g.stopped
+}
+
+func (g *GroupCommitter) run() {
+	defer close(g.stopped)
+	for {
+		select {
+		case req := <-g.queue:
+			g.commit(req)
+		case <-g.stop:
+			for {
+				select {
+				case req := <-g.queue:
+					g.commit(req)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// commit writes the request together with everything waiting in the queue up to maxBatch records.
+// Hooks of all requests share the transaction, so an error of any of them fails the whole group
+func (g *GroupCommitter) commit(first *commitRequest) {
+	group := []*commitRequest{first}
+	records := first.records
+collect:
+	for len(records) < g.maxBatch {
+		select {
+		case req := <-g.queue:
+			group = append(group, req)
+			records = append(records[:len(records):len(records)], req.records...)
+		default:
+			break collect
+		}
+	}
+
+	ctx := context.Background()
+	for _, req := range group {
+		for _, hook := range req.hooks {
+			ctx = WithTxHook(ctx, hook)
+		}
+	}
+	err := g.service.BulkAddRecords(ctx, records)
+	for _, req := range group {
+		req.done <- err
+	}
+}
@@ -0,0 +1,163 @@
+package record
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupCommitter(t *testing.T) {
+	ms := new(MockedService)
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var batches []int
+	ms.On("BulkAddRecords", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		started <- struct{}{}
+		<-release
+		mu.Lock()
+		batches = append(batches, len(args.Get(1).([]*Record)))
+		mu.Unlock()
+	}).Return(nil)
+
+	g := NewGroupCommitter(ms, 10, 100)
+	defer g.Close()
+
+	// the first request blocks the writer, the others pile up and share the next transaction
+	wg := sync.WaitGroup{}
+	commit := func() {
+		defer wg.Done()
+		assert.NoError(t, g.Commit(context.Background(), []*Record{NewRecord(1, "1.1.1.1")}))
+	}
+	wg.Add(1)
+	go commit()
+	<-started
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go commit()
+	}
+	require.Eventually(t, func() bool { return len(g.queue) == 5 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, []int{1, 5}, batches)
+}
+
+func TestGroupCommitter_QueueFull(t *testing.T) {
+	ms := new(MockedService)
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	ms.On("BulkAddRecords", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		started <- struct{}{}
+		<-release
+	}).Return(errors.New("failed"))
+
+	g := NewGroupCommitter(ms, 1, 100)
+	results := make(chan error, 2)
+	go func() { results <- g.Commit(context.Background(), []*Record{NewRecord(1, "1.1.1.1")}) }()
+	<-started
+	go func() { results <- g.Commit(context.Background(), []*Record{NewRecord(1, "1.1.1.1")}) }()
+	require.Eventually(t, func() bool { return len(g.queue) == 1 }, time.Second, time.Millisecond)
+
+	assert.Equal(t, ErrQueueFull, g.Commit(context.Background(), []*Record{NewRecord(1, "1.1.1.1")}))
+
+	close(release)
+	assert.EqualError(t, <-results, "failed")
+	assert.EqualError(t, <-results, "failed")
+	g.Close()
+	assert.Equal(t, ErrClosed, g.Commit(context.Background(), nil))
+}
+
+func TestGroupCommitter_ConcurrentClose(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		ms := new(MockedService)
+		var mu sync.Mutex
+		var written int
+		ms.On("BulkAddRecords", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			mu.Lock()
+			written += len(args.Get(1).([]*Record))
+			mu.Unlock()
+		}).Return(nil)
+
+		g := NewGroupCommitter(ms, 100, 10)
+		results := make(chan error, 20)
+		for j := 0; j < cap(results); j++ {
+			go func() { results <- g.Commit(context.Background(), []*Record{NewRecord(1, "1.1.1.1")}) }()
+		}
+		go g.Close()
+		g.Close()
+
+		// every request is either written or rejected, none of them is left waiting
+		var committed int
+		for j := 0; j < cap(results); j++ {
+			select {
+			case err := <-results:
+				if err == nil {
+					committed++
+				} else {
+					require.Equal(t, ErrClosed, err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("commit is never written")
+			}
+		}
+		assert.Equal(t, committed, written)
+	}
+}
+
+func TestGroupCommitter_MaxBatch(t *testing.T) {
+	ms := new(MockedService)
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	var batches []int
+	ms.On("BulkAddRecords", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		started <- struct{}{}
+		<-release
+		batches = append(batches, len(args.Get(1).([]*Record)))
+	}).Return(nil)
+
+	g := NewGroupCommitter(ms, 10, 3)
+	wg := sync.WaitGroup{}
+	commit := func(n int) {
+		defer wg.Done()
+		records := make([]*Record, n)
+		for i := range records {
+			records[i] = NewRecord(1, "1.1.1.1")
+		}
+		assert.NoError(t, g.Commit(context.Background(), records))
+	}
+	wg.Add(1)
+	go commit(1)
+	<-started
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go commit(2)
+	}
+	require.Eventually(t, func() bool { return len(g.queue) == 4 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	g.Close()
+
+	assert.Equal(t, []int{1, 4, 4}, batches)
+}
+
+func TestGroupCommitter_TxHooks(t *testing.T) {
+	ms := new(MockedService)
+	var hooks int
+	ms.On("BulkAddRecords", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		hooks = len(txHooks(args.Get(0).(context.Context)))
+	}).Return(nil)
+
+	g := NewGroupCommitter(ms, 10, 100)
+	ctx := WithTxHook(context.Background(), func(tx *bolt.Tx) error { return nil })
+	require.NoError(t, g.Commit(WithTxHook(ctx, func(tx *bolt.Tx) error { return nil }), nil))
+	g.Close()
+	assert.Equal(t, 2, hooks, "hooks of the request are called by the group write")
+}
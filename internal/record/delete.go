@@ -0,0 +1,107 @@
+package record
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/internal/fsck"
+	"github.com/pkg/errors"
+)
+
+const auditBucketName = "AUDIT"
+
+// AuditDeleteUsers is the action of users deletion audit entries
+const AuditDeleteUsers = "delete_users"
+
+// AuditEntry records an erasure of users' data. It keeps no personal data, so deleted users' ids
+// aren't recorded, Ref should be a reference to the erasure request
+type AuditEntry struct {
+	ID        uint64    `json:"id"`
+	Action    string    `json:"action"`
+	Tenant    Tenant    `json:"tenant,omitempty"`
+	Ref       string    `json:"ref"`
+	Requested int       `json:"requested"`
+	Deleted   int       `json:"deleted"`
+	At        time.Time `json:"at"`
+}
+
+// DeleteUser deletes user's info and records an audit entry with ref in a single transaction.
+// It returns false if there was nothing to delete
+func (b *boltRepository) DeleteUser(ctx context.Context, userID UserID, ref string) (bool, error) {
+	n, err := b.DeleteUsers(ctx, []UserID{userID}, ref)
+	return n > 0, err
+}
+
+// DeleteUsers deletes users' info and records a single audit entry with ref in a single transaction.
+// It returns number of deleted users
+func (b *boltRepository) DeleteUsers(ctx context.Context, userIDs []UserID, ref string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var deleted int
+	err := b.DB.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(b.BKT))
+		for _, uID := range userIDs {
+			key := getKey(uID)
+			if bkt.Get(key) == nil {
+				continue
+			}
+			if err := bkt.Delete(key); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return writeAudit(tx, &AuditEntry{Action: AuditDeleteUsers, Tenant: b.tenant, Ref: ref, Requested: len(userIDs), Deleted: deleted})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// Audit returns all audit entries in the order they're recorded
+func Audit(db *bolt.DB) ([]*AuditEntry, error) {
+	var entries []*AuditEntry
+	err := db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(auditBucketName))
+		if bkt == nil {
+			return nil
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			e := &AuditEntry{}
+			if err := json.Unmarshal(v, e); err != nil {
+				return errors.Wrapf(err, "failed to decode audit entry %d", binary.BigEndian.Uint64(k))
+			}
+			entries = append(entries, e)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// AuditCheck returns the check of audit bucket, undecodable entries are removed on repair
+func AuditCheck() fsck.Check {
+	return fsck.JSONCheck(auditBucketName, func() interface{} { return &AuditEntry{} })
+}
+
+// writeAudit stores the entry under the next sequence number of audit bucket
+func writeAudit(tx *bolt.Tx, e *AuditEntry) error {
+	bkt, err := tx.CreateBucketIfNotExists([]byte(auditBucketName))
+	if err != nil {
+		return errors.Wrapf(err, "failed to create bucket %s", auditBucketName)
+	}
+	if e.ID, err = bkt.NextSequence(); err != nil {
+		return err
+	}
+	e.At = time.Now().UTC()
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, e.ID)
+	return bkt.Put(key, buf)
+}
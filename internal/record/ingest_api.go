@@ -0,0 +1,161 @@
+package record
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/mullakhmetov/duplicates-checker/internal/idempotency"
+	"github.com/pkg/errors"
+)
+
+// limits of request bodies, a single record takes a few dozens of bytes
+const (
+	maxRecordBodySize = 4 << 10
+	maxBulkBodySize   = 32 << 20
+)
+
+// Ingestion idempotency headers. A request with a key seen already isn't applied again,
+// the original response is returned with the replayed header set
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+)
+
+// RegisterIngestHandlers register records ingestion handlers in router
+func RegisterIngestHandlers(r gin.IRoutes, committer Committer, keys *idempotency.Store) {
+	res := ingestResource{committer, keys}
+
+	r.POST("/records", res.AddRecord)
+	r.POST("/records/bulk", res.BulkAddRecords)
+}
+
+// RegisterReadOnlyIngestHandlers register records ingestion handlers rejecting every request,
+// they are used when the store is opened read-only
+func RegisterReadOnlyIngestHandlers(r gin.IRoutes) {
+	reject := func(c *gin.Context) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "server is read-only"})
+	}
+
+	r.POST("/records", reject)
+	r.POST("/records/bulk", reject)
+	r.DELETE("/users/:id", reject)
+}
+
+type ingestResource struct {
+	committer Committer
+	keys      *idempotency.Store
+}
+
+type recordRequest struct {
+	UserID UserID `json:"user_id"`
+	IP     string `json:"ip"`
+}
+
+func (r recordRequest) record() (*Record, error) {
+	return ParseRecord(r.UserID, r.IP)
+}
+
+func (r ingestResource) AddRecord(c *gin.Context) {
+	var req recordRequest
+	if err := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, maxRecordBodySize)).Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json: " + err.Error()})
+		return
+	}
+	record, err := req.record()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	r.commit(c, []*Record{record})
+}
+
+// BulkAddRecords accepts JSON array of records or newline delimited JSON records
+func (r ingestResource) BulkAddRecords(c *gin.Context) {
+	reqs, err := decodeBulk(http.MaxBytesReader(c.Writer, c.Request.Body, maxBulkBodySize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json: " + err.Error()})
+		return
+	}
+	records := make([]*Record, len(reqs))
+	for i, req := range reqs {
+		if records[i], err = req.record(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "index": i})
+			return
+		}
+	}
+	r.commit(c, records)
+}
+
+func (r ingestResource) commit(c *gin.Context, records []*Record) {
+	res, replayed, err := r.keys.Do(c, c.GetHeader(idempotencyKeyHeader), func(remember idempotency.Remember) (*idempotency.Result, error) {
+		body, err := json.Marshal(gin.H{"added": len(records)})
+		if err != nil {
+			return nil, err
+		}
+		res := &idempotency.Result{Status: http.StatusOK, Body: body}
+		if len(records) > 0 {
+			// the key is remembered in the transaction writing the records
+			ctx := WithTxHook(c, func(tx *bolt.Tx) error { return remember(tx, res) })
+			if err := r.committer.Commit(ctx, records); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	})
+	switch err {
+	case nil:
+	case ErrQueueFull:
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	case idempotency.ErrInProgress:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case bolt.ErrDatabaseReadOnly:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "served dataset is read-only"})
+		return
+	case idempotency.ErrInvalidKey:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	default:
+		log.Printf("[ERROR] failed to add records: %+v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	if replayed {
+		c.Header(idempotencyReplayedHeader, "true")
+	}
+	c.Data(res.Status, "application/json; charset=utf-8", res.Body)
+}
+
+func decodeBulk(body io.Reader) ([]recordRequest, error) {
+	br := bufio.NewReader(body)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, errors.Wrap(err, "empty body")
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		br.ReadByte()
+	}
+
+	dec := json.NewDecoder(br)
+	var reqs []recordRequest
+	if b, _ := br.Peek(1); b[0] == '[' {
+		return reqs, dec.Decode(&reqs)
+	}
+	for dec.More() {
+		var req recordRequest
+		if err := dec.Decode(&req); err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
@@ -0,0 +1,161 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/mullakhmetov/duplicates-checker/internal/idempotency"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCommitter struct {
+	records []*Record
+	err     error
+}
+
+func (f *fakeCommitter) Commit(ctx context.Context, records []*Record) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.records = append(f.records, records...)
+	return nil
+}
+
+type memKeys map[string]*idempotency.Result
+
+func (m memKeys) Get(ctx context.Context, key string) (*idempotency.Result, error) {
+	if res, ok := m[key]; ok {
+		return res, nil
+	}
+	return nil, idempotency.ErrNotFound
+}
+
+func (m memKeys) Save(ctx context.Context, res *idempotency.Result) error {
+	m[res.Key] = res
+	return nil
+}
+
+func (m memKeys) SaveTx(tx *bolt.Tx, res *idempotency.Result) error {
+	return m.Save(context.Background(), res)
+}
+
+func TestAddRecord(t *testing.T) {
+	router, fc := setupIngestRouter()
+
+	w := post(router, "/records", `{"user_id": 1, "ip": "1.1.1.1"}`)
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"added": 1}`, w.Body.String())
+	assert.Equal(t, []*Record{NewRecord(1, "1.1.1.1")}, fc.records)
+
+	large := `{"user_id": 1, "ip": "1.1.1.1", "pad": "` + strings.Repeat("x", maxRecordBodySize) + `"}`
+	for _, body := range []string{`{"user_id": 1, "ip": "1.1.1"}`, `{"user_id": 0, "ip": "1.1.1.1"}`, `{"user_id": -1}`, `nope`, large} {
+		w = post(router, "/records", body)
+		assert.Equal(t, 400, w.Code, body)
+	}
+	assert.Len(t, fc.records, 1)
+}
+
+func TestBulkAddRecords(t *testing.T) {
+	router, fc := setupIngestRouter()
+
+	w := post(router, "/records/bulk", ` [{"user_id": 1, "ip": "1.1.1.1"}, {"user_id": 2, "ip": "2.2.2.2"}]`)
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"added": 2}`, w.Body.String())
+
+	w = post(router, "/records/bulk", "{\"user_id\": 3, \"ip\": \"3.3.3.3\"}\n{\"user_id\": 4, \"ip\": \"4.4.4.4\"}\n")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"added": 2}`, w.Body.String())
+
+	assert.Equal(t, []*Record{
+		NewRecord(1, "1.1.1.1"), NewRecord(2, "2.2.2.2"), NewRecord(3, "3.3.3.3"), NewRecord(4, "4.4.4.4"),
+	}, fc.records)
+
+	w = post(router, "/records/bulk", `[{"user_id": 1, "ip": "1.1.1.1"}, {"user_id": 2, "ip": "0.0.0.0"}]`)
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `"index":1`)
+
+	w = post(router, "/records/bulk", "{\"user_id\": 1, \"ip\": \"1.1.1.1\"}\n{broken")
+	assert.Equal(t, 400, w.Code)
+
+	w = post(router, "/records/bulk", "")
+	assert.Equal(t, 400, w.Code)
+	assert.Len(t, fc.records, 4)
+}
+
+func TestIngest_Errors(t *testing.T) {
+	router, fc := setupIngestRouter()
+
+	fc.err = ErrQueueFull
+	w := post(router, "/records", `{"user_id": 1, "ip": "1.1.1.1"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	fc.err = errors.New("disk is full")
+	w = post(router, "/records/bulk", `[{"user_id": 1, "ip": "1.1.1.1"}]`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestIngest_IdempotencyKey(t *testing.T) {
+	router, fc := setupIngestRouter()
+
+	body := `[{"user_id": 1, "ip": "1.1.1.1"}, {"user_id": 2, "ip": "2.2.2.2"}]`
+	w := postWithKey(router, "/records/bulk", body, "batch-1")
+	assert.Equal(t, 200, w.Code)
+	assert.Empty(t, w.Header().Get(idempotencyReplayedHeader))
+
+	w = postWithKey(router, "/records/bulk", body, "batch-1")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"added": 2}`, w.Body.String())
+	assert.Equal(t, "true", w.Header().Get(idempotencyReplayedHeader))
+	assert.Len(t, fc.records, 2)
+
+	// failed batch may be retried with the same key
+	fc.err = ErrQueueFull
+	w = postWithKey(router, "/records", `{"user_id": 3, "ip": "3.3.3.3"}`, "batch-2")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	fc.err = nil
+	w = postWithKey(router, "/records", `{"user_id": 3, "ip": "3.3.3.3"}`, "batch-2")
+	assert.Equal(t, 200, w.Code)
+	assert.Empty(t, w.Header().Get(idempotencyReplayedHeader))
+	assert.Len(t, fc.records, 3)
+
+	w = postWithKey(router, "/records", `{"user_id": 3, "ip": "3.3.3.3"}`, strings.Repeat("k", idempotency.MaxKeyLength+1))
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestIngest_ReadOnly(t *testing.T) {
+	router := gin.Default()
+	RegisterReadOnlyIngestHandlers(router)
+
+	w := post(router, "/records", `{"user_id": 1, "ip": "1.1.1.1"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = post(router, "/records/bulk", `[{"user_id": 1, "ip": "1.1.1.1"}]`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func setupIngestRouter() (*gin.Engine, *fakeCommitter) {
+	r := gin.Default()
+	fc := &fakeCommitter{}
+	RegisterIngestHandlers(r, fc, idempotency.NewStore(memKeys{}))
+	return r, fc
+}
+
+func post(router *gin.Engine, path, body string) *httptest.ResponseRecorder {
+	return postWithKey(router, path, body, "")
+}
+
+func postWithKey(router *gin.Engine, path, body, key string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,131 @@
+package record
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+)
+
+// IPSet is a set of IPv4 addresses kept as big-endian uint32 values in ascending order,
+// so sets are intersected by merging without allocations
+type IPSet []uint32
+
+// NewIPSet returns the set of IPv4 addresses, other addresses are skipped
+func NewIPSet(ips ...net.IP) IPSet {
+	s := make(IPSet, 0, len(ips))
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			s = append(s, binary.BigEndian.Uint32(ip4))
+		}
+	}
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	return s.dedup()
+}
+
+// dedup removes repeated values of the sorted set
+func (s IPSet) dedup() IPSet {
+	if len(s) < 2 {
+		return s
+	}
+	n := 1
+	for _, v := range s[1:] {
+		if v != s[n-1] {
+			s[n] = v
+			n++
+		}
+	}
+	return s[:n]
+}
+
+// IP returns i-th IP of the set
+func (s IPSet) IP(i int) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, s[i])
+	return ip
+}
+
+// Strings returns IPs of the set in ascending order
+func (s IPSet) Strings() []string {
+	res := make([]string, len(s))
+	for i := range s {
+		res[i] = s.IP(i).String()
+	}
+	return res
+}
+
+// Contains returns true if the IP is in the set
+func (s IPSet) Contains(ip net.IP) bool {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return false
+	}
+	v := binary.BigEndian.Uint32(ip4)
+	i := sort.Search(len(s), func(i int) bool { return s[i] >= v })
+	return i < len(s) && s[i] == v
+}
+
+// HasNCommons returns true if sets have at least n common IPs. It stops as soon as n common IPs are found
+// or there aren't enough IPs left to find them
+func (s IPSet) HasNCommons(o IPSet, n int) bool {
+	if n <= 0 {
+		return true
+	}
+	var found int
+	intersect(s, o, func(v uint32) bool {
+		found++
+		return found < n
+	}, n)
+	return found >= n
+}
+
+// Intersect returns IPs of both sets
+func (s IPSet) Intersect(o IPSet) IPSet {
+	var res IPSet
+	intersect(s, o, func(v uint32) bool {
+		res = append(res, v)
+		return true
+	}, 0)
+	return res
+}
+
+// intersect calls fn with common values of sorted sets in ascending order while fn returns true.
+// It stops early once the values left can't make up the needed number of common ones
+func intersect(a, b IPSet, fn func(v uint32) bool, need int) {
+	// values of the smaller set are galloped through the larger one, O(m log(n/m)) for sets of m and n values
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	var j int
+	for i, v := range a {
+		if len(a)-i < need {
+			return
+		}
+		j = gallop(b, j, v)
+		if j == len(b) {
+			return
+		}
+		if b[j] == v {
+			need--
+			if !fn(v) {
+				return
+			}
+			j++
+		}
+	}
+}
+
+// gallop returns the index of the first value of s not less than v, starting from `from`.
+// The range is found by doubling steps and then binary searched
+func gallop(s IPSet, from int, v uint32) int {
+	step := 1
+	hi := from
+	for hi < len(s) && s[hi] < v {
+		from = hi + 1
+		hi += step
+		step <<= 1
+	}
+	if hi > len(s) {
+		hi = len(s)
+	}
+	return from + sort.Search(hi-from, func(i int) bool { return s[from+i] >= v })
+}
@@ -0,0 +1,189 @@
+package record
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type ipSetTestCase struct {
+	a   []net.IP
+	b   []net.IP
+	n   int
+	res bool
+}
+
+func TestIPSet_HasNCommons(t *testing.T) {
+	cases := []ipSetTestCase{
+		ipSetTestCase{[]net.IP{}, []net.IP{}, 1, false},
+		ipSetTestCase{[]net.IP{}, []net.IP{ip1}, 1, false},
+		ipSetTestCase{[]net.IP{ip1}, []net.IP{ip1}, 1, true},
+		ipSetTestCase{[]net.IP{ip1}, []net.IP{ip2}, 1, false},
+		ipSetTestCase{[]net.IP{ip1, ip2}, []net.IP{ip3, ip5}, 1, false},
+
+		ipSetTestCase{[]net.IP{ip1}, []net.IP{ip1}, 2, false},
+		ipSetTestCase{[]net.IP{ip1, ip1}, []net.IP{ip1, ip1}, 2, false},
+		ipSetTestCase{[]net.IP{ip1}, []net.IP{ip2, ip3, ip4, ip5}, 2, false},
+		ipSetTestCase{[]net.IP{ip1, ip2}, []net.IP{ip2, ip3}, 2, false},
+		ipSetTestCase{[]net.IP{ip1, ip2, ip3, ip4}, []net.IP{ip4, ip5, ip6, ip7}, 2, false},
+		ipSetTestCase{[]net.IP{ip1, ip2, ip3, ip4}, []net.IP{ip3, ip4, ip5, ip6, ip7}, 2, true},
+		ipSetTestCase{[]net.IP{ip3, ip2, ip1}, []net.IP{ip7, ip6, ip5, ip4, ip3, ip2}, 2, true},
+		ipSetTestCase{[]net.IP{ip1, ip2, ip3}, []net.IP{ip2, ip3, ip4, ip5, ip6, ip7}, 3, false},
+	}
+	for _, c := range cases {
+		a, b := NewIPSet(c.a...), NewIPSet(c.b...)
+		assert.Equal(t, c.res, a.HasNCommons(b, c.n), fmt.Sprintf("a: %v, b: %v, n: %d", c.a, c.b, c.n))
+		assert.Equal(t, c.res, b.HasNCommons(a, c.n), fmt.Sprintf("a: %v, b: %v, n: %d", c.b, c.a, c.n))
+	}
+}
+
+func TestIPSet(t *testing.T) {
+	s := NewIPSet(ip3, net.ParseIP("::1"), ip1, ip3.To4())
+	assert.Equal(t, IPSet{0x01010101, 0x03030303}, s)
+	assert.Equal(t, []string{"1.1.1.1", "3.3.3.3"}, s.Strings())
+	assert.Equal(t, ip3.To4(), s.IP(1))
+	assert.True(t, s.Contains(ip1))
+	assert.False(t, s.Contains(ip2))
+	assert.False(t, s.Contains(net.ParseIP("::1")))
+	assert.Empty(t, NewIPSet())
+}
+
+func TestIPSet_Intersect(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, sizes := range [][2]int{{0, 10}, {1, 1}, {3, 1000}, {100, 100}, {1000, 10000}} {
+		// a narrow range makes many common IPs
+		a, b := randomIPSet(rnd, sizes[0], 20000), randomIPSet(rnd, sizes[1], 20000)
+		want := make(map[uint32]bool)
+		for _, v := range a {
+			want[v] = true
+		}
+		var common IPSet
+		for _, v := range b {
+			if want[v] {
+				common = append(common, v)
+			}
+		}
+
+		assert.Equal(t, common, a.Intersect(b), "sizes %v", sizes)
+		assert.Equal(t, common, b.Intersect(a), "sizes %v", sizes)
+		for _, n := range []int{1, 2, len(common), len(common) + 1} {
+			assert.Equal(t, len(common) >= n, a.HasNCommons(b, n), "sizes %v, n %d", sizes, n)
+		}
+	}
+}
+
+func TestGallop(t *testing.T) {
+	s := IPSet{1, 3, 5, 7, 9, 11, 13}
+	for v := uint32(0); v < 16; v++ {
+		for from := 0; from <= len(s); from++ {
+			want := from
+			for want < len(s) && s[want] < v {
+				want++
+			}
+			require.Equal(t, want, gallop(s, from, v), "v %d, from %d", v, from)
+		}
+	}
+}
+
+// randomIPSet returns a set of n random IPs out of the first max ones
+func randomIPSet(rnd *rand.Rand, n, max int) IPSet {
+	ips := make([]net.IP, n)
+	for i := range ips {
+		ips[i] = make(net.IP, 4)
+		binary.BigEndian.PutUint32(ips[i], uint32(rnd.Intn(max)))
+	}
+	return NewIPSet(ips...)
+}
+
+// mapHasNCommons is the map based check the sorted sets replace, it's kept to compare them in benchmarks
+func mapHasNCommons(a, b []net.IP, n int) bool {
+	temp := make(map[uint32]int)
+	for _, i := range a {
+		temp[binary.BigEndian.Uint32(i.To4())] = 1
+	}
+	for _, i := range b {
+		k := binary.BigEndian.Uint32(i.To4())
+		if v, ok := temp[k]; ok && v < 2 {
+			temp[k]++
+		}
+	}
+	var commons int
+	for _, v := range temp {
+		if v >= 2 {
+			commons++
+		}
+		if commons >= n {
+			return true
+		}
+	}
+	return false
+}
+
+var benchSizes = []int{1, 10, 10000}
+
+// benchmark users share a half of their IPs, so a check finds the common ones among others
+func benchUsers(n int) (IPSet, IPSet) {
+	a, b := make(IPSet, n), make(IPSet, n)
+	for i := range a {
+		a[i] = uint32(2 * i)
+		b[i] = uint32(2*i + i%2)
+	}
+	return a, b
+}
+
+func toIPs(s IPSet) []net.IP {
+	ips := make([]net.IP, len(s))
+	for i := range s {
+		ips[i] = s.IP(i)
+	}
+	return ips
+}
+
+func BenchmarkHasNCommons(b *testing.B) {
+	for _, n := range benchSizes {
+		s1, s2 := benchUsers(n)
+		ips1, ips2 := toIPs(s1), toIPs(s2)
+		b.Run(fmt.Sprintf("map/%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				mapHasNCommons(ips1, ips2, doubleLimit)
+			}
+		})
+		b.Run(fmt.Sprintf("sorted/%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s1.HasNCommons(s2, doubleLimit)
+			}
+		})
+	}
+}
+
+func BenchmarkBoltRepository_GetUserInfo(b *testing.B) {
+	ctx := context.Background()
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			repo, _, teardown := prepBoltRepo(b)
+			defer teardown()
+			s, _ := benchUsers(n)
+			records := make([]*Record, n)
+			for i := range s {
+				records[i] = &Record{UserID: 1, IP: s.IP(i)}
+			}
+			require.NoError(b, repo.BulkAddRecords(ctx, records))
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := repo.GetUserInfo(ctx, 1); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
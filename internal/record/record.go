@@ -1,7 +1,11 @@
 package record
 
 import (
+	"encoding/binary"
 	"net"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 // UserID ...
@@ -11,10 +15,103 @@ type UserID uint32
 type Record struct {
 	UserID UserID
 	IP     net.IP
+	// Seen is the time of the access if the source has it, zero time means the access is seen when it's written
+	Seen time.Time
+}
+
+// Record validation errors
+var (
+	ErrZeroUserID = errors.New("zero user id")
+	ErrInvalidIP  = errors.New("invalid ip")
+	ErrReservedIP = errors.New("reserved ip")
+)
+
+// reserved IPv4 ranges that never identify a client
+var reservedNets = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),   // "this" network, including unspecified address
+	mustParseCIDR("224.0.0.0/4"), // multicast
+	mustParseCIDR("240.0.0.0/4"), // reserved for future use, including broadcast
 }
 
-// NewRecord creates Record by UserID and string IP
+// NewRecord creates Record by UserID and string IP. It doesn't validate anything,
+// use ParseRecord for untrusted input
 func NewRecord(id UserID, ips string) *Record {
 	ip := net.ParseIP(ips).To4()
-	return &Record{id, ip}
+	return &Record{UserID: id, IP: ip}
+}
+
+// ParseRecord creates Record by UserID and string IP and validates it
+func ParseRecord(id UserID, ips string) (*Record, error) {
+	record := NewRecord(id, ips)
+	if record.IP == nil {
+		return nil, errors.Wrapf(ErrInvalidIP, "%q", ips)
+	}
+	if err := record.Validate(); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Validate returns an error if the record can't come from a real user's access
+func (r *Record) Validate() error {
+	if r.UserID == 0 {
+		return ErrZeroUserID
+	}
+	if r.IP.To4() == nil {
+		return errors.Wrapf(ErrInvalidIP, "%q", r.IP)
+	}
+	for _, n := range reservedNets {
+		if n.Contains(r.IP) {
+			return errors.Wrapf(ErrReservedIP, "%s is in %s", r.IP, n)
+		}
+	}
+	return nil
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// UserIPs maps users to distinct IPs seen for them, IPs are encoded as big endian uint32. Every IP maps
+// to unix time it's last seen, 0 if it's seen when it's written. It collapses any number of records into
+// one update per user
+type UserIPs map[UserID]map[uint32]int64
+
+// Add puts record's IP into user's set
+func (u UserIPs) Add(record *Record) {
+	ips, ok := u[record.UserID]
+	if !ok {
+		ips = make(map[uint32]int64, 1)
+		u[record.UserID] = ips
+	}
+	var seen int64
+	if !record.Seen.IsZero() {
+		seen = record.Seen.Unix()
+	}
+	see(ips, binary.BigEndian.Uint32(record.IP.To4()), seen)
+}
+
+// Merge moves all other's IPs into u
+func (u UserIPs) Merge(other UserIPs) {
+	for uID, ips := range other {
+		dst, ok := u[uID]
+		if !ok {
+			u[uID] = ips
+			continue
+		}
+		for ip, seen := range ips {
+			see(dst, ip, seen)
+		}
+	}
+}
+
+// see keeps the later of IP's times, 0 is the time of the write, so it's later than any other
+func see(ips map[uint32]int64, ip uint32, seen int64) {
+	if cur, ok := ips[ip]; !ok || (cur != 0 && (seen == 0 || seen > cur)) {
+		ips[ip] = seen
+	}
 }
@@ -3,7 +3,9 @@ package record
 import (
 	"net"
 	"testing"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -12,3 +14,57 @@ func TestNewRecord(t *testing.T) {
 	assert.Equal(t, UserID(1), record.UserID)
 	assert.Equal(t, net.ParseIP("0.0.0.1").To4(), record.IP)
 }
+
+func TestParseRecord(t *testing.T) {
+	record, err := ParseRecord(1, "1.1.1.1")
+	assert.NoError(t, err)
+	assert.Equal(t, UserID(1), record.UserID)
+	assert.Equal(t, net.ParseIP("1.1.1.1").To4(), record.IP)
+
+	_, err = ParseRecord(1, "127.0.0.1")
+	assert.NoError(t, err, "loopback is fine for debug datasets")
+
+	cases := []struct {
+		id  UserID
+		ip  string
+		err error
+	}{
+		{0, "1.1.1.1", ErrZeroUserID},
+		{1, "", ErrInvalidIP},
+		{1, "1.1.1", ErrInvalidIP},
+		{1, "256.1.1.1", ErrInvalidIP},
+		{1, "::1", ErrInvalidIP},
+		{1, "0.0.0.0", ErrReservedIP},
+		{1, "0.0.0.1", ErrReservedIP},
+		{1, "224.0.0.1", ErrReservedIP},
+		{1, "255.255.255.255", ErrReservedIP},
+	}
+	for _, c := range cases {
+		_, err := ParseRecord(c.id, c.ip)
+		assert.Equal(t, c.err, errors.Cause(err), c.ip)
+	}
+}
+
+func TestUserIPs(t *testing.T) {
+	u := UserIPs{}
+	u.Add(NewRecord(1, "0.0.0.1"))
+	u.Add(NewRecord(1, "0.0.0.1"))
+	u.Add(NewRecord(1, "0.0.0.2"))
+	u.Add(NewRecord(2, "0.0.0.1"))
+	assert.Equal(t, UserIPs{1: {1: 0, 2: 0}, 2: {1: 0}}, u)
+
+	u.Merge(UserIPs{2: {3: 0}, 3: {1: 0}})
+	assert.Equal(t, UserIPs{1: {1: 0, 2: 0}, 2: {1: 0, 3: 0}, 3: {1: 0}}, u)
+
+	// the later time is kept, records without time are seen at the write
+	rec := NewRecord(4, "0.0.0.1")
+	for _, seen := range []int64{20, 10} {
+		rec.Seen = time.Unix(seen, 0)
+		u.Add(rec)
+	}
+	u.Merge(UserIPs{4: {1: 15, 2: 5}})
+	assert.Equal(t, map[uint32]int64{1: 20, 2: 5}, u[4])
+	u.Merge(UserIPs{4: {1: 0}})
+	u.Merge(UserIPs{4: {1: 30}})
+	assert.Equal(t, map[uint32]int64{1: 0, 2: 5}, u[4])
+}
@@ -1,10 +1,13 @@
 package record
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
 	"encoding/json"
 	"net"
+	"sort"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/pkg/errors"
@@ -15,15 +18,36 @@ const bucketName = "USER_INFO"
 // Repository encapsulates the logic to access domain models
 type Repository interface {
 	GetUserInfo(ctx context.Context, userID UserID) (*UserInfo, error)
+	GetUserInfos(ctx context.Context, userIDs ...UserID) ([]*UserInfo, error)
 	AddRecord(ctx context.Context, record *Record) error
 	BulkAddRecords(ctx context.Context, records []*Record) error
+	MergeUserIPs(ctx context.Context, users UserIPs) error
+	ForEachUser(ctx context.Context, fn func(info *UserInfo) error) error
+	ForEachUserInRange(ctx context.Context, from, to UserID, fn func(info *UserInfo) error) error
+	StorageStats(ctx context.Context) (*StorageStats, error)
+	DeleteUser(ctx context.Context, userID UserID, ref string) (bool, error)
+	DeleteUsers(ctx context.Context, userIDs []UserID, ref string) (int, error)
+	Purge(ctx context.Context, olderThan time.Time) (*Purged, error)
 	Clean(ctx context.Context) error
 }
 
+// StorageStats describes the file and pages of the store
+type StorageStats struct {
+	FileSize     int64                       `json:"file_size"`
+	PageSize     int                         `json:"page_size"`
+	FreePages    int                         `json:"free_pages"`
+	PendingPages int                         `json:"pending_pages"`
+	Buckets      map[string]bolt.BucketStats `json:"buckets"`
+}
+
 // UserInfo contains user's info. UserInfo accumulates all user logs
 type UserInfo struct {
 	UserID UserID
-	IPs    []net.IP
+	// IPs are sorted, so users are intersected without building sets
+	IPs IPSet
+	// LastSeen is unix time in seconds of the last record of every IP, it's kept as is, so an index
+	// returns it without decoding
+	LastSeen []uint32
 }
 
 type boltIP uint32
@@ -41,24 +65,33 @@ func (d *ipDecoder) Decode(ip boltIP) net.IP {
 	return net.IP(b)
 }
 
-// bolt specific user's info structure. Stored in boltdb
+// bolt specific user's info structure. Stored in boltdb.
+// LastSeen keeps unix time of the last record of every user's IP
 type boltUserInfo struct {
-	UserID UserID
-	IPset  map[boltIP]bool
+	UserID   UserID
+	LastSeen map[boltIP]int64
 	*ipDecoder
 }
 
 func (bu *boltUserInfo) toUserInfo() *UserInfo {
-	ips := make([]net.IP, 0, len(bu.IPset))
-	for ip := range bu.IPset {
-		ips = append(ips, bu.Decode(ip))
+	ips := make(IPSet, 0, len(bu.LastSeen))
+	for ip := range bu.LastSeen {
+		ips = append(ips, uint32(ip))
+	}
+	sort.Slice(ips, func(i, j int) bool { return ips[i] < ips[j] })
+	seen := make([]uint32, len(ips))
+	for i, ip := range ips {
+		seen[i] = uint32(bu.LastSeen[boltIP(ip)])
 	}
-	return &UserInfo{UserID: bu.UserID, IPs: ips}
+	return &UserInfo{UserID: bu.UserID, IPs: ips, LastSeen: seen}
 }
 
 type boltRepository struct {
-	DB  *bolt.DB
-	BKT string
+	DB     *bolt.DB
+	BKT    string
+	tenant Tenant
+
+	now func() time.Time
 }
 
 type key []byte
@@ -70,32 +103,57 @@ func getKey(userID UserID) key {
 	return key
 }
 
-// Get returns UserInfo by UserID or nil if it doesn't exist
+// Get returns UserInfo by UserID, UserInfo of unknown user has no IPs
 func (b *boltRepository) GetUserInfo(ctx context.Context, userID UserID) (*UserInfo, error) {
-	userInfo := &UserInfo{}
+	infos, err := b.GetUserInfos(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return infos[0], nil
+}
+
+// GetUserInfos returns UserInfos of users in the order of userIDs. Users are read within a single
+// transaction, so they're of the same database state. Reading stops on ctx cancellation
+func (b *boltRepository) GetUserInfos(ctx context.Context, userIDs ...UserID) ([]*UserInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	infos := make([]*UserInfo, len(userIDs))
 	err := b.DB.View(func(tx *bolt.Tx) error {
 		bkt := tx.Bucket([]byte(b.BKT))
-
-		v := bkt.Get(getKey(userID))
-		if v == nil {
-			return nil
-		}
-
-		boltUserInfo := boltUserInfo{}
-		if err := json.Unmarshal(v, &boltUserInfo); err != nil {
-			return err
+		for i, uID := range userIDs {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			v := bkt.Get(getKey(uID))
+			if v == nil {
+				infos[i] = &UserInfo{}
+				continue
+			}
+
+			boltUserInfo := boltUserInfo{}
+			if err := json.Unmarshal(v, &boltUserInfo); err != nil {
+				return errors.Wrapf(err, "failed to decode user %d info, check the store with verify-db", uID)
+			}
+			infos[i] = boltUserInfo.toUserInfo()
 		}
-		userInfo = boltUserInfo.toUserInfo()
-
 		return nil
 	})
-
-	return userInfo, err
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
 }
 
 // AddRecord does not add the record to storage literally. It gets user's UserInfo from storage
 // and updates it with new info. UserInfo will be created if it doesn't exist yet.
 func (b *boltRepository) AddRecord(ctx context.Context, record *Record) error {
+	if record.IP.To4() == nil {
+		return errors.Wrapf(ErrInvalidIP, "user %d", record.UserID)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	tx, err := b.DB.Begin(true)
 	if err != nil {
 		return err
@@ -107,7 +165,7 @@ func (b *boltRepository) AddRecord(ctx context.Context, record *Record) error {
 
 func (b *boltRepository) createBoltUserInfo(ctx context.Context, tx *bolt.Tx, record *Record) error {
 	boltUserInfo := boltUserInfo{UserID: record.UserID}
-	boltUserInfo.IPset = map[boltIP]bool{boltUserInfo.Encode(record.IP): true}
+	boltUserInfo.LastSeen = map[boltIP]int64{boltUserInfo.Encode(record.IP): b.seen(record.Seen)}
 
 	buf, err := json.Marshal(&boltUserInfo)
 	if err != nil {
@@ -119,23 +177,126 @@ func (b *boltRepository) createBoltUserInfo(ctx context.Context, tx *bolt.Tx, re
 	return nil
 }
 
-// BulkAddRecords processes []*Record and updates UserInfo for each user's
+// BulkAddRecords processes []*Record and updates UserInfo for each user's.
+// Records are collapsed to per-user IP sets first, so every user is updated once
 func (b *boltRepository) BulkAddRecords(ctx context.Context, records []*Record) error {
-	tx, err := b.DB.Begin(true)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
+	users := make(UserIPs)
 	for _, record := range records {
-		b.createOrUpdateBoltUserInfo(ctx, tx, record)
+		if record.IP.To4() == nil {
+			return errors.Wrapf(ErrInvalidIP, "user %d", record.UserID)
+		}
+		users.Add(record)
 	}
+	return b.MergeUserIPs(ctx, users)
+}
 
-	if err := tx.Commit(); err != nil {
+// MergeUserIPs merges users' IP sets into stored UserInfos in a single transaction.
+// Users are written in key order which is the cheapest way to fill bolt pages.
+// IPs' last seen times never go back, so backfilled records don't hide later accesses, see seen.
+// Merging stops on ctx cancellation, nothing is written then. Hooks of ctx are called last, see WithTxHook
+func (b *boltRepository) MergeUserIPs(ctx context.Context, users UserIPs) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
+	ids := make([]UserID, 0, len(users))
+	for uID := range users {
+		ids = append(ids, uID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	now := b.now().Unix()
 
-	return nil
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(b.BKT))
+		for n, uID := range ids {
+			if n%1e4 == 0 && ctx.Err() != nil {
+				return ctx.Err()
+			}
+			key := getKey(uID)
+			boltUserInfo := boltUserInfo{}
+			if v := bkt.Get(key); v != nil {
+				if err := json.Unmarshal(v, &boltUserInfo); err != nil {
+					return errors.Wrapf(err, "failed to decode user %d info", uID)
+				}
+			} else {
+				boltUserInfo.UserID = uID
+				boltUserInfo.LastSeen = make(map[boltIP]int64, len(users[uID]))
+			}
+			for ip, seen := range users[uID] {
+				if seen == 0 || seen > now {
+					seen = now
+				}
+				if seen > boltUserInfo.LastSeen[boltIP(ip)] {
+					boltUserInfo.LastSeen[boltIP(ip)] = seen
+				}
+			}
+
+			buf, err := json.Marshal(&boltUserInfo)
+			if err != nil {
+				return err
+			}
+			if err := bkt.Put(key, buf); err != nil {
+				return err
+			}
+		}
+		return runTxHooks(ctx, tx)
+	})
+}
+
+// ForEachUser calls fn for every user in key order within a single read transaction, so users are
+// streamed from the consistent snapshot. Iteration stops on the first error of fn or on ctx cancellation
+func (b *boltRepository) ForEachUser(ctx context.Context, fn func(info *UserInfo) error) error {
+	return b.ForEachUserInRange(ctx, 0, 0, fn)
+}
+
+// ForEachUserInRange is ForEachUser for users from `from` to `to` inclusive, zero `to` means no upper bound
+func (b *boltRepository) ForEachUserInRange(ctx context.Context, from, to UserID, fn func(info *UserInfo) error) error {
+	var last key
+	if to != 0 {
+		last = getKey(to)
+	}
+	return b.DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(b.BKT)).Cursor()
+		var n int
+		for k, v := c.Seek(getKey(from)); k != nil; k, v = c.Next() {
+			if last != nil && bytes.Compare(k, last) > 0 {
+				return nil
+			}
+			if n%1e4 == 0 && ctx.Err() != nil {
+				return ctx.Err()
+			}
+			n++
+			boltUserInfo := boltUserInfo{}
+			if err := json.Unmarshal(v, &boltUserInfo); err != nil {
+				return errors.Wrapf(err, "failed to decode user %d info", binary.BigEndian.Uint64(k))
+			}
+			if err := fn(boltUserInfo.toUserInfo()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// StorageStats returns file size, freelist and every bucket's page stats
+func (b *boltRepository) StorageStats(ctx context.Context) (*StorageStats, error) {
+	dbStats := b.DB.Stats()
+	stats := &StorageStats{
+		PageSize:     b.DB.Info().PageSize,
+		FreePages:    dbStats.FreePageN,
+		PendingPages: dbStats.PendingPageN,
+		Buckets:      make(map[string]bolt.BucketStats),
+	}
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		stats.FileSize = tx.Size()
+		return tx.ForEach(func(name []byte, bkt *bolt.Bucket) error {
+			stats.Buckets[string(name)] = bkt.Stats()
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
 }
 
 // Clean deletes bucket
@@ -146,15 +307,20 @@ func (b *boltRepository) Clean(ctx context.Context) error {
 	return err
 }
 
-// NewBoltRepository makes boltb Repository implementation, creates a bucket if it doesn't exist
+// NewBoltRepository makes boltb Repository implementation, creates a bucket if it doesn't exist.
+// Schema version of a new store is recorded, an existing store must be of SchemaVersion
 func NewBoltRepository(db *bolt.DB) (Repository, error) {
-	r := boltRepository{db, bucketName}
-	err := r.createBucketIfNotExists(bucketName)
-	if err != nil {
-		return nil, err
-	}
+	return NewTenantRepository(db, DefaultTenant)
+}
+
+// OpenBoltRepository makes boltdb Repository implementation over existing dataset of SchemaVersion.
+// It doesn't write anything, so db may be opened read-only
+func OpenBoltRepository(db *bolt.DB) (Repository, error) {
+	return OpenTenantRepository(db, DefaultTenant)
+}
 
-	return &r, nil
+func newBoltRepository(db *bolt.DB, tenant Tenant) *boltRepository {
+	return &boltRepository{DB: db, BKT: tenant.bucket(), tenant: tenant, now: time.Now}
 }
 
 // NewBoltDB returns boltb connection
@@ -166,6 +332,16 @@ func NewBoltDB(name string, options *bolt.Options) (*bolt.DB, error) {
 	return db, nil
 }
 
+// seen returns unix time of the access seen at t. Zero time is the time of the write, and so is a time
+// in the future, since it would never expire
+func (b *boltRepository) seen(t time.Time) int64 {
+	now := b.now().Unix()
+	if t.IsZero() || t.Unix() > now {
+		return now
+	}
+	return t.Unix()
+}
+
 func (b *boltRepository) createOrUpdateBoltUserInfo(ctx context.Context, tx *bolt.Tx, record *Record) error {
 	bkt := tx.Bucket([]byte(b.BKT))
 	v := bkt.Get([]byte(getKey(record.UserID)))
@@ -183,7 +359,10 @@ func (b *boltRepository) createOrUpdateBoltUserInfo(ctx context.Context, tx *bol
 }
 
 func (b *boltRepository) updateBoltUserInfo(ctx context.Context, tx *bolt.Tx, boltUserInfo *boltUserInfo, record *Record) error {
-	boltUserInfo.IPset[boltUserInfo.Encode(record.IP)] = true
+	ip := boltUserInfo.Encode(record.IP)
+	if seen := b.seen(record.Seen); seen > boltUserInfo.LastSeen[ip] {
+		boltUserInfo.LastSeen[ip] = seen
+	}
 
 	buf, err := json.Marshal(&boltUserInfo)
 	if err != nil {
@@ -6,9 +6,12 @@ import (
 	"net"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var testDb = "/tmp/test.db"
@@ -47,7 +50,7 @@ func TestBoltRepo_AddRecord(t *testing.T) {
 	assert.NoError(t, err)
 
 	assert.Equal(t, uID, boltUserInfo.UserID)
-	assert.True(t, boltUserInfo.IPset[1])
+	assert.NotZero(t, boltUserInfo.LastSeen[1])
 }
 
 func TestBoltRepo_BulkAddRecords(t *testing.T) {
@@ -62,6 +65,138 @@ func TestBoltRepo_BulkAddRecords(t *testing.T) {
 	err := r.BulkAddRecords(context.Background(), []*Record{record1, record2, record3, record4})
 
 	assert.NoError(t, err)
+
+	info, err := r.GetUserInfo(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, NewIPSet(record1.IP, record3.IP), info.IPs)
+}
+
+func TestBoltRepo_InvalidIP(t *testing.T) {
+	r, _, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+
+	err := r.AddRecord(ctx, NewRecord(1, "invalid"))
+	assert.Equal(t, ErrInvalidIP, errors.Cause(err))
+
+	err = r.BulkAddRecords(ctx, []*Record{NewRecord(1, "1.1.1.1"), NewRecord(2, "invalid")})
+	assert.Equal(t, ErrInvalidIP, errors.Cause(err))
+
+	info, err := r.GetUserInfo(ctx, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, info.IPs, "nothing is written if any record is invalid")
+}
+
+func TestBoltRepo_MergeUserIPs(t *testing.T) {
+	r, _, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+
+	err := r.AddRecord(ctx, NewRecord(1, "0.0.0.1"))
+	assert.NoError(t, err)
+
+	err = r.MergeUserIPs(ctx, UserIPs{1: {1: 0, 2: 0}, 2: {3: 0}})
+	assert.NoError(t, err)
+
+	info, err := r.GetUserInfo(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, NewIPSet(net.ParseIP("0.0.0.1"), net.ParseIP("0.0.0.2")), info.IPs)
+
+	info, err = r.GetUserInfo(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, NewIPSet(net.ParseIP("0.0.0.3")), info.IPs)
+}
+
+func TestBoltRepo_Seen(t *testing.T) {
+	r, _, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+	now := time.Date(2020, 1, 15, 10, 0, 0, 0, time.UTC)
+	r.(*boltRepository).now = func() time.Time { return now }
+	seen := func(id UserID, ip string, at time.Time) *Record {
+		rec := NewRecord(id, ip)
+		rec.Seen = at
+		return rec
+	}
+
+	// records are seen at their time, the time of the write is used without one and for future times
+	require.NoError(t, r.BulkAddRecords(ctx, []*Record{
+		seen(1, "0.0.0.1", now.Add(-time.Hour)), seen(1, "0.0.0.2", now.Add(time.Hour)), NewRecord(1, "0.0.0.3"),
+	}))
+	require.NoError(t, r.AddRecord(ctx, seen(2, "0.0.0.1", now.Add(-2*time.Hour))))
+	infos, err := r.GetUserInfos(ctx, 1, 2)
+	require.NoError(t, err)
+	unix := func(t time.Time) uint32 { return uint32(t.Unix()) }
+	assert.Equal(t, []uint32{unix(now.Add(-time.Hour)), unix(now), unix(now)}, infos[0].LastSeen)
+	assert.Equal(t, []uint32{unix(now.Add(-2 * time.Hour))}, infos[1].LastSeen)
+
+	// backfilled records never move last seen times back
+	require.NoError(t, r.BulkAddRecords(ctx, []*Record{seen(1, "0.0.0.1", now.Add(-3*time.Hour))}))
+	require.NoError(t, r.AddRecord(ctx, seen(2, "0.0.0.1", now.Add(-3*time.Hour))))
+	infos, err = r.GetUserInfos(ctx, 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, unix(now.Add(-time.Hour)), infos[0].LastSeen[0])
+	assert.Equal(t, unix(now.Add(-2*time.Hour)), infos[1].LastSeen[0])
+}
+
+func TestBoltRepo_MergeUserIPsTxHook(t *testing.T) {
+	r, db, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("HOOK"))
+		return err
+	}))
+
+	// an error of the hook rolls the users back
+	failing := WithTxHook(ctx, func(tx *bolt.Tx) error { return errors.New("failed") })
+	assert.EqualError(t, r.MergeUserIPs(failing, UserIPs{1: {1: 0}}), "failed")
+	info, err := r.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Empty(t, info.IPs)
+
+	hooked := WithTxHook(ctx, func(tx *bolt.Tx) error { return tx.Bucket([]byte("HOOK")).Put([]byte("k"), []byte("v")) })
+	require.NoError(t, r.BulkAddRecords(hooked, []*Record{NewRecord(1, "0.0.0.1")}))
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		assert.Equal(t, []byte("v"), tx.Bucket([]byte("HOOK")).Get([]byte("k")))
+		return nil
+	}))
+}
+
+func TestBoltRepo_GetUserInfos(t *testing.T) {
+	r, _, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+	assert.NoError(t, r.BulkAddRecords(ctx, []*Record{NewRecord(1, "0.0.0.1"), NewRecord(2, "0.0.0.2"), NewRecord(2, "0.0.0.3")}))
+
+	infos, err := r.GetUserInfos(ctx, 2, 3, 1, 2)
+	assert.NoError(t, err)
+	assert.Len(t, infos, 4)
+	assert.Equal(t, NewIPSet(net.ParseIP("0.0.0.2"), net.ParseIP("0.0.0.3")), infos[0].IPs)
+	assert.Empty(t, infos[1].IPs)
+	assert.Equal(t, NewIPSet(net.ParseIP("0.0.0.1")), infos[2].IPs)
+	assert.Equal(t, infos[0], infos[3])
+
+	infos, err = r.GetUserInfos(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, infos)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = r.GetUserInfos(cancelled, 1, 2)
+	assert.Equal(t, context.Canceled, err)
+	_, err = r.GetUserInfo(cancelled, 1)
+	assert.Equal(t, context.Canceled, err)
+	expired, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = r.GetUserInfos(expired, 1)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	assert.Equal(t, context.Canceled, r.AddRecord(cancelled, NewRecord(3, "0.0.0.4")))
+	assert.Equal(t, context.Canceled, r.MergeUserIPs(cancelled, UserIPs{3: {4: 0}}))
+	info, err := r.GetUserInfo(ctx, 3)
+	assert.NoError(t, err)
+	assert.Empty(t, info.IPs, "nothing is written with cancelled context")
 }
 
 func TestBoltRepo_createOrUpdateBehavior(t *testing.T) {
@@ -125,12 +260,12 @@ func TestBoltRepo_GetUserInfo(t *testing.T) {
 	info, err := r.GetUserInfo(ctx, uID1)
 	assert.NoError(t, err)
 	assert.Equal(t, 2, len(info.IPs), info.IPs)
-	assert.ElementsMatch(t, []net.IP{net.ParseIP(ip1).To4(), net.ParseIP(ip2).To4()}, info.IPs)
+	assert.Equal(t, NewIPSet(net.ParseIP(ip1), net.ParseIP(ip2)), info.IPs)
 
 	info, err = r.GetUserInfo(ctx, uID2)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(info.IPs))
-	assert.Equal(t, []net.IP{net.ParseIP(ip1).To4()}, info.IPs)
+	assert.Equal(t, NewIPSet(net.ParseIP(ip1)), info.IPs)
 
 	info, err = r.GetUserInfo(ctx, UserID(10))
 	assert.NoError(t, err)
@@ -156,6 +291,66 @@ func TestBoltRepo_Clean(t *testing.T) {
 	})
 }
 
+func TestBoltRepo_ForEachUser(t *testing.T) {
+	r, _, teardown := prepBoltRepo(t)
+	defer teardown()
+
+	ctx := context.Background()
+	err := r.BulkAddRecords(ctx, []*Record{NewRecord(2, "1.1.1.1"), NewRecord(1, "1.1.1.1"), NewRecord(2, "2.2.2.2")})
+	assert.NoError(t, err)
+
+	var users []UserID
+	var ips int
+	err = r.ForEachUser(ctx, func(info *UserInfo) error {
+		users = append(users, info.UserID)
+		ips += len(info.IPs)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []UserID{1, 2}, users)
+	assert.Equal(t, 3, ips)
+
+	stop := errors.New("stop")
+	err = r.ForEachUser(ctx, func(info *UserInfo) error { return stop })
+	assert.Equal(t, stop, err)
+
+	stats, err := r.StorageStats(ctx)
+	assert.NoError(t, err)
+	assert.True(t, stats.FileSize > 0)
+	assert.Equal(t, 2, stats.Buckets[bucketName].KeyN)
+}
+
+func TestBoltRepo_DeleteUsers(t *testing.T) {
+	r, b, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+
+	err := r.BulkAddRecords(ctx, []*Record{NewRecord(1, "1.1.1.1"), NewRecord(2, "1.1.1.1"), NewRecord(3, "3.3.3.3")})
+	assert.NoError(t, err)
+
+	deleted, err := r.DeleteUser(ctx, 1, "ticket-1")
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+	deleted, err = r.DeleteUser(ctx, 1, "ticket-1")
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+	n, err := r.DeleteUsers(ctx, []UserID{2, 3, 4}, "ticket-2")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	for _, uID := range []UserID{1, 2, 3} {
+		info, err := r.GetUserInfo(ctx, uID)
+		assert.NoError(t, err)
+		assert.Empty(t, info.IPs)
+	}
+
+	entries, err := Audit(b)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, AuditEntry{ID: 3, Action: AuditDeleteUsers, Ref: "ticket-2", Requested: 3, Deleted: 2, At: entries[2].At}, *entries[2])
+	assert.Equal(t, 0, entries[1].Deleted)
+}
+
 type recordTestCase struct {
 	i boltIP
 	s string
@@ -191,7 +386,7 @@ func getSuccCases() []recordTestCase {
 	}
 }
 
-func prepBoltRepo(t *testing.T) (repo Repository, bolt *bolt.DB, teardown func()) {
+func prepBoltRepo(t testing.TB) (repo Repository, bolt *bolt.DB, teardown func()) {
 	_ = os.Remove(testDb)
 
 	bolt, err := NewBoltDB(testDb, nil)
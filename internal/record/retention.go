@@ -0,0 +1,124 @@
+package record
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// purgeBatch is the number of users checked within a single purge transaction,
+// so write transactions stay short and reads aren't blocked for long
+const purgeBatch = 1e4
+
+// Purged counts user's IPs removed by a purge and users left without IPs which are removed too
+type Purged struct {
+	Users int `json:"users"`
+	IPs   int `json:"ips"`
+}
+
+// Purge removes user's IPs last seen before olderThan. Users are purged in batches, every one within its
+// own transaction, so a cancelled purge keeps the batches committed already
+func (b *boltRepository) Purge(ctx context.Context, olderThan time.Time) (*Purged, error) {
+	res := &Purged{}
+	before := olderThan.Unix()
+	from := getKey(0)
+	for from != nil {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		err := b.DB.Update(func(tx *bolt.Tx) error {
+			var err error
+			from, err = b.purgeBatch(tx, from, before, res)
+			return err
+		})
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// purgeBatch purges up to purgeBatch users starting from the key and returns the key to continue from,
+// it's nil when all users are checked. Values are changed after they're read, since bolt cursor
+// shouldn't be used over a bucket modified meanwhile
+func (b *boltRepository) purgeBatch(tx *bolt.Tx, from key, before int64, res *Purged) (key, error) {
+	bkt := tx.Bucket([]byte(b.BKT))
+	updated := make(map[string][]byte)
+	var deleted []key
+	var next key
+
+	c := bkt.Cursor()
+	var n int
+	for k, v := c.Seek(from); k != nil; k, v = c.Next() {
+		if n == purgeBatch {
+			next = append(key(nil), k...)
+			break
+		}
+		n++
+		info := boltUserInfo{}
+		if err := json.Unmarshal(v, &info); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode user %d info", binary.BigEndian.Uint64(k))
+		}
+		var expired int
+		for ip, ts := range info.LastSeen {
+			if ts < before {
+				delete(info.LastSeen, ip)
+				expired++
+			}
+		}
+		if expired == 0 {
+			continue
+		}
+		res.IPs += expired
+		if len(info.LastSeen) == 0 {
+			deleted = append(deleted, append(key(nil), k...))
+			continue
+		}
+		buf, err := json.Marshal(&info)
+		if err != nil {
+			return nil, err
+		}
+		updated[string(k)] = buf
+	}
+
+	for k, v := range updated {
+		if err := bkt.Put([]byte(k), v); err != nil {
+			return nil, err
+		}
+	}
+	for _, k := range deleted {
+		if err := bkt.Delete(k); err != nil {
+			return nil, err
+		}
+	}
+	res.Users += len(deleted)
+	return next, nil
+}
+
+// Sweep purges IPs last seen more than retention ago every interval. It blocks until ctx is cancelled.
+// Read-only store is skipped, a dataset may be swapped for a writable one later
+func Sweep(ctx context.Context, repo Repository, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			res, err := repo.Purge(ctx, time.Now().Add(-retention))
+			switch {
+			case err == bolt.ErrDatabaseReadOnly:
+				log.Printf("[DEBUG] store is read-only, sweep skipped")
+			case err != nil && ctx.Err() == nil:
+				log.Printf("[ERROR] failed to sweep expired IPs: %+v", err)
+			case err == nil && res.IPs > 0:
+				log.Printf("[INFO] swept %d expired IPs, %d users removed", res.IPs, res.Users)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package record
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoltRepo_Purge(t *testing.T) {
+	r, _, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+	day := 24 * time.Hour
+	now := time.Date(2020, 1, 15, 10, 0, 0, 0, time.UTC)
+	clock := func(at time.Time) { r.(*boltRepository).now = func() time.Time { return at } }
+
+	clock(now.Add(-200 * day))
+	require.NoError(t, r.BulkAddRecords(ctx, []*Record{NewRecord(1, "1.1.1.1"), NewRecord(1, "2.2.2.2"), NewRecord(2, "1.1.1.1")}))
+	clock(now.Add(-10 * day))
+	require.NoError(t, r.AddRecord(ctx, NewRecord(1, "2.2.2.2")))
+	require.NoError(t, r.AddRecord(ctx, NewRecord(3, "3.3.3.3")))
+
+	info, err := r.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	seen := make(map[string]time.Time)
+	for i := range info.IPs {
+		seen[info.IPs.IP(i).String()] = time.Unix(int64(info.LastSeen[i]), 0).UTC()
+	}
+	assert.Equal(t, map[string]time.Time{"1.1.1.1": now.Add(-200 * day), "2.2.2.2": now.Add(-10 * day)}, seen)
+
+	res, err := r.Purge(ctx, now.Add(-180*day))
+	require.NoError(t, err)
+	assert.Equal(t, &Purged{Users: 1, IPs: 2}, res)
+
+	info, err = r.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, NewIPSet(net.ParseIP("2.2.2.2")), info.IPs)
+	info, err = r.GetUserInfo(ctx, 2)
+	require.NoError(t, err)
+	assert.Empty(t, info.IPs)
+	info, err = r.GetUserInfo(ctx, 3)
+	require.NoError(t, err)
+	assert.Len(t, info.IPs, 1)
+
+	res, err = r.Purge(ctx, now.Add(-180*day))
+	require.NoError(t, err)
+	assert.Equal(t, &Purged{}, res)
+}
+
+func TestService_Retention(t *testing.T) {
+	r, _, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+	day := 24 * time.Hour
+	now := time.Date(2020, 1, 15, 10, 0, 0, 0, time.UTC)
+	r.(*boltRepository).now = func() time.Time { return now.Add(-200 * day) }
+	require.NoError(t, r.BulkAddRecords(ctx, []*Record{
+		NewRecord(1, "1.1.1.1"), NewRecord(1, "2.2.2.2"),
+		NewRecord(2, "1.1.1.1"), NewRecord(2, "2.2.2.2"),
+	}))
+	r.(*boltRepository).now = func() time.Time { return now.Add(-10 * day) }
+	require.NoError(t, r.BulkAddRecords(ctx, []*Record{NewRecord(1, "1.1.1.1"), NewRecord(2, "1.1.1.1")}))
+
+	dupe, err := NewService(r).IsDuple(ctx, 1, 2)
+	require.NoError(t, err)
+	assert.True(t, dupe)
+
+	// 2.2.2.2 is expired before it's purged
+	s := NewServiceWithRules(r, Rules{Retention: 180 * day}).(*service)
+	s.now = func() time.Time { return now }
+	dupe, err = s.IsDuple(ctx, 1, 2)
+	require.NoError(t, err)
+	assert.False(t, dupe)
+	e, err := s.Explain(ctx, 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("1.1.1.1").To4()}, e.CommonIPs)
+}
@@ -0,0 +1,219 @@
+package record
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+const (
+	// metaBucketName is shared with other packages keeping dataset metadata, every one under its own key
+	metaBucketName = "METADATA"
+	schemaKey      = "schema"
+)
+
+// SchemaVersion is the version of the store layout this build works with
+const SchemaVersion = 2
+
+// ErrSchemaVersion is returned when the store layout version isn't SchemaVersion
+var ErrSchemaVersion = errors.New("unexpected store schema version")
+
+// Schema describes the store layout, it's kept in the meta bucket
+type Schema struct {
+	Version    int       `json:"version"`
+	CreatedAt  time.Time `json:"created_at"`
+	MigratedAt time.Time `json:"migrated_at,omitempty"`
+}
+
+// Migration upgrades the store layout from Version-1 to Version within a single write transaction
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *bolt.Tx) error
+}
+
+// migrations are ordered by version, the last one upgrades the store to SchemaVersion
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "record schema version of the store created before versioning",
+		Up: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+			return err
+		},
+	},
+	{
+		Version:     2,
+		Description: "track last seen time of every user's IP, IPs are considered seen at the migration",
+		Up:          migrateLastSeen,
+	},
+}
+
+// boltUserInfoV1 is the user's info stored by schema version 1
+type boltUserInfoV1 struct {
+	UserID UserID
+	IPset  map[boltIP]bool
+}
+
+// migrateLastSeen rewrites users' IP sets to last seen maps. Values are rewritten in chunks after
+// they're read, since bolt cursor shouldn't be used over a bucket modified meanwhile
+func migrateLastSeen(tx *bolt.Tx) error {
+	bkt := tx.Bucket([]byte(bucketName))
+	if bkt == nil {
+		return nil
+	}
+	now := time.Now().Unix()
+	c := bkt.Cursor()
+	for k, _ := c.First(); k != nil; {
+		rewritten := make(map[string][]byte)
+		var last []byte
+		for ; k != nil && len(rewritten) < 1e4; k, _ = c.Next() {
+			v1 := boltUserInfoV1{}
+			if err := json.Unmarshal(bkt.Get(k), &v1); err != nil {
+				return errors.Wrapf(err, "failed to decode user %x info", k)
+			}
+			info := boltUserInfo{UserID: v1.UserID, LastSeen: make(map[boltIP]int64, len(v1.IPset))}
+			for ip := range v1.IPset {
+				info.LastSeen[ip] = now
+			}
+			buf, err := json.Marshal(&info)
+			if err != nil {
+				return err
+			}
+			rewritten[string(k)] = buf
+			last = k
+		}
+		last = append([]byte(nil), last...)
+		for key, v := range rewritten {
+			if err := bkt.Put([]byte(key), v); err != nil {
+				return err
+			}
+		}
+		c.Seek(last)
+		k, _ = c.Next()
+	}
+	return nil
+}
+
+// GetSchema returns the store schema. Version of the store created before versioning is 0
+func GetSchema(db *bolt.DB) (*Schema, error) {
+	var s *Schema
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		s, err = readSchema(tx)
+		return err
+	})
+	return s, err
+}
+
+// Migrate applies pending migrations, every one within its own transaction, and returns them.
+// With dryRun all pending migrations are applied within a single transaction which is rolled back
+func Migrate(ctx context.Context, db *bolt.DB, dryRun bool) ([]Migration, error) {
+	s, err := GetSchema(db)
+	if err != nil {
+		return nil, err
+	}
+	if s.Version > SchemaVersion {
+		return nil, newerSchemaError(s.Version)
+	}
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > s.Version {
+			pending = append(pending, m)
+		}
+	}
+
+	if dryRun {
+		tx, err := db.Begin(true)
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		for _, m := range pending {
+			if err := m.Up(tx); err != nil {
+				return nil, errors.Wrapf(err, "migration %d failed", m.Version)
+			}
+		}
+		return pending, nil
+	}
+
+	for i, m := range pending {
+		if err := ctx.Err(); err != nil {
+			return pending[:i], err
+		}
+		err := db.Update(func(tx *bolt.Tx) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			s.Version, s.MigratedAt = m.Version, time.Now().UTC()
+			return writeSchema(tx, s)
+		})
+		if err != nil {
+			return pending[:i], errors.Wrapf(err, "migration %d failed", m.Version)
+		}
+	}
+	return pending, nil
+}
+
+// initSchema records the current schema version of a new store, the version of an existing store is checked
+func initSchema(tx *bolt.Tx) error {
+	s, err := readSchema(tx)
+	if err != nil {
+		return err
+	}
+	if s.Version == 0 && tx.Bucket([]byte(bucketName)) == nil {
+		return writeSchema(tx, &Schema{Version: SchemaVersion, CreatedAt: time.Now().UTC()})
+	}
+	return checkSchema(s)
+}
+
+func checkSchema(s *Schema) error {
+	if s.Version > SchemaVersion {
+		return newerSchemaError(s.Version)
+	}
+	if s.Version < SchemaVersion {
+		return errors.Wrapf(ErrSchemaVersion, "store schema version is %d, expected %d, run migrate command to upgrade it",
+			s.Version, SchemaVersion)
+	}
+	return nil
+}
+
+func newerSchemaError(version int) error {
+	return errors.Wrapf(ErrSchemaVersion, "store schema version %d is newer than supported %d, upgrade duplicates-checker",
+		version, SchemaVersion)
+}
+
+// readSchema returns the stored schema or the zero version one
+func readSchema(tx *bolt.Tx) (*Schema, error) {
+	s := &Schema{}
+	bkt := tx.Bucket([]byte(metaBucketName))
+	if bkt == nil {
+		return s, nil
+	}
+	v := bkt.Get([]byte(schemaKey))
+	if v == nil {
+		return s, nil
+	}
+	if err := json.Unmarshal(v, s); err != nil {
+		return nil, errors.Wrap(err, "failed to decode store schema")
+	}
+	return s, nil
+}
+
+func writeSchema(tx *bolt.Tx, s *Schema) error {
+	if s.CreatedAt.IsZero() {
+		s.CreatedAt = time.Now().UTC()
+	}
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	bkt, err := tx.CreateBucketIfNotExists([]byte(metaBucketName))
+	if err != nil {
+		return errors.Wrapf(err, "failed to create bucket %s", metaBucketName)
+	}
+	return bkt.Put([]byte(schemaKey), buf)
+}
@@ -0,0 +1,91 @@
+package record
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchema_New(t *testing.T) {
+	_, db, teardown := prepBoltRepo(t)
+	defer teardown()
+
+	s, err := GetSchema(db)
+	require.NoError(t, err)
+	assert.Equal(t, SchemaVersion, s.Version)
+	assert.False(t, s.CreatedAt.IsZero())
+
+	_, err = NewBoltRepository(db)
+	assert.NoError(t, err)
+	_, err = OpenBoltRepository(db)
+	assert.NoError(t, err)
+}
+
+func TestSchema_Migrate(t *testing.T) {
+	_, db, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+
+	// the store created before versioning
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucketName))
+		require.NoError(t, bkt.Put(getKey(1), []byte(`{"UserID":1,"IPset":{"16843009":true,"33686018":true}}`)))
+		return tx.DeleteBucket([]byte(metaBucketName))
+	}))
+	_, err := NewBoltRepository(db)
+	assert.Equal(t, ErrSchemaVersion, errors.Cause(err))
+	assert.EqualError(t, err, "store schema version is 0, expected 2, run migrate command to upgrade it: unexpected store schema version")
+	_, err = OpenBoltRepository(db)
+	assert.Equal(t, ErrSchemaVersion, errors.Cause(err))
+
+	pending, err := Migrate(ctx, db, true)
+	require.NoError(t, err)
+	assert.Len(t, pending, 2)
+	s, err := GetSchema(db)
+	require.NoError(t, err)
+	assert.Equal(t, 0, s.Version)
+
+	applied, err := Migrate(ctx, db, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, applied[0].Version)
+	s, err = GetSchema(db)
+	require.NoError(t, err)
+	assert.Equal(t, SchemaVersion, s.Version)
+	assert.False(t, s.MigratedAt.IsZero())
+	r, err := NewBoltRepository(db)
+	require.NoError(t, err)
+	info, err := r.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, NewIPSet(net.ParseIP("1.1.1.1"), net.ParseIP("2.2.2.2")), info.IPs)
+	assert.WithinDuration(t, s.MigratedAt, time.Unix(int64(info.LastSeen[0]), 0), time.Second)
+
+	applied, err = Migrate(ctx, db, false)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+}
+
+func TestSchema_Newer(t *testing.T) {
+	_, db, teardown := prepBoltRepo(t)
+	defer teardown()
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return writeSchema(tx, &Schema{Version: SchemaVersion + 1})
+	}))
+	_, err := NewBoltRepository(db)
+	assert.EqualError(t, err, "store schema version 3 is newer than supported 2, upgrade duplicates-checker: unexpected store schema version")
+	_, err = Migrate(context.Background(), db, false)
+	assert.Equal(t, ErrSchemaVersion, errors.Cause(err))
+}
+
+func TestMigrations_Ordered(t *testing.T) {
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version)
+	}
+	assert.Equal(t, SchemaVersion, migrations[len(migrations)-1].Version)
+}
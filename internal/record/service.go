@@ -2,8 +2,8 @@ package record
 
 import (
 	"context"
-	"encoding/binary"
 	"net"
+	"time"
 )
 
 const doubleLimit = 2
@@ -12,12 +12,33 @@ const doubleLimit = 2
 type Service interface {
 	AddRecord(ctx context.Context, record *Record) error
 	BulkAddRecords(ctx context.Context, records []*Record) error
+	MergeUserIPs(ctx context.Context, users UserIPs) error
 	IsDuple(ctx context.Context, u1, u2 UserID) (bool, error)
+	Explain(ctx context.Context, u1, u2 UserID) (*Explanation, error)
+	DeleteUser(ctx context.Context, userID UserID, ref string) (bool, error)
 	Clear(ctx context.Context) error
 }
 
+// Rules configure duplicates checks
+type Rules struct {
+	// CommonIPs is the number of common IPs making users duplicates, zero means the default of 2
+	CommonIPs int
+	// Retention is how long user's IP counts after it's last seen, zero keeps IPs forever
+	Retention time.Duration
+}
+
+// Explanation tells why users are duplicates or not
+type Explanation struct {
+	Dupes     bool
+	CommonIPs []net.IP
+	// Required is the number of common IPs making users duplicates
+	Required int
+}
+
 type service struct {
-	repo Repository
+	repo  Repository
+	rules Rules
+	now   func() time.Time
 }
 
 // AddRecord processes new record
@@ -38,57 +59,72 @@ func (s *service) BulkAddRecords(ctx context.Context, records []*Record) error {
 	return nil
 }
 
+// MergeUserIPs processes already aggregated users' IPs
+func (s *service) MergeUserIPs(ctx context.Context, users UserIPs) error {
+	return s.repo.MergeUserIPs(ctx, users)
+}
+
 // IsDuple returns true if users are duplicates
 func (s *service) IsDuple(ctx context.Context, u1, u2 UserID) (bool, error) {
 	if u1 == u2 {
 		return true, nil
 	}
-	u1Info, err := s.repo.GetUserInfo(ctx, u1)
+	infos, err := s.repo.GetUserInfos(ctx, u1, u2)
 	if err != nil {
 		return false, err
 	}
-	u2Info, err := s.repo.GetUserInfo(ctx, u2)
+
+	return s.active(infos[0]).HasNCommons(s.active(infos[1]), s.rules.CommonIPs), nil
+}
+
+// Explain returns users' common IPs along with the verdict
+func (s *service) Explain(ctx context.Context, u1, u2 UserID) (*Explanation, error) {
+	infos, err := s.repo.GetUserInfos(ctx, u1, u2)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	return s.hasNCommons(u1Info.IPs, u2Info.IPs, doubleLimit), nil
+	common := s.active(infos[0]).Intersect(s.active(infos[1]))
+	e := &Explanation{Dupes: u1 == u2 || len(common) >= s.rules.CommonIPs, Required: s.rules.CommonIPs}
+	for i := range common {
+		e.CommonIPs = append(e.CommonIPs, common.IP(i))
+	}
+	return e, nil
+}
+
+// DeleteUser erases user's data, ref is recorded in the audit instead of the user
+func (s *service) DeleteUser(ctx context.Context, userID UserID, ref string) (bool, error) {
+	return s.repo.DeleteUser(ctx, userID, ref)
 }
 
 func (s *service) Clear(ctx context.Context) error {
 	return s.repo.Clean(ctx)
 }
 
-// Returns the value true if `a` and `b` slices has n common values
-func (s *service) hasNCommons(a, b []net.IP, n int) bool {
-	temp := make(map[uint32]int)
-
-	for _, i := range a {
-		// handle non-unique values
-		temp[uint32(binary.BigEndian.Uint32(i.To4()))] = 1
-	}
-	for _, i := range b {
-		k := uint32(binary.BigEndian.Uint32(i.To4()))
-		v, ok := temp[k]
-		if ok && v < 2 {
-			temp[k]++
-		}
+// active returns user's IPs which aren't expired yet, expired ones are ignored even before they're purged
+func (s *service) active(info *UserInfo) IPSet {
+	if s.rules.Retention == 0 || len(info.LastSeen) != len(info.IPs) {
+		return info.IPs
 	}
-
-	var commons int
-	for _, v := range temp {
-		// v presents in both slices
-		if v >= 2 {
-			commons++
-		}
-		if commons >= n {
-			return true
+	since := s.now().Add(-s.rules.Retention).Unix()
+	ips := make(IPSet, 0, len(info.IPs))
+	for i, ip := range info.IPs {
+		if int64(info.LastSeen[i]) >= since {
+			ips = append(ips, ip)
 		}
 	}
-	return false
+	return ips
 }
 
 // NewService returns Service implementation
 func NewService(repo Repository) Service {
-	return &service{repo}
+	return NewServiceWithRules(repo, Rules{})
+}
+
+// NewServiceWithRules returns Service implementation checking users by rules
+func NewServiceWithRules(repo Repository, rules Rules) Service {
+	if rules.CommonIPs == 0 {
+		rules.CommonIPs = doubleLimit
+	}
+	return &service{repo: repo, rules: rules, now: time.Now}
 }
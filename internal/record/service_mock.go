@@ -14,13 +14,19 @@ type MockedService struct {
 // AddRecord mocked
 func (m *MockedService) AddRecord(ctx context.Context, record *Record) error {
 	args := m.Called(ctx, record)
-	return args.Error(1)
+	return args.Error(0)
 }
 
 // BulkAddRecords mocked
 func (m *MockedService) BulkAddRecords(ctx context.Context, records []*Record) error {
 	args := m.Called(ctx, records)
-	return args.Error(1)
+	return args.Error(0)
+}
+
+// MergeUserIPs mocked
+func (m *MockedService) MergeUserIPs(ctx context.Context, users UserIPs) error {
+	args := m.Called(ctx, users)
+	return args.Error(0)
 }
 
 // IsDuple mocked
@@ -29,8 +35,21 @@ func (m *MockedService) IsDuple(ctx context.Context, u1, u2 UserID) (bool, error
 	return args.Bool(0), args.Error(1)
 }
 
+// Explain mocked
+func (m *MockedService) Explain(ctx context.Context, u1, u2 UserID) (*Explanation, error) {
+	args := m.Called(ctx, u1, u2)
+	e, _ := args.Get(0).(*Explanation)
+	return e, args.Error(1)
+}
+
+// DeleteUser mocked
+func (m *MockedService) DeleteUser(ctx context.Context, userID UserID, ref string) (bool, error) {
+	args := m.Called(ctx, userID, ref)
+	return args.Bool(0), args.Error(1)
+}
+
 // Clear mocked
 func (m *MockedService) Clear(ctx context.Context) error {
 	args := m.Called(ctx)
-	return args.Error(1)
+	return args.Error(0)
 }
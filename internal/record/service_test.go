@@ -1,20 +1,14 @@
 package record
 
 import (
-	"fmt"
+	"context"
 	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-type serviceTestCase struct {
-	a   []net.IP
-	b   []net.IP
-	n   int
-	res bool
-}
-
 var ip1 = net.ParseIP("1.1.1.1")
 var ip2 = net.ParseIP("2.2.2.2")
 var ip3 = net.ParseIP("3.3.3.3")
@@ -23,23 +17,55 @@ var ip5 = net.ParseIP("5.5.5.5")
 var ip6 = net.ParseIP("6.6.6.6")
 var ip7 = net.ParseIP("7.7.7.7")
 
-func TestService_hasNCommons(t *testing.T) {
-	cases := []serviceTestCase{
-		serviceTestCase{[]net.IP{}, []net.IP{}, 1, false},
-		serviceTestCase{[]net.IP{}, []net.IP{ip1}, 1, false},
-		serviceTestCase{[]net.IP{ip1}, []net.IP{ip1}, 1, true},
-		serviceTestCase{[]net.IP{ip1}, []net.IP{ip2}, 1, false},
-		serviceTestCase{[]net.IP{ip1, ip2}, []net.IP{ip3, ip5}, 1, false},
-
-		serviceTestCase{[]net.IP{ip1}, []net.IP{ip1}, 2, false},
-		serviceTestCase{[]net.IP{ip1}, []net.IP{ip2, ip3, ip4, ip5}, 2, false},
-		serviceTestCase{[]net.IP{ip1, ip2}, []net.IP{ip2, ip3}, 2, false},
-		serviceTestCase{[]net.IP{ip1, ip2, ip3, ip4}, []net.IP{ip4, ip5, ip6, ip7}, 2, false},
-		serviceTestCase{[]net.IP{ip1, ip2, ip3, ip4}, []net.IP{ip3, ip4, ip5, ip6, ip7}, 2, true},
-		serviceTestCase{[]net.IP{ip1, ip2, ip3}, []net.IP{ip2, ip3, ip4, ip5, ip6, ip7}, 2, true},
-	}
-	s := &service{}
-	for _, c := range cases {
-		assert.Equal(t, c.res, s.hasNCommons(c.a, c.b, c.n), fmt.Sprintf("a: %v, b: %v, n: %d", c.a, c.b, c.n))
-	}
+func TestService_Explain(t *testing.T) {
+	repo, _, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+	require.NoError(t, repo.BulkAddRecords(ctx, []*Record{
+		NewRecord(1, "3.3.3.3"), NewRecord(1, "1.1.1.1"), NewRecord(1, "2.2.2.2"),
+		NewRecord(2, "2.2.2.2"), NewRecord(2, "3.3.3.3"),
+		NewRecord(3, "1.1.1.1"),
+	}))
+	s := NewService(repo)
+
+	e, err := s.Explain(ctx, 1, 2)
+	require.NoError(t, err)
+	assert.True(t, e.Dupes)
+	assert.Equal(t, 2, e.Required)
+	assert.Equal(t, []net.IP{net.ParseIP("2.2.2.2").To4(), net.ParseIP("3.3.3.3").To4()}, e.CommonIPs)
+
+	e, err = s.Explain(ctx, 2, 3)
+	require.NoError(t, err)
+	assert.False(t, e.Dupes)
+	assert.Empty(t, e.CommonIPs)
+
+	e, err = s.Explain(ctx, 4, 4)
+	require.NoError(t, err)
+	assert.True(t, e.Dupes)
+}
+
+func TestService_IsDuple(t *testing.T) {
+	repo, _, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+	require.NoError(t, repo.BulkAddRecords(ctx, []*Record{
+		NewRecord(1, "1.1.1.1"), NewRecord(1, "2.2.2.2"),
+		NewRecord(2, "2.2.2.2"), NewRecord(2, "1.1.1.1"),
+		NewRecord(3, "1.1.1.1"),
+	}))
+	counting := &countingRepository{Repository: repo}
+	s := NewService(counting)
+
+	dupes, err := s.IsDuple(ctx, 1, 2)
+	require.NoError(t, err)
+	assert.True(t, dupes)
+	assert.Equal(t, int64(1), counting.gets, "both users are read at once")
+	dupes, err = s.IsDuple(ctx, 1, 3)
+	require.NoError(t, err)
+	assert.False(t, dupes)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = s.IsDuple(cancelled, 1, 2)
+	assert.Equal(t, context.Canceled, err)
 }
@@ -0,0 +1,147 @@
+package record
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SwappableRepository serves requests from the current repository which may be replaced at runtime
+type SwappableRepository struct {
+	mu      sync.RWMutex
+	current *activeRepository
+	onSwap  []func()
+}
+
+type activeRepository struct {
+	Repository
+	inflight sync.WaitGroup
+}
+
+// NewSwappableRepository returns SwappableRepository serving from repo
+func NewSwappableRepository(repo Repository) *SwappableRepository {
+	return &SwappableRepository{current: &activeRepository{Repository: repo}}
+}
+
+// Swap makes repo the current one and calls OnSwap functions. It returns the previous repository
+// once all requests and holds of it are finished, so it may be closed right away
+func (s *SwappableRepository) Swap(repo Repository) Repository {
+	s.mu.Lock()
+	prev := s.current
+	s.current = &activeRepository{Repository: repo}
+	onSwap := s.onSwap
+	s.mu.Unlock()
+
+	for _, fn := range onSwap {
+		fn()
+	}
+	prev.inflight.Wait()
+	return prev.Repository
+}
+
+// OnSwap registers fn called after the current repository is swapped, e.g. to reset a cache over it
+func (s *SwappableRepository) OnSwap(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onSwap = append(s.onSwap, fn)
+}
+
+// Hold keeps the current repository from being returned by Swap until release is called. Users read
+// meanwhile may be used until then even if they're views into the repository, e.g. into a mapped index.
+// Swap waits for the hold, so the holder must not swap the repository itself
+func (s *SwappableRepository) Hold() (release func()) {
+	return s.acquire().inflight.Done
+}
+
+// acquire returns the current repository, release it with inflight.Done when the request is finished.
+// A repository is never acquired after it's swapped, so waiting for inflight of the previous one is safe
+func (s *SwappableRepository) acquire() *activeRepository {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.current.inflight.Add(1)
+	return s.current
+}
+
+// GetUserInfo returns UserInfo from the current repository
+func (s *SwappableRepository) GetUserInfo(ctx context.Context, userID UserID) (*UserInfo, error) {
+	r := s.acquire()
+	defer r.inflight.Done()
+	return r.GetUserInfo(ctx, userID)
+}
+
+// GetUserInfos returns UserInfos from the current repository
+func (s *SwappableRepository) GetUserInfos(ctx context.Context, userIDs ...UserID) ([]*UserInfo, error) {
+	r := s.acquire()
+	defer r.inflight.Done()
+	return r.GetUserInfos(ctx, userIDs...)
+}
+
+// AddRecord adds the record to the current repository
+func (s *SwappableRepository) AddRecord(ctx context.Context, record *Record) error {
+	r := s.acquire()
+	defer r.inflight.Done()
+	return r.AddRecord(ctx, record)
+}
+
+// BulkAddRecords adds records to the current repository
+func (s *SwappableRepository) BulkAddRecords(ctx context.Context, records []*Record) error {
+	r := s.acquire()
+	defer r.inflight.Done()
+	return r.BulkAddRecords(ctx, records)
+}
+
+// MergeUserIPs merges users' IPs into the current repository
+func (s *SwappableRepository) MergeUserIPs(ctx context.Context, users UserIPs) error {
+	r := s.acquire()
+	defer r.inflight.Done()
+	return r.MergeUserIPs(ctx, users)
+}
+
+// ForEachUser iterates users of the current repository
+func (s *SwappableRepository) ForEachUser(ctx context.Context, fn func(info *UserInfo) error) error {
+	r := s.acquire()
+	defer r.inflight.Done()
+	return r.ForEachUser(ctx, fn)
+}
+
+// ForEachUserInRange iterates users of the current repository in the range
+func (s *SwappableRepository) ForEachUserInRange(ctx context.Context, from, to UserID, fn func(info *UserInfo) error) error {
+	r := s.acquire()
+	defer r.inflight.Done()
+	return r.ForEachUserInRange(ctx, from, to, fn)
+}
+
+// StorageStats returns storage stats of the current repository
+func (s *SwappableRepository) StorageStats(ctx context.Context) (*StorageStats, error) {
+	r := s.acquire()
+	defer r.inflight.Done()
+	return r.StorageStats(ctx)
+}
+
+// DeleteUser deletes the user from the current repository
+func (s *SwappableRepository) DeleteUser(ctx context.Context, userID UserID, ref string) (bool, error) {
+	r := s.acquire()
+	defer r.inflight.Done()
+	return r.DeleteUser(ctx, userID, ref)
+}
+
+// DeleteUsers deletes users from the current repository
+func (s *SwappableRepository) DeleteUsers(ctx context.Context, userIDs []UserID, ref string) (int, error) {
+	r := s.acquire()
+	defer r.inflight.Done()
+	return r.DeleteUsers(ctx, userIDs, ref)
+}
+
+// Purge purges expired IPs of the current repository
+func (s *SwappableRepository) Purge(ctx context.Context, olderThan time.Time) (*Purged, error) {
+	r := s.acquire()
+	defer r.inflight.Done()
+	return r.Purge(ctx, olderThan)
+}
+
+// Clean cleans the current repository
+func (s *SwappableRepository) Clean(ctx context.Context) error {
+	r := s.acquire()
+	defer r.inflight.Done()
+	return r.Clean(ctx)
+}
@@ -0,0 +1,101 @@
+package record
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingRepository blocks reads until release is closed
+type blockingRepository struct {
+	Repository
+	id      UserID
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingRepository) GetUserInfo(ctx context.Context, userID UserID) (*UserInfo, error) {
+	if b.started != nil {
+		b.started <- struct{}{}
+		<-b.release
+	}
+	return &UserInfo{UserID: b.id}, nil
+}
+
+func (b *blockingRepository) GetUserInfos(ctx context.Context, userIDs ...UserID) ([]*UserInfo, error) {
+	infos := make([]*UserInfo, len(userIDs))
+	for i, uID := range userIDs {
+		infos[i], _ = b.GetUserInfo(ctx, uID)
+	}
+	return infos, nil
+}
+
+func TestSwappableRepository(t *testing.T) {
+	old := &blockingRepository{id: 1, started: make(chan struct{}), release: make(chan struct{})}
+	s := NewSwappableRepository(old)
+
+	inflight := make(chan *UserInfo)
+	go func() {
+		info, _ := s.GetUserInfo(context.Background(), 1)
+		inflight <- info
+	}()
+	<-old.started
+
+	swapped := make(chan Repository)
+	go func() { swapped <- s.Swap(&blockingRepository{id: 2}) }()
+
+	// new requests are served by the new repository while the previous one drains
+	require.Eventually(t, func() bool {
+		info, err := s.GetUserInfo(context.Background(), 1)
+		return err == nil && info.UserID == 2
+	}, time.Second, time.Millisecond)
+	select {
+	case <-swapped:
+		t.Fatal("swap returned before in-flight request was finished")
+	default:
+	}
+
+	close(old.release)
+	assert.Equal(t, UserID(1), (<-inflight).UserID)
+	assert.Equal(t, old, <-swapped)
+}
+
+func TestSwappableRepository_OnSwap(t *testing.T) {
+	s := NewSwappableRepository(&blockingRepository{id: 1})
+	c := NewCachedRepository(s, 1<<20, 0)
+	s.OnSwap(c.Reset)
+	ctx := context.Background()
+
+	info, err := c.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, UserID(1), info.UserID)
+
+	s.Swap(&blockingRepository{id: 2})
+	info, err = c.GetUserInfo(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, UserID(2), info.UserID, "users of the swapped repository aren't served from the cache")
+}
+
+func TestSwappableRepository_Hold(t *testing.T) {
+	old := &blockingRepository{id: 1}
+	s := NewSwappableRepository(old)
+	release := s.Hold()
+
+	swapped := make(chan Repository)
+	go func() { swapped <- s.Swap(&blockingRepository{id: 2}) }()
+	require.Eventually(t, func() bool {
+		info, err := s.GetUserInfo(context.Background(), 1)
+		return err == nil && info.UserID == 2
+	}, time.Second, time.Millisecond)
+	select {
+	case <-swapped:
+		t.Fatal("swap returned before the hold was released")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	release()
+	assert.Equal(t, old, <-swapped)
+}
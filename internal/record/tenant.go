@@ -0,0 +1,97 @@
+package record
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// Tenant names a separate user id space. Every tenant's users are kept in its own bucket,
+// and a repository is bound to a single bucket, so it never touches users of another tenant
+type Tenant string
+
+// DefaultTenant is the tenant of the store created before tenants, its users are kept in USER_INFO bucket
+const DefaultTenant Tenant = ""
+
+// tenantBucketPrefix starts names of tenants' buckets. Tenant names can't contain the separator,
+// so a bucket name is never shared by two tenants
+const tenantBucketPrefix = bucketName + ":"
+
+var tenantRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Tenant errors
+var (
+	ErrInvalidTenant = errors.New("tenant name should be 1-63 lowercase letters, digits, _ or - starting with a letter or digit")
+	ErrUnknownTenant = errors.New("unknown tenant")
+)
+
+// Validate returns ErrInvalidTenant unless the tenant is the default one or a valid name
+func (t Tenant) Validate() error {
+	if t != DefaultTenant && !tenantRe.MatchString(string(t)) {
+		return errors.Wrapf(ErrInvalidTenant, "tenant %q", string(t))
+	}
+	return nil
+}
+
+func (t Tenant) bucket() string {
+	if t == DefaultTenant {
+		return bucketName
+	}
+	return tenantBucketPrefix + string(t)
+}
+
+// NewTenantRepository is NewBoltRepository of the tenant, its bucket is created if it doesn't exist
+func NewTenantRepository(db *bolt.DB, tenant Tenant) (Repository, error) {
+	if err := tenant.Validate(); err != nil {
+		return nil, err
+	}
+	r := newBoltRepository(db, tenant)
+	if err := db.Update(initSchema); err != nil {
+		return nil, err
+	}
+	if err := r.createBucketIfNotExists(r.BKT); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// OpenTenantRepository is OpenBoltRepository of the tenant, ErrUnknownTenant is returned if its bucket doesn't exist
+func OpenTenantRepository(db *bolt.DB, tenant Tenant) (Repository, error) {
+	if err := tenant.Validate(); err != nil {
+		return nil, err
+	}
+	r := newBoltRepository(db, tenant)
+	err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(r.BKT)) == nil {
+			if tenant == DefaultTenant {
+				return errors.Errorf("bucket %s doesn't exist", r.BKT)
+			}
+			return errors.Wrapf(ErrUnknownTenant, "tenant %s", tenant)
+		}
+		s, err := readSchema(tx)
+		if err != nil {
+			return err
+		}
+		return checkSchema(s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Tenants returns names of tenants kept in the store in order, the default one isn't listed
+func Tenants(db *bolt.DB) ([]Tenant, error) {
+	var tenants []Tenant
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if strings.HasPrefix(string(name), tenantBucketPrefix) {
+				tenants = append(tenants, Tenant(strings.TrimPrefix(string(name), tenantBucketPrefix)))
+			}
+			return nil
+		})
+	})
+	return tenants, err
+}
@@ -0,0 +1,60 @@
+package record
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TenantHeader selects the tenant of a request to a path of the default tenant
+const TenantHeader = "X-Tenant"
+
+// tenantScoped are prefixes of paths served per tenant, X-Tenant header of other paths is ignored
+var tenantScoped = []string{"/duples/", "/records", "/users/"}
+
+// TenantPath returns the prefix of paths of the tenant's handlers. They should be registered
+// in a group of this path for every served tenant, so handlers are bound to the tenant's service
+func TenantPath(tenant Tenant) string {
+	return "/t/" + string(tenant)
+}
+
+// TenantHeaderMiddleware serves a request with X-Tenant header by the tenant's handlers, so /duples/1/2
+// with `X-Tenant: acme` is the same as /t/acme/duples/1/2. A request of a tenant which isn't served gets 404,
+// the header of a tenant's path must match it. It should be used by the router before handlers are registered
+func TenantHeaderMiddleware(router *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant := Tenant(c.GetHeader(TenantHeader))
+		if tenant == DefaultTenant {
+			return
+		}
+		if err := tenant.Validate(); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		path := c.Request.URL.Path
+		if strings.HasPrefix(path, "/t/") {
+			// the rewritten request or a tenant's path
+			if path != TenantPath(tenant) && !strings.HasPrefix(path, TenantPath(tenant)+"/") {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "tenant of the path doesn't match " + TenantHeader})
+			}
+			return
+		}
+		if !scoped(path) {
+			return
+		}
+		c.Request.URL.Path = TenantPath(tenant) + path
+		c.Request.URL.RawPath = ""
+		router.HandleContext(c)
+		c.Abort()
+	}
+}
+
+func scoped(path string) bool {
+	for _, p := range tenantScoped {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
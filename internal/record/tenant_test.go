@@ -0,0 +1,105 @@
+package record
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mullakhmetov/duplicates-checker/internal/fsck"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenant_Validate(t *testing.T) {
+	for _, tenant := range []Tenant{DefaultTenant, "acme", "a", "0-product_2"} {
+		assert.NoError(t, tenant.Validate(), tenant)
+	}
+	for _, tenant := range []Tenant{"Acme", "-acme", "acme:b", "a/b", "../acme", "acme\x00", Tenant(make([]byte, 64))} {
+		assert.Equal(t, ErrInvalidTenant, errors.Cause(tenant.Validate()), tenant)
+	}
+}
+
+func TestTenantRepository_Isolation(t *testing.T) {
+	def, db, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+
+	acme, err := NewTenantRepository(db, "acme")
+	require.NoError(t, err)
+	beta, err := NewTenantRepository(db, "beta")
+	require.NoError(t, err)
+	_, err = NewTenantRepository(db, "Acme")
+	assert.Equal(t, ErrInvalidTenant, errors.Cause(err))
+
+	// the same user ids in every tenant
+	require.NoError(t, def.BulkAddRecords(ctx, []*Record{NewRecord(1, "1.1.1.1"), NewRecord(2, "1.1.1.1")}))
+	require.NoError(t, acme.BulkAddRecords(ctx, []*Record{NewRecord(1, "2.2.2.2")}))
+	require.NoError(t, acme.AddRecord(ctx, NewRecord(3, "3.3.3.3")))
+	require.NoError(t, beta.MergeUserIPs(ctx, UserIPs{1: {4: 0}}))
+
+	ips := func(repo Repository, uID UserID) IPSet {
+		info, err := repo.GetUserInfo(ctx, uID)
+		require.NoError(t, err)
+		return info.IPs
+	}
+	users := func(repo Repository) []UserID {
+		var ids []UserID
+		require.NoError(t, repo.ForEachUser(ctx, func(info *UserInfo) error {
+			ids = append(ids, info.UserID)
+			return nil
+		}))
+		return ids
+	}
+	assert.Equal(t, NewIPSet(net.ParseIP("1.1.1.1")), ips(def, 1))
+	assert.Equal(t, NewIPSet(net.ParseIP("2.2.2.2")), ips(acme, 1))
+	assert.Equal(t, NewIPSet(net.ParseIP("0.0.0.4")), ips(beta, 1))
+	assert.Equal(t, []UserID{1, 2}, users(def))
+	assert.Equal(t, []UserID{1, 3}, users(acme))
+	assert.Equal(t, []UserID{1}, users(beta))
+
+	n, err := acme.DeleteUsers(ctx, []UserID{1, 2}, "ticket-1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Empty(t, ips(acme, 1))
+	assert.NotEmpty(t, ips(def, 1))
+	assert.NotEmpty(t, ips(def, 2))
+	assert.NotEmpty(t, ips(beta, 1))
+	entries, err := Audit(db)
+	require.NoError(t, err)
+	assert.Equal(t, Tenant("acme"), entries[0].Tenant)
+
+	res, err := beta.Purge(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, &Purged{Users: 1, IPs: 1}, res)
+	assert.Equal(t, []UserID{1, 2}, users(def))
+	assert.Equal(t, []UserID{3}, users(acme))
+
+	require.NoError(t, acme.Clean(ctx))
+	assert.Equal(t, []UserID{1, 2}, users(def))
+
+	tenants, err := Tenants(db)
+	require.NoError(t, err)
+	assert.Equal(t, []Tenant{"beta"}, tenants)
+	_, err = OpenTenantRepository(db, "acme")
+	assert.Equal(t, ErrUnknownTenant, errors.Cause(err))
+	_, err = OpenTenantRepository(db, "beta")
+	assert.NoError(t, err)
+}
+
+func TestTenantRepository_Check(t *testing.T) {
+	_, db, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+
+	acme, err := NewTenantRepository(db, "acme")
+	require.NoError(t, err)
+	require.NoError(t, acme.AddRecord(ctx, NewRecord(1, "1.1.1.1")))
+
+	report, err := fsck.Run(db, false, Check())
+	require.NoError(t, err)
+	assert.Equal(t, fsck.Clean, report.Status)
+	assert.Equal(t, 1, report.Checked["USER_INFO:acme"])
+	assert.Empty(t, report.Unknown)
+}
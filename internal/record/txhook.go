@@ -0,0 +1,34 @@
+package record
+
+import (
+	"context"
+
+	"github.com/boltdb/bolt"
+)
+
+// TxHook writes within the transaction merging users' IPs
+type TxHook func(tx *bolt.Tx) error
+
+type txHooksKey struct{}
+
+// WithTxHook returns ctx which makes MergeUserIPs and BulkAddRecords of a bolt store call hook before
+// their transaction is committed. Whatever hook writes is committed along with the users or not at all,
+// an error of hook rolls the whole write back. Stores without transactions never call hook
+func WithTxHook(ctx context.Context, hook TxHook) context.Context {
+	hooks := txHooks(ctx)
+	return context.WithValue(ctx, txHooksKey{}, append(hooks[:len(hooks):len(hooks)], hook))
+}
+
+func txHooks(ctx context.Context) []TxHook {
+	hooks, _ := ctx.Value(txHooksKey{}).([]TxHook)
+	return hooks
+}
+
+func runTxHooks(ctx context.Context, tx *bolt.Tx) error {
+	for _, hook := range txHooks(ctx) {
+		if err := hook(tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package record
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/internal/fsck"
+	"github.com/pkg/errors"
+)
+
+// Check returns the check of users buckets of all tenants and the schema: every key is made by getKey of
+// a valid user id, every value is decoded, belongs to the user of its key and has IPs. On repair a missing
+// default tenant's bucket is created, values of another user are rewritten under the key's user and other
+// broken entries are removed. The schema of unexpected version isn't repaired, the store should be migrated.
+// Users of such a store aren't checked at all, since their values have the layout of another version
+func Check() fsck.Check {
+	return fsck.Check{
+		Buckets:  []string{bucketName, metaBucketName},
+		Prefixes: []string{tenantBucketPrefix},
+		Run: func(tx *bolt.Tx, repair bool, r *fsck.Report) error {
+			s, err := readSchema(tx)
+			if err == nil {
+				err = checkSchema(s)
+			}
+			if err != nil {
+				r.Add(metaBucketName, schemaKey, err.Error(), false)
+				return nil
+			}
+			if err := checkUsers(tx, bucketName, repair, r); err != nil {
+				return err
+			}
+			var tenants []string
+			err = tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+				if strings.HasPrefix(string(name), tenantBucketPrefix) {
+					tenants = append(tenants, string(name))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, name := range tenants {
+				if err := checkUsers(tx, name, repair, r); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func checkUsers(tx *bolt.Tx, bucketName string, repair bool, r *fsck.Report) error {
+	bkt := tx.Bucket([]byte(bucketName))
+	if bkt == nil {
+		r.Add(bucketName, "", "bucket doesn't exist", repair)
+		if !repair {
+			return nil
+		}
+		_, err := tx.CreateBucket([]byte(bucketName))
+		return err
+	}
+
+	var broken [][]byte
+	rebuilt := make(map[string][]byte)
+	err := bkt.ForEach(func(k, v []byte) error {
+		r.Checked[bucketName]++
+		name, userID, err := checkUserKey(k)
+		if err == nil && v == nil {
+			err = errors.New("unexpected nested bucket")
+		}
+		info := boltUserInfo{}
+		if err == nil {
+			if e := json.Unmarshal(v, &info); e != nil {
+				err = errors.Wrap(e, "failed to decode")
+			} else if len(info.LastSeen) == 0 {
+				err = errors.New("no IPs")
+			}
+		}
+		if err != nil {
+			r.Add(bucketName, name, err.Error(), repair)
+			broken = append(broken, fsck.Copy(k))
+			return nil
+		}
+
+		if info.UserID != userID {
+			r.Add(bucketName, name, fmt.Sprintf("value of user %d", info.UserID), repair)
+			info.UserID = userID
+			buf, err := json.Marshal(&info)
+			if err != nil {
+				return err
+			}
+			rebuilt[string(k)] = buf
+		}
+		return nil
+	})
+	if err != nil || !repair {
+		return err
+	}
+
+	if err := fsck.Remove(bkt, broken); err != nil {
+		return err
+	}
+	for k, v := range rebuilt {
+		if err := bkt.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkUserKey returns printable key and user id of the key made by getKey
+func checkUserKey(k []byte) (string, UserID, error) {
+	if len(k) != 8 {
+		return hex.EncodeToString(k), 0, errors.Errorf("key of %d bytes, expected 8", len(k))
+	}
+	id := binary.BigEndian.Uint64(k)
+	name := fmt.Sprint(id)
+	if id == 0 {
+		return name, 0, ErrZeroUserID
+	}
+	if id > math.MaxUint32 {
+		return name, 0, errors.Errorf("user id overflows %d", uint32(math.MaxUint32))
+	}
+	return name, UserID(id), nil
+}
@@ -0,0 +1,90 @@
+package record
+
+import (
+	"context"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/internal/fsck"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	r, db, teardown := prepBoltRepo(t)
+	defer teardown()
+	ctx := context.Background()
+
+	require.NoError(t, r.BulkAddRecords(ctx, []*Record{NewRecord(1, "1.1.1.1"), NewRecord(2, "2.2.2.2")}))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucketName))
+		require.NoError(t, bkt.Put(getKey(3), []byte(`{"UserID":3,"LastSeen":{`)))
+		require.NoError(t, bkt.Put(getKey(4), []byte(`{"UserID":4,"LastSeen":{}}`)))
+		require.NoError(t, bkt.Put(getKey(5), []byte(`{"UserID":1,"LastSeen":{"84215045":1579082400}}`)))
+		require.NoError(t, bkt.Put([]byte{1, 2}, []byte(`{}`)))
+		require.NoError(t, bkt.Put(getKey(0), []byte(`{}`)))
+		return bkt.Put([]byte{0, 0, 0, 1, 0, 0, 0, 0}, []byte(`{}`))
+	}))
+
+	_, err := r.GetUserInfo(ctx, 3)
+	assert.EqualError(t, err, "failed to decode user 3 info, check the store with verify-db: unexpected end of JSON input")
+
+	report, err := fsck.Run(db, false, Check())
+	require.NoError(t, err)
+	assert.Equal(t, fsck.Broken, report.Status)
+	assert.Equal(t, 8, report.Checked[bucketName])
+	assert.Equal(t, []fsck.Problem{
+		{Bucket: bucketName, Key: "0", Error: "zero user id"},
+		{Bucket: bucketName, Key: "3", Error: "failed to decode: unexpected end of JSON input"},
+		{Bucket: bucketName, Key: "4", Error: "no IPs"},
+		{Bucket: bucketName, Key: "5", Error: "value of user 1"},
+		{Bucket: bucketName, Key: "4294967296", Error: "user id overflows 4294967295"},
+		{Bucket: bucketName, Key: "0102", Error: "key of 2 bytes, expected 8"},
+	}, report.Problems)
+
+	report, err = fsck.Run(db, true, Check())
+	require.NoError(t, err)
+	assert.Equal(t, fsck.Repaired, report.Status)
+
+	report, err = fsck.Run(db, false, Check())
+	require.NoError(t, err)
+	assert.Equal(t, fsck.Clean, report.Status)
+	assert.Equal(t, 3, report.Checked[bucketName])
+	info, err := r.GetUserInfo(ctx, 5)
+	require.NoError(t, err)
+	assert.Equal(t, UserID(5), info.UserID)
+	assert.Equal(t, "5.5.5.5", info.IPs.IP(0).String())
+
+	// missing bucket is created on repair
+	require.NoError(t, r.Clean(ctx))
+	report, err = fsck.Run(db, true, Check())
+	require.NoError(t, err)
+	assert.Equal(t, []fsck.Problem{{Bucket: bucketName, Error: "bucket doesn't exist", Repaired: true}}, report.Problems)
+	_, err = OpenBoltRepository(db)
+	assert.NoError(t, err)
+}
+
+func TestCheck_OldSchema(t *testing.T) {
+	_, db, teardown := prepBoltRepo(t)
+	defer teardown()
+
+	// users of the store created before versioning aren't broken, they're of another layout
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucketName))
+		require.NoError(t, bkt.Put(getKey(1), []byte(`{"UserID":1,"IPset":{"16843009":true}}`)))
+		return tx.DeleteBucket([]byte(metaBucketName))
+	}))
+	report, err := fsck.Run(db, true, Check())
+	require.NoError(t, err)
+	assert.Equal(t, fsck.Broken, report.Status)
+	assert.Equal(t, []fsck.Problem{{Bucket: metaBucketName, Key: schemaKey,
+		Error: "store schema version is 0, expected 2, run migrate command to upgrade it: unexpected store schema version"}}, report.Problems)
+
+	_, err = Migrate(context.Background(), db, false)
+	require.NoError(t, err)
+	r, err := OpenBoltRepository(db)
+	require.NoError(t, err)
+	info, err := r.GetUserInfo(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, "1.1.1.1", info.IPs.IP(0).String())
+}
@@ -0,0 +1,35 @@
+package stats
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterHandlers register stats handlers in router
+func RegisterHandlers(r *gin.Engine, service *Service) {
+	res := resource{service}
+
+	r.GET("/admin/stats", res.Get)
+}
+
+type resource struct {
+	service *Service
+}
+
+// Get computes stats, with ?cached=1 the last computed ones are returned
+func (r resource) Get(c *gin.Context) {
+	cached := c.Query("cached") == "1" || c.Query("cached") == "true"
+	s, err := r.service.Get(c, cached)
+	if err == ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] failed to get stats: %+v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get stats"})
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
@@ -0,0 +1,84 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+	"github.com/mullakhmetov/duplicates-checker/internal/fsck"
+	"github.com/pkg/errors"
+)
+
+const (
+	bucketName = "METADATA"
+	statsKey   = "stats"
+)
+
+// ErrNotFound is returned when no stats are cached yet
+var ErrNotFound = errors.New("stats are not computed yet")
+
+// Repository caches the last computed stats
+type Repository interface {
+	Get(ctx context.Context) (*Stats, error)
+	Save(ctx context.Context, s *Stats) error
+}
+
+type boltRepository struct {
+	DB  *bolt.DB
+	BKT string
+}
+
+// Get returns cached stats or ErrNotFound
+func (b *boltRepository) Get(ctx context.Context) (*Stats, error) {
+	s := &Stats{}
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(b.BKT))
+		if bkt == nil {
+			return ErrNotFound
+		}
+		v := bkt.Get([]byte(statsKey))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save replaces cached stats
+func (b *boltRepository) Save(ctx context.Context, s *Stats) error {
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(b.BKT)).Put([]byte(statsKey), buf)
+	})
+}
+
+// Check returns the check of cached stats, undecodable ones are removed on repair
+func Check() fsck.Check {
+	return fsck.JSONKeyCheck(bucketName, statsKey, func() interface{} { return &Stats{} })
+}
+
+// NewBoltRepository makes boltdb Repository implementation. The bucket is created
+// unless db is read-only, Save fails for read-only db
+func NewBoltRepository(db *bolt.DB) (Repository, error) {
+	r := boltRepository{db, bucketName}
+	if db.IsReadOnly() {
+		return &r, nil
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, e := tx.CreateBucketIfNotExists([]byte(r.BKT)); e != nil {
+			return errors.Wrapf(e, "failed to create bucket %s", r.BKT)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
@@ -0,0 +1,49 @@
+package stats
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+)
+
+// Service computes stats of the records repository caching the last result
+type Service struct {
+	records record.Repository
+	cache   Repository
+	save    bool
+
+	mu sync.Mutex
+}
+
+// NewService returns Service of records. Computed stats are saved to cache unless save is false,
+// cache may be nil if stats are never cached
+func NewService(records record.Repository, cache Repository, save bool) *Service {
+	return &Service{records: records, cache: cache, save: save && cache != nil}
+}
+
+// Get returns the last computed stats if cached is true, otherwise computes them.
+// Only one computation runs at a time, it's a full scan of the store
+func (s *Service) Get(ctx context.Context, cached bool) (*Stats, error) {
+	if cached {
+		if s.cache == nil {
+			return nil, ErrNotFound
+		}
+		return s.cache.Get(ctx)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res, err := Compute(ctx, s.records)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] stats of %d users are computed in %s", res.Users, res.Elapsed)
+	if s.save {
+		if err := s.cache.Save(ctx, res); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
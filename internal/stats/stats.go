@@ -0,0 +1,131 @@
+package stats
+
+import (
+	"context"
+	"encoding/binary"
+	"math/bits"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+)
+
+// topSharedIPs is how many of the most shared IPs are reported
+const topSharedIPs = 10
+
+// Stats describes the dataset kept in the store
+type Stats struct {
+	Users   uint64 `json:"users"`
+	UserIPs uint64 `json:"user_ips"`
+	IPs     uint64 `json:"ips"`
+
+	IPsPerUser Histogram  `json:"ips_per_user"`
+	UsersPerIP Histogram  `json:"users_per_ip"`
+	TopShared  []SharedIP `json:"top_shared_ips"`
+
+	Storage *record.StorageStats `json:"storage"`
+
+	ComputedAt time.Time     `json:"computed_at"`
+	Elapsed    time.Duration `json:"elapsed"`
+}
+
+// SharedIP is an IP with the number of its users
+type SharedIP struct {
+	IP    string `json:"ip"`
+	Users uint64 `json:"users"`
+}
+
+// Histogram counts values in power of two buckets: 1, 2, 3-4, 5-8 and so on. Empty buckets are omitted
+type Histogram []HistogramBucket
+
+// HistogramBucket counts values from Min to Max inclusive
+type HistogramBucket struct {
+	Min   uint64 `json:"min"`
+	Max   uint64 `json:"max"`
+	Count uint64 `json:"count"`
+}
+
+type histogram []uint64
+
+// add accounts a positive value
+func (h *histogram) add(v uint64) {
+	i := bits.Len64(v - 1)
+	for len(*h) <= i {
+		*h = append(*h, 0)
+	}
+	(*h)[i]++
+}
+
+func (h histogram) buckets() Histogram {
+	res := Histogram{}
+	for i, n := range h {
+		if n == 0 {
+			continue
+		}
+		b := HistogramBucket{Min: 1, Max: 1 << uint(i), Count: n}
+		if i > 0 {
+			b.Min = 1<<uint(i-1) + 1
+		}
+		res = append(res, b)
+	}
+	return res
+}
+
+// Compute scans all users of the repository. Users per IP are counted in memory,
+// it takes a few bytes per distinct IP
+func Compute(ctx context.Context, repo record.Repository) (*Stats, error) {
+	start := time.Now()
+	s := &Stats{}
+	var ipsPerUser histogram
+	usersPerIP := make(map[uint32]uint64)
+	err := repo.ForEachUser(ctx, func(info *record.UserInfo) error {
+		s.Users++
+		s.UserIPs += uint64(len(info.IPs))
+		if len(info.IPs) > 0 {
+			ipsPerUser.add(uint64(len(info.IPs)))
+		}
+		for _, ip := range info.IPs {
+			usersPerIP[ip]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var perIP histogram
+	top := make([]uint32, 0, len(usersPerIP))
+	for ip, n := range usersPerIP {
+		perIP.add(n)
+		top = append(top, ip)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if usersPerIP[top[i]] != usersPerIP[top[j]] {
+			return usersPerIP[top[i]] > usersPerIP[top[j]]
+		}
+		return top[i] < top[j]
+	})
+	if len(top) > topSharedIPs {
+		top = top[:topSharedIPs]
+	}
+	s.TopShared = make([]SharedIP, 0, len(top))
+	for _, k := range top {
+		if usersPerIP[k] < 2 {
+			break
+		}
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, k)
+		s.TopShared = append(s.TopShared, SharedIP{IP: ip.String(), Users: usersPerIP[k]})
+	}
+
+	s.IPs = uint64(len(usersPerIP))
+	s.IPsPerUser = ipsPerUser.buckets()
+	s.UsersPerIP = perIP.buckets()
+	if s.Storage, err = repo.StorageStats(ctx); err != nil {
+		return nil, err
+	}
+	s.ComputedAt = time.Now().UTC()
+	s.Elapsed = time.Since(start)
+	return s, nil
+}
@@ -0,0 +1,133 @@
+package stats
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/gin-gonic/gin"
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDb = "/tmp/test_stats.db"
+
+func TestCompute(t *testing.T) {
+	repo, _, teardown := prepRepos(t)
+	defer teardown()
+
+	s, err := Compute(context.Background(), repo)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), s.Users)
+	assert.Equal(t, uint64(9), s.UserIPs)
+	assert.Equal(t, uint64(4), s.IPs)
+	assert.Equal(t, Histogram{{1, 1, 1}, {2, 2, 2}, {3, 4, 1}}, s.IPsPerUser)
+	assert.Equal(t, Histogram{{1, 1, 1}, {2, 2, 2}, {3, 4, 1}}, s.UsersPerIP)
+	assert.Equal(t, []SharedIP{{"127.0.0.1", 4}, {"127.0.0.2", 2}, {"127.0.0.3", 2}}, s.TopShared)
+	assert.True(t, s.Storage.FileSize > 0)
+	assert.Contains(t, s.Storage.Buckets, "USER_INFO")
+	assert.Equal(t, 4, s.Storage.Buckets["USER_INFO"].KeyN)
+}
+
+func TestCompute_Cancelled(t *testing.T) {
+	repo, _, teardown := prepRepos(t)
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Compute(ctx, repo)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestHistogram(t *testing.T) {
+	var h histogram
+	for _, v := range []uint64{1, 2, 3, 4, 5, 8, 9, 1000} {
+		h.add(v)
+	}
+	assert.Equal(t, Histogram{{1, 1, 1}, {2, 2, 1}, {3, 4, 2}, {5, 8, 2}, {9, 16, 1}, {513, 1024, 1}}, h.buckets())
+}
+
+func TestService_Cache(t *testing.T) {
+	repo, cache, teardown := prepRepos(t)
+	defer teardown()
+	ctx := context.Background()
+
+	_, err := NewService(repo, cache, true).Get(ctx, true)
+	assert.Equal(t, ErrNotFound, err)
+
+	// not saved
+	_, err = NewService(repo, cache, false).Get(ctx, false)
+	require.NoError(t, err)
+	_, err = cache.Get(ctx)
+	assert.Equal(t, ErrNotFound, err)
+
+	s, err := NewService(repo, cache, true).Get(ctx, false)
+	require.NoError(t, err)
+	got, err := NewService(repo, cache, false).Get(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, s.Users, got.Users)
+	assert.Equal(t, s.TopShared, got.TopShared)
+	assert.True(t, s.ComputedAt.Equal(got.ComputedAt))
+
+	_, err = NewService(repo, nil, true).Get(ctx, true)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestAPI(t *testing.T) {
+	repo, cache, teardown := prepRepos(t)
+	defer teardown()
+	r := gin.Default()
+	RegisterHandlers(r, NewService(repo, cache, true))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/stats?cached=1", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/stats", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"users":4`)
+	assert.Contains(t, w.Body.String(), `"top_shared_ips":[{"ip":"127.0.0.1","users":4}`)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/stats?cached=1", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"users":4`)
+}
+
+// prepRepos returns records repository with the example dataset from README and stats cache
+func prepRepos(t *testing.T) (record.Repository, Repository, func()) {
+	_ = os.Remove(testDb)
+
+	db, err := record.NewBoltDB(testDb, &bolt.Options{})
+	require.NoError(t, err)
+	repo, err := record.NewBoltRepository(db)
+	require.NoError(t, err)
+	err = repo.BulkAddRecords(context.Background(), []*record.Record{
+		record.NewRecord(1, "127.0.0.1"),
+		record.NewRecord(2, "127.0.0.1"),
+		record.NewRecord(1, "127.0.0.2"),
+		record.NewRecord(2, "127.0.0.2"),
+		record.NewRecord(2, "127.0.0.3"),
+		record.NewRecord(3, "127.0.0.3"),
+		record.NewRecord(3, "127.0.0.1"),
+		record.NewRecord(4, "127.0.0.1"),
+		record.NewRecord(2, "127.0.0.4"),
+	})
+	require.NoError(t, err)
+	cache, err := NewBoltRepository(db)
+	require.NoError(t, err)
+
+	teardown := func() {
+		assert.NoError(t, db.Close())
+		_ = os.Remove(testDb)
+	}
+	return repo, cache, teardown
+}
@@ -0,0 +1,91 @@
+package syslog
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+// ErrNoRecord is returned when neither structured data nor message contain user id and ip
+var ErrNoRecord = errors.New("no access record in message")
+
+// Extractor finds access record in the message's structured data or in its text
+type Extractor struct {
+	// SDID restricts structured data lookup to the element with this ID, any element is checked if empty
+	SDID      string
+	UserParam string
+	IPParam   string
+	// MessageRegexp is applied to the message text if structured data has no record.
+	// It must have `user_id` and `ip` named groups
+	MessageRegexp *regexp.Regexp
+	userGroup     int
+	ipGroup       int
+}
+
+// NewExtractor returns Extractor, messageRegexp is optional
+func NewExtractor(sdID, userParam, ipParam, messageRegexp string) (*Extractor, error) {
+	e := &Extractor{SDID: sdID, UserParam: userParam, IPParam: ipParam}
+	if messageRegexp == "" {
+		return e, nil
+	}
+	re, err := regexp.Compile(messageRegexp)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid message regexp")
+	}
+	e.userGroup, e.ipGroup = subexpIndex(re, "user_id"), subexpIndex(re, "ip")
+	if e.userGroup < 0 || e.ipGroup < 0 {
+		return nil, errors.New("message regexp must have user_id and ip named groups")
+	}
+	e.MessageRegexp = re
+	return e, nil
+}
+
+// Extract returns validated record from the message. The first structured data element having
+// the record is used. The record is seen at the message timestamp if it has one
+func (e *Extractor) Extract(m *Message) (*record.Record, error) {
+	rec, err := e.extract(m)
+	if err != nil {
+		return nil, err
+	}
+	rec.Seen = m.Timestamp
+	return rec, nil
+}
+
+func (e *Extractor) extract(m *Message) (*record.Record, error) {
+	for _, el := range m.StructuredData {
+		if e.SDID != "" && el.ID != e.SDID {
+			continue
+		}
+		uID, okU := el.Params[e.UserParam]
+		ip, okIP := el.Params[e.IPParam]
+		if okU && okIP {
+			return parse(uID, ip)
+		}
+	}
+
+	if e.MessageRegexp != nil {
+		if match := e.MessageRegexp.FindStringSubmatch(m.Message); match != nil {
+			return parse(match[e.userGroup], match[e.ipGroup])
+		}
+	}
+	return nil, ErrNoRecord
+}
+
+func parse(uID, ip string) (*record.Record, error) {
+	id, err := strconv.ParseUint(uID, 10, 32)
+	if err != nil {
+		return nil, errors.Wrapf(ErrNoRecord, "invalid user id %q", uID)
+	}
+	return record.ParseRecord(record.UserID(id), ip)
+}
+
+func subexpIndex(re *regexp.Regexp, name string) int {
+	for i, n := range re.SubexpNames() {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
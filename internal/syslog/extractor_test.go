@@ -0,0 +1,55 @@
+package syslog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractor(t *testing.T) {
+	e, err := NewExtractor("access", "user_id", "ip", `user=(?P<user_id>\d+) from (?P<ip>[\d.]+)`)
+	require.NoError(t, err)
+
+	rec, err := e.Extract(&Message{StructuredData: []SDElement{
+		{"other", map[string]string{"user_id": "2", "ip": "2.2.2.2"}},
+		{"access", map[string]string{"user_id": "1", "ip": "1.1.1.1"}},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, record.NewRecord(1, "1.1.1.1"), rec)
+
+	// any element is checked without SDID, the first one in message order wins
+	anyElement, err := NewExtractor("", "user_id", "ip", "")
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		rec, err = anyElement.Extract(&Message{StructuredData: []SDElement{
+			{"meta", map[string]string{"ip": "4.4.4.4"}},
+			{"other", map[string]string{"user_id": "2", "ip": "2.2.2.2"}},
+			{"access", map[string]string{"user_id": "1", "ip": "1.1.1.1"}},
+		}})
+		require.NoError(t, err)
+		assert.Equal(t, record.NewRecord(2, "2.2.2.2"), rec)
+	}
+
+	rec, err = e.Extract(&Message{Message: "login user=3 from 3.3.3.3 ok"})
+	require.NoError(t, err)
+	assert.Equal(t, record.NewRecord(3, "3.3.3.3"), rec)
+
+	// the record is seen at the message timestamp
+	ts := time.Date(2003, 10, 11, 22, 14, 15, 0, time.UTC)
+	rec, err = e.Extract(&Message{Timestamp: ts, Message: "login user=3 from 3.3.3.3 ok"})
+	require.NoError(t, err)
+	assert.Equal(t, ts, rec.Seen)
+
+	_, err = e.Extract(&Message{Message: "nothing here"})
+	assert.Equal(t, ErrNoRecord, err)
+
+	_, err = e.Extract(&Message{Message: "login user=3 from 0.0.0.0"})
+	assert.Equal(t, record.ErrReservedIP, errors.Cause(err))
+
+	_, err = NewExtractor("", "user_id", "ip", `user=(\d+)`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,186 @@
+package syslog
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidMessage is returned for messages which are neither RFC 5424 nor RFC 3164
+var ErrInvalidMessage = errors.New("invalid syslog message")
+
+const nilValue = "-"
+
+// Message is a parsed syslog message. Fields missing in RFC 3164 messages are left empty,
+// structured data elements are kept in message order
+type Message struct {
+	Facility       int
+	Severity       int
+	Timestamp      time.Time
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData []SDElement
+	Message        string
+}
+
+// SDElement is a structured data element of RFC 5424 message
+type SDElement struct {
+	ID     string
+	Params map[string]string
+}
+
+// Parse parses RFC 5424 message and falls back to RFC 3164 one
+func Parse(b []byte) (*Message, error) {
+	b = bytes.TrimRight(b, "\r\n\x00")
+	pri, rest, err := parsePriority(b)
+	if err != nil {
+		return nil, err
+	}
+	m := &Message{Facility: pri / 8, Severity: pri % 8}
+
+	if len(rest) > 1 && rest[0] == '1' && rest[1] == ' ' {
+		return m, m.parse5424(rest[2:])
+	}
+	m.parse3164(rest)
+	return m, nil
+}
+
+func parsePriority(b []byte) (int, []byte, error) {
+	if len(b) < 3 || b[0] != '<' {
+		return 0, nil, errors.Wrap(ErrInvalidMessage, "no priority")
+	}
+	end := bytes.IndexByte(b[:min(len(b), 5)], '>')
+	if end < 2 {
+		return 0, nil, errors.Wrap(ErrInvalidMessage, "malformed priority")
+	}
+	pri, err := strconv.Atoi(string(b[1:end]))
+	if err != nil || pri > 191 {
+		return 0, nil, errors.Wrapf(ErrInvalidMessage, "malformed priority %q", b[1:end])
+	}
+	return pri, b[end+1:], nil
+}
+
+// TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID SP STRUCTURED-DATA [SP MSG]
+func (m *Message) parse5424(b []byte) error {
+	var fields [5]string
+	for i := range fields {
+		sp := bytes.IndexByte(b, ' ')
+		if sp < 0 {
+			return errors.Wrap(ErrInvalidMessage, "truncated header")
+		}
+		if f := string(b[:sp]); f != nilValue {
+			fields[i] = f
+		}
+		b = b[sp+1:]
+	}
+	if fields[0] != "" {
+		ts, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return errors.Wrapf(ErrInvalidMessage, "malformed timestamp %q", fields[0])
+		}
+		m.Timestamp = ts
+	}
+	m.Hostname, m.AppName, m.ProcID, m.MsgID = fields[1], fields[2], fields[3], fields[4]
+
+	rest, err := m.parseStructuredData(b)
+	if err != nil {
+		return err
+	}
+	rest = bytes.TrimPrefix(rest, []byte{' '})
+	rest = bytes.TrimPrefix(rest, []byte("\xef\xbb\xbf"))
+	m.Message = string(rest)
+	return nil
+}
+
+func (m *Message) parseStructuredData(b []byte) ([]byte, error) {
+	if len(b) > 0 && b[0] == '-' {
+		return b[1:], nil
+	}
+	for len(b) > 0 && b[0] == '[' {
+		end := bytes.IndexAny(b, " ]")
+		if end < 0 {
+			return nil, errors.Wrap(ErrInvalidMessage, "unterminated structured data")
+		}
+		params := make(map[string]string)
+		m.StructuredData = append(m.StructuredData, SDElement{ID: string(b[1:end]), Params: params})
+		b = b[end:]
+
+		for len(b) > 0 && b[0] == ' ' {
+			b = b[1:]
+			eq := bytes.IndexByte(b, '=')
+			if eq < 0 || len(b) < eq+2 || b[eq+1] != '"' {
+				return nil, errors.Wrap(ErrInvalidMessage, "malformed structured data param")
+			}
+			name := string(b[:eq])
+			value, n, err := parseParamValue(b[eq+2:])
+			if err != nil {
+				return nil, err
+			}
+			params[name] = value
+			b = b[eq+2+n:]
+		}
+		if len(b) == 0 || b[0] != ']' {
+			return nil, errors.Wrap(ErrInvalidMessage, "unterminated structured data element")
+		}
+		b = b[1:]
+	}
+	return b, nil
+}
+
+// parseParamValue reads an escaped value up to the closing quote, returns the number of consumed bytes
+func parseParamValue(b []byte) (string, int, error) {
+	var buf []byte
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			if i+1 < len(b) && (b[i+1] == '"' || b[i+1] == '\\' || b[i+1] == ']') {
+				i++
+			}
+			buf = append(buf, b[i])
+		case '"':
+			return string(buf), i + 1, nil
+		default:
+			buf = append(buf, b[i])
+		}
+	}
+	return "", 0, errors.Wrap(ErrInvalidMessage, "unterminated param value")
+}
+
+// [TIMESTAMP SP HOSTNAME SP] TAG[PID]: MSG. RFC 3164 is a description of existing practice
+// rather than a standard, so anything unrecognized is left in the message
+func (m *Message) parse3164(b []byte) {
+	const stamp = "Jan _2 15:04:05"
+	if len(b) > len(stamp) && b[len(stamp)] == ' ' {
+		if ts, err := time.Parse(stamp, string(b[:len(stamp)])); err == nil {
+			now := time.Now()
+			m.Timestamp = time.Date(now.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), 0, time.Local)
+			b = b[len(stamp)+1:]
+			if sp := bytes.IndexByte(b, ' '); sp > 0 {
+				m.Hostname = string(b[:sp])
+				b = b[sp+1:]
+			}
+		}
+	}
+
+	if colon := bytes.IndexByte(b, ':'); colon > 0 && bytes.IndexByte(b[:colon], ' ') < 0 {
+		tag := b[:colon]
+		if open := bytes.IndexByte(tag, '['); open > 0 && tag[len(tag)-1] == ']' {
+			m.ProcID = string(tag[open+1 : len(tag)-1])
+			tag = tag[:open]
+		}
+		m.AppName = string(tag)
+		b = bytes.TrimPrefix(b[colon+1:], []byte{' '})
+	}
+	m.Message = string(b)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
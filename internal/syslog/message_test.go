@@ -0,0 +1,69 @@
+package syslog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse_RFC5424(t *testing.T) {
+	m, err := Parse([]byte(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Appl\"ica\]tion"][access user_id="1" ip="1.1.1.1"] ` + "\xef\xbb\xbf" + "An application event\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, 20, m.Facility)
+	assert.Equal(t, 5, m.Severity)
+	assert.Equal(t, time.Date(2003, 10, 11, 22, 14, 15, 3e6, time.UTC), m.Timestamp)
+	assert.Equal(t, "mymachine.example.com", m.Hostname)
+	assert.Equal(t, "evntslog", m.AppName)
+	assert.Equal(t, "", m.ProcID)
+	assert.Equal(t, "ID47", m.MsgID)
+	assert.Equal(t, []SDElement{
+		{"exampleSDID@32473", map[string]string{"iut": "3", "eventSource": `Appl"ica]tion`}},
+		{"access", map[string]string{"user_id": "1", "ip": "1.1.1.1"}},
+	}, m.StructuredData)
+	assert.Equal(t, "An application event", m.Message)
+}
+
+func TestParse_RFC5424NoSD(t *testing.T) {
+	m, err := Parse([]byte(`<34>1 - - su - - - 'su root' failed`))
+	require.NoError(t, err)
+	assert.True(t, m.Timestamp.IsZero())
+	assert.Equal(t, "su", m.AppName)
+	assert.Nil(t, m.StructuredData)
+	assert.Equal(t, "'su root' failed", m.Message)
+}
+
+func TestParse_RFC3164(t *testing.T) {
+	m, err := Parse([]byte("<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick on /dev/pts/8"))
+	require.NoError(t, err)
+	assert.Equal(t, 4, m.Facility)
+	assert.Equal(t, 2, m.Severity)
+	assert.Equal(t, time.October, m.Timestamp.Month())
+	assert.Equal(t, "mymachine", m.Hostname)
+	assert.Equal(t, "su", m.AppName)
+	assert.Equal(t, "123", m.ProcID)
+	assert.Equal(t, "'su root' failed for lonvick on /dev/pts/8", m.Message)
+
+	m, err = Parse([]byte("<13>login user_id=5 ip=2.2.2.2"))
+	require.NoError(t, err)
+	assert.Equal(t, "login user_id=5 ip=2.2.2.2", m.Message)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, msg := range []string{
+		"",
+		"no priority",
+		"<>1 -",
+		"<1000>1 - - - - - -",
+		"<34>1 not-a-time - - - - -",
+		"<34>1 - - - - -",
+		`<34>1 - - - - - [sd a="1"`,
+		`<34>1 - - - - - [sd a=1]`,
+	} {
+		_, err := Parse([]byte(msg))
+		assert.Equal(t, ErrInvalidMessage, errors.Cause(err), msg)
+	}
+}
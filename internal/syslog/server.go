@@ -0,0 +1,303 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/pkg/errors"
+)
+
+const maxMessageSize = 64 * 1024
+
+// Options of syslog Server. Empty address disables the listener
+type Options struct {
+	UDPAddr       string
+	TCPAddr       string
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// Stats of received messages
+type Stats struct {
+	Received  uint64
+	Invalid   uint64
+	Committed uint64
+	Failed    uint64
+}
+
+// Server receives syslog messages over UDP and TCP and commits extracted records in batches
+// bounded by size and time
+type Server struct {
+	opts      Options
+	service   record.Service
+	extractor *Extractor
+
+	udp     net.PacketConn
+	tcp     net.Listener
+	records chan *record.Record
+	readers sync.WaitGroup
+	stats   Stats
+
+	// lastInvalid is the cause of the last invalid message, reported is the number of invalid messages logged
+	lastInvalid atomic.Value
+	reported    uint64
+}
+
+// NewServer returns Server committing records via service
+func NewServer(service record.Service, extractor *Extractor, opts Options) *Server {
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	return &Server{
+		opts:      opts,
+		service:   service,
+		extractor: extractor,
+		records:   make(chan *record.Record, opts.BatchSize),
+	}
+}
+
+// Listen binds configured addresses
+func (s *Server) Listen() (err error) {
+	if s.opts.UDPAddr != "" {
+		if s.udp, err = net.ListenPacket("udp", s.opts.UDPAddr); err != nil {
+			return errors.Wrap(err, "failed to listen udp")
+		}
+	}
+	if s.opts.TCPAddr != "" {
+		if s.tcp, err = net.Listen("tcp", s.opts.TCPAddr); err != nil {
+			if s.udp != nil {
+				s.udp.Close()
+			}
+			return errors.Wrap(err, "failed to listen tcp")
+		}
+	}
+	if s.udp == nil && s.tcp == nil {
+		return errors.New("neither udp nor tcp address is set")
+	}
+	return nil
+}
+
+// UDPAddr returns bound UDP address or nil
+func (s *Server) UDPAddr() net.Addr {
+	if s.udp == nil {
+		return nil
+	}
+	return s.udp.LocalAddr()
+}
+
+// TCPAddr returns bound TCP address or nil
+func (s *Server) TCPAddr() net.Addr {
+	if s.tcp == nil {
+		return nil
+	}
+	return s.tcp.Addr()
+}
+
+// Run serves until ctx is done, then commits everything received. Listen must be called first
+func (s *Server) Run(ctx context.Context) {
+	if s.udp != nil {
+		s.readers.Add(1)
+		go s.serveUDP()
+	}
+	if s.tcp != nil {
+		s.readers.Add(1)
+		go s.serveTCP()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.batch()
+		close(done)
+	}()
+
+	<-ctx.Done()
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	if s.tcp != nil {
+		s.tcp.Close()
+	}
+	s.readers.Wait()
+	close(s.records)
+	<-done
+
+	st := s.Stats()
+	log.Printf("[INFO] syslog server stopped. received: %d, invalid: %d, committed: %d, failed: %d",
+		st.Received, st.Invalid, st.Committed, st.Failed)
+}
+
+// Stats returns current counters
+func (s *Server) Stats() Stats {
+	return Stats{
+		Received:  atomic.LoadUint64(&s.stats.Received),
+		Invalid:   atomic.LoadUint64(&s.stats.Invalid),
+		Committed: atomic.LoadUint64(&s.stats.Committed),
+		Failed:    atomic.LoadUint64(&s.stats.Failed),
+	}
+}
+
+func (s *Server) serveUDP() {
+	defer s.readers.Done()
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, _, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		s.handle(buf[:n])
+	}
+}
+
+func (s *Server) serveTCP() {
+	defer s.readers.Done()
+	conns := sync.WaitGroup{}
+	active := make(map[net.Conn]struct{})
+	mu := sync.Mutex{}
+	defer func() {
+		// listener is closed, stop reading open connections
+		mu.Lock()
+		for conn := range active {
+			conn.Close()
+		}
+		mu.Unlock()
+		conns.Wait()
+	}()
+
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		active[conn] = struct{}{}
+		mu.Unlock()
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			defer func() {
+				mu.Lock()
+				delete(active, conn)
+				mu.Unlock()
+				conn.Close()
+			}()
+			if err := readFrames(conn, s.handle); err != nil && err != io.EOF {
+				log.Printf("[WARN] syslog connection from %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// readFrames splits TCP stream by octet counting framing (RFC 6587 3.4.1)
+// or by new lines if a frame doesn't start with a digit
+func readFrames(r io.Reader, handle func([]byte)) error {
+	br := bufio.NewReaderSize(r, maxMessageSize)
+	for {
+		first, err := br.Peek(1)
+		if err != nil {
+			return err
+		}
+		if first[0] < '0' || first[0] > '9' {
+			line, err := br.ReadSlice('\n')
+			if err == bufio.ErrBufferFull {
+				return errors.New("message is too long")
+			}
+			if len(bytes.TrimSpace(line)) > 0 {
+				handle(line)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		prefix, err := br.ReadSlice(' ')
+		if err != nil {
+			return err
+		}
+		size, err := strconv.Atoi(string(prefix[:len(prefix)-1]))
+		if err != nil || size > maxMessageSize {
+			return errors.Errorf("invalid frame length %q", prefix)
+		}
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(br, frame); err != nil {
+			return err
+		}
+		handle(frame)
+	}
+}
+
+func (s *Server) handle(b []byte) {
+	atomic.AddUint64(&s.stats.Received, 1)
+	m, err := Parse(b)
+	if err == nil {
+		var rec *record.Record
+		if rec, err = s.extractor.Extract(m); err == nil {
+			s.records <- rec
+			return
+		}
+	}
+	// messages carry users' data and may be sent by anyone, so they are counted instead of logged
+	s.lastInvalid.Store(errors.Cause(err))
+	atomic.AddUint64(&s.stats.Invalid, 1)
+}
+
+// reportInvalid logs the number of invalid messages skipped since the previous report
+func (s *Server) reportInvalid() {
+	invalid := atomic.LoadUint64(&s.stats.Invalid)
+	if invalid == s.reported {
+		return
+	}
+	log.Printf("[WARN] skip %d invalid syslog messages, the last one: %v", invalid-s.reported, s.lastInvalid.Load())
+	s.reported = invalid
+}
+
+// batch commits records when a batch is full or flush interval is passed, invalid messages are reported
+// every flush interval
+func (s *Server) batch() {
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*record.Record, 0, s.opts.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.service.BulkAddRecords(context.Background(), batch); err != nil {
+			atomic.AddUint64(&s.stats.Failed, uint64(len(batch)))
+			log.Printf("[ERROR] failed to commit %d syslog records: %+v", len(batch), err)
+		} else {
+			atomic.AddUint64(&s.stats.Committed, uint64(len(batch)))
+		}
+		batch = make([]*record.Record, 0, s.opts.BatchSize)
+	}
+
+	for {
+		select {
+		case rec, ok := <-s.records:
+			if !ok {
+				flush()
+				s.reportInvalid()
+				return
+			}
+			batch = append(batch, rec)
+			if len(batch) >= s.opts.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			s.reportInvalid()
+		}
+	}
+}
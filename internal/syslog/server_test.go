@@ -0,0 +1,137 @@
+package syslog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mullakhmetov/duplicates-checker/internal/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	ms := new(record.MockedService)
+	var mu sync.Mutex
+	var committed []string
+	var batches int
+	ms.On("BulkAddRecords", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		batches++
+		for _, rec := range args.Get(1).([]*record.Record) {
+			committed = append(committed, fmt.Sprintf("%d,%s", rec.UserID, rec.IP))
+		}
+	}).Return(nil)
+
+	e, err := NewExtractor("", "user_id", "ip", `user=(?P<user_id>\d+) ip=(?P<ip>\S+)`)
+	require.NoError(t, err)
+	s := NewServer(ms, e, Options{UDPAddr: "127.0.0.1:0", TCPAddr: "127.0.0.1:0", BatchSize: 3, FlushInterval: time.Hour})
+	require.NoError(t, s.Listen())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	udp, err := net.Dial("udp", s.UDPAddr().String())
+	require.NoError(t, err)
+	defer udp.Close()
+	_, err = udp.Write([]byte(`<14>1 2020-01-01T00:00:00Z host app - - [access user_id="1" ip="1.1.1.1"] login`))
+	require.NoError(t, err)
+	_, err = udp.Write([]byte(`<14>Jan  1 00:00:00 host app: user=2 ip=2.2.2.2`))
+	require.NoError(t, err)
+	_, err = udp.Write([]byte(`<14>Jan  1 00:00:00 host app: garbage`))
+	require.NoError(t, err)
+
+	tcp, err := net.Dial("tcp", s.TCPAddr().String())
+	require.NoError(t, err)
+	framed := `<14>1 - host app - - [access user_id="3" ip="3.3.3.3"]`
+	stream := bytes.Buffer{}
+	fmt.Fprintf(&stream, "%d %s", len(framed), framed)
+	stream.WriteString("<14>app: user=4 ip=4.4.4.4\n")
+	_, err = tcp.Write(stream.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, tcp.Close())
+
+	require.Eventually(t, func() bool { return s.Stats().Received == 5 }, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	sort.Strings(committed)
+	assert.Equal(t, []string{"1,1.1.1.1", "2,2.2.2.2", "3,3.3.3.3", "4,4.4.4.4"}, committed)
+	assert.Equal(t, 2, batches, "full batch and the rest on shutdown")
+	assert.Equal(t, Stats{Received: 5, Invalid: 1, Committed: 4}, s.Stats())
+}
+
+func TestServer_FlushInterval(t *testing.T) {
+	ms := new(record.MockedService)
+	committed := make(chan int, 1)
+	ms.On("BulkAddRecords", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		committed <- len(args.Get(1).([]*record.Record))
+	}).Return(nil)
+
+	e, err := NewExtractor("", "user_id", "ip", "")
+	require.NoError(t, err)
+	s := NewServer(ms, e, Options{UDPAddr: "127.0.0.1:0", BatchSize: 100, FlushInterval: 20 * time.Millisecond})
+	require.NoError(t, s.Listen())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	udp, err := net.Dial("udp", s.UDPAddr().String())
+	require.NoError(t, err)
+	defer udp.Close()
+	_, err = udp.Write([]byte(`<14>1 - - - - - [a user_id="1" ip="1.1.1.1"]`))
+	require.NoError(t, err)
+
+	select {
+	case n := <-committed:
+		assert.Equal(t, 1, n)
+	case <-time.After(time.Second):
+		t.Fatal("batch wasn't flushed by interval")
+	}
+}
+
+func TestReadFrames(t *testing.T) {
+	var frames []string
+	err := readFrames(bytes.NewBufferString("5 hello3 abc\nline one\r\n\nline two"), func(b []byte) {
+		frames = append(frames, string(b))
+	})
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []string{"hello", "abc", "line one\r\n", "line two"}, frames)
+
+	err = readFrames(bytes.NewBufferString("99999999 x"), func(b []byte) {})
+	assert.Error(t, err)
+}
+
+func TestServer_ReportInvalid(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	e, err := NewExtractor("", "user_id", "ip", "")
+	require.NoError(t, err)
+	s := NewServer(new(record.MockedService), e, Options{})
+	s.handle([]byte(`garbage 5.5.5.5`))
+	s.handle([]byte(`<14>1 - host app - - [access user_id="6" ip="0.0.0.0"]`))
+	s.reportInvalid()
+	s.reportInvalid()
+
+	assert.Equal(t, 1, strings.Count(buf.String(), "[WARN]"), "nothing is reported without new invalid messages")
+	assert.Contains(t, buf.String(), "skip 2 invalid syslog messages, the last one: "+record.ErrReservedIP.Error())
+	assert.NotContains(t, buf.String(), "5.5.5.5", "messages aren't logged")
+	assert.NotContains(t, buf.String(), "0.0.0.0")
+}